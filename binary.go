@@ -3,6 +3,7 @@ package historitor
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math/bits"
 )
 
@@ -31,8 +32,8 @@ func decodeUnsignedInt(b []byte) (uint64, error) {
 	if n > 8 {
 		return 0, fmt.Errorf("invalid uint data length %d", n)
 	}
-	if len(b) < n {
-		fmt.Errorf("invalid uint data length %d: exceeds input size %d", n, len(b))
+	if len(b) < n+1 {
+		return 0, fmt.Errorf("invalid uint data length %d: exceeds input size %d", n, len(b))
 	}
 
 	var x uint64
@@ -43,3 +44,32 @@ func decodeUnsignedInt(b []byte) (uint64, error) {
 	}
 	return x, nil
 }
+
+// readUnsignedInt reads an unsigned integer encoded by encodeUnsignedInt from r. It returns the integer and the number
+// of bytes read. If r is exhausted before the first byte is read, io.EOF is returned. If r is exhausted in the middle
+// of the integer, io.ErrUnexpectedEOF is returned.
+func readUnsignedInt(r io.ByteReader) (uint64, int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	b := []byte{first}
+	if first > 0x7f {
+		n := -int(int8(first))
+		if n > 8 {
+			return 0, 1, fmt.Errorf("invalid uint data length %d", n)
+		}
+		for i := 0; i < n; i++ {
+			byt, err := r.ReadByte()
+			if err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return 0, len(b), err
+			}
+			b = append(b, byt)
+		}
+	}
+	x, err := decodeUnsignedInt(b)
+	return x, len(b), err
+}
@@ -0,0 +1,34 @@
+package historitor
+
+import (
+	"sync"
+)
+
+// broadcast wakes up every goroutine waiting for it when notified. The zero value is ready for use.
+//
+// A goroutine should obtain the channel from wait before checking the condition it waits for, so a notification sent
+// after the check is not missed.
+type broadcast struct {
+	mut sync.Mutex
+	ch  chan struct{}
+}
+
+// wait returns a channel that is closed the next time notify is called.
+func (b *broadcast) wait() <-chan struct{} {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.ch == nil {
+		b.ch = make(chan struct{})
+	}
+	return b.ch
+}
+
+// notify wakes up every goroutine waiting on a channel returned by wait.
+func (b *broadcast) notify() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.ch != nil {
+		close(b.ch)
+		b.ch = nil
+	}
+}
@@ -0,0 +1,304 @@
+package historitor
+
+import (
+	"slices"
+	"sort"
+)
+
+// btreeDegree is the minimum number of children of the inner nodes of a btree other than the root. Every node other
+// than the root holds between btreeDegree-1 and 2*btreeDegree-1 entries.
+const btreeDegree = 32
+
+// btree is an in-memory B-tree holding log entries ordered by their [EntryID]. Finding an entry, or the position to
+// start iterating from, takes O(log n), as do inserting and deleting entries.
+//
+// A btree is not safe for concurrent use, but its read-only methods may be called concurrently with each other.
+type btree struct {
+	root *btreeNode
+	size int
+}
+
+// btreeNode is a node of a btree. The entries of a node are ordered by ID. An inner node has one more child than it
+// has entries, and the child i holds the entries ordered between the entries i-1 and i of the node. Leaves have no
+// children.
+type btreeNode struct {
+	entries  []Entry
+	children []*btreeNode
+}
+
+// get returns the entry with the given ID, if any.
+func (t *btree) get(id EntryID) (Entry, bool) {
+	n := t.root
+	for n != nil {
+		i, found := n.find(id)
+		if found {
+			return n.entries[i], true
+		}
+		if n.leaf() {
+			break
+		}
+		n = n.children[i]
+	}
+	return Entry{}, false
+}
+
+// set inserts e into the tree, replacing the entry with the same ID, if any.
+func (t *btree) set(e Entry) {
+	if t.root == nil {
+		t.root = &btreeNode{entries: []Entry{e}}
+		t.size++
+		return
+	}
+	if t.root.full() {
+		t.root = &btreeNode{children: []*btreeNode{t.root}}
+		t.root.split(0)
+	}
+	if t.root.insert(e) {
+		t.size++
+	}
+}
+
+// delete removes the entry with the given ID from the tree, and reports whether it existed.
+func (t *btree) delete(id EntryID) bool {
+	if t.root == nil {
+		return false
+	}
+	deleted := t.root.remove(id)
+	if len(t.root.entries) == 0 {
+		// the root shrinks when its last entry has been merged into its only child
+		if t.root.leaf() {
+			t.root = nil
+		} else {
+			t.root = t.root.children[0]
+		}
+	}
+	if deleted {
+		t.size--
+	}
+	return deleted
+}
+
+// ascend calls fn for every entry with an ID equal to or after from, in ascending order, until fn returns false.
+func (t *btree) ascend(from EntryID, fn func(e Entry) bool) {
+	if t.root != nil {
+		t.root.ascend(from, fn)
+	}
+}
+
+// descend calls fn for every entry with an ID equal to or before from, in descending order, until fn returns false.
+func (t *btree) descend(from EntryID, fn func(e Entry) bool) {
+	if t.root != nil {
+		t.root.descend(from, fn)
+	}
+}
+
+// last returns the entry with the greatest ID, if any.
+func (t *btree) last() (Entry, bool) {
+	n := t.root
+	if n == nil {
+		return Entry{}, false
+	}
+	for !n.leaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n.entries[len(n.entries)-1], true
+}
+
+// leaf reports whether n is a leaf.
+func (n *btreeNode) leaf() bool {
+	return len(n.children) == 0
+}
+
+// full reports whether n holds as many entries as a node can hold.
+func (n *btreeNode) full() bool {
+	return len(n.entries) >= 2*btreeDegree-1
+}
+
+// find returns the position of the first entry of n with an ID equal to or after id, and whether the ID of that entry
+// is id.
+func (n *btreeNode) find(id EntryID) (int, bool) {
+	i := sort.Search(len(n.entries), func(i int) bool {
+		return n.entries[i].ID.Compare(id) >= 0
+	})
+	return i, i < len(n.entries) && n.entries[i].ID.Compare(id) == 0
+}
+
+// split splits the full child i of n in two, moving the middle entry of the child to n. n must not be full.
+func (n *btreeNode) split(i int) {
+	c := n.children[i]
+	mid := btreeDegree - 1
+	right := &btreeNode{entries: slices.Clone(c.entries[mid+1:])}
+	if !c.leaf() {
+		right.children = slices.Clone(c.children[mid+1:])
+		clear(c.children[mid+1:])
+		c.children = c.children[:mid+1]
+	}
+	n.entries = slices.Insert(n.entries, i, c.entries[mid])
+	n.children = slices.Insert(n.children, i+1, right)
+	clear(c.entries[mid:])
+	c.entries = c.entries[:mid]
+}
+
+// insert inserts e into the subtree of n, replacing the entry with the same ID, if any, and reports whether e was
+// inserted rather than replacing an entry. n must not be full. Full nodes are split on the way down, so there is
+// always room for the entry moved up by a split.
+func (n *btreeNode) insert(e Entry) bool {
+	for {
+		i, found := n.find(e.ID)
+		if found {
+			n.entries[i] = e
+			return false
+		}
+		if n.leaf() {
+			n.entries = slices.Insert(n.entries, i, e)
+			return true
+		}
+		if n.children[i].full() {
+			n.split(i)
+			switch c := e.ID.Compare(n.entries[i].ID); {
+			case c == 0:
+				n.entries[i] = e
+				return false
+			case c > 0:
+				i++
+			}
+		}
+		n = n.children[i]
+	}
+}
+
+// remove removes the entry with the given ID from the subtree of n, and reports whether it existed. Unless n is the
+// root, it must hold at least btreeDegree entries, so an entry can be removed from it without merging n with its
+// siblings. The children of n are grown to that size on the way down.
+func (n *btreeNode) remove(id EntryID) bool {
+	i, found := n.find(id)
+	if n.leaf() {
+		if found {
+			n.entries = slices.Delete(n.entries, i, i+1)
+		}
+		return found
+	}
+	if !found {
+		i = n.grow(i)
+		return n.children[i].remove(id)
+	}
+	// the entry is replaced by its predecessor or successor, which is removed from the child holding it instead
+	switch {
+	case len(n.children[i].entries) >= btreeDegree:
+		c := n.children[i]
+		n.entries[i] = c.max()
+		return c.remove(n.entries[i].ID)
+	case len(n.children[i+1].entries) >= btreeDegree:
+		c := n.children[i+1]
+		n.entries[i] = c.min()
+		return c.remove(n.entries[i].ID)
+	default:
+		n.merge(i)
+		return n.children[i].remove(id)
+	}
+}
+
+// grow makes sure the child i of n holds at least btreeDegree entries, by moving an entry to it from one of its
+// siblings, or by merging it with one of its siblings. It returns the position of the child afterwards.
+func (n *btreeNode) grow(i int) int {
+	c := n.children[i]
+	if len(c.entries) >= btreeDegree {
+		return i
+	}
+	if i > 0 && len(n.children[i-1].entries) >= btreeDegree {
+		left := n.children[i-1]
+		last := len(left.entries) - 1
+		c.entries = slices.Insert(c.entries, 0, n.entries[i-1])
+		n.entries[i-1] = left.entries[last]
+		left.entries[last] = Entry{}
+		left.entries = left.entries[:last]
+		if !left.leaf() {
+			last = len(left.children) - 1
+			c.children = slices.Insert(c.children, 0, left.children[last])
+			left.children[last] = nil
+			left.children = left.children[:last]
+		}
+		return i
+	}
+	if i < len(n.entries) && len(n.children[i+1].entries) >= btreeDegree {
+		right := n.children[i+1]
+		c.entries = append(c.entries, n.entries[i])
+		n.entries[i] = right.entries[0]
+		right.entries = slices.Delete(right.entries, 0, 1)
+		if !right.leaf() {
+			c.children = append(c.children, right.children[0])
+			right.children = slices.Delete(right.children, 0, 1)
+		}
+		return i
+	}
+	if i == len(n.entries) {
+		i--
+	}
+	n.merge(i)
+	return i
+}
+
+// merge merges the entry i of n and the child i+1 of n into the child i of n.
+func (n *btreeNode) merge(i int) {
+	c, right := n.children[i], n.children[i+1]
+	c.entries = append(append(c.entries, n.entries[i]), right.entries...)
+	c.children = append(c.children, right.children...)
+	n.entries = slices.Delete(n.entries, i, i+1)
+	n.children = slices.Delete(n.children, i+1, i+2)
+}
+
+// min returns the entry of the subtree of n with the smallest ID.
+func (n *btreeNode) min() Entry {
+	for !n.leaf() {
+		n = n.children[0]
+	}
+	return n.entries[0]
+}
+
+// max returns the entry of the subtree of n with the greatest ID.
+func (n *btreeNode) max() Entry {
+	for !n.leaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n.entries[len(n.entries)-1]
+}
+
+// ascend calls fn for every entry of the subtree of n with an ID equal to or after from, in ascending order, until fn
+// returns false. It reports whether iteration should continue.
+func (n *btreeNode) ascend(from EntryID, fn func(e Entry) bool) bool {
+	i, _ := n.find(from)
+	for ; i < len(n.entries); i++ {
+		if !n.leaf() && !n.children[i].ascend(from, fn) {
+			return false
+		}
+		if !fn(n.entries[i]) {
+			return false
+		}
+	}
+	if n.leaf() {
+		return true
+	}
+	return n.children[i].ascend(from, fn)
+}
+
+// descend calls fn for every entry of the subtree of n with an ID equal to or before from, in descending order, until
+// fn returns false. It reports whether iteration should continue.
+func (n *btreeNode) descend(from EntryID, fn func(e Entry) bool) bool {
+	i, found := n.find(from)
+	if found {
+		i++
+	}
+	// the entries before i have IDs equal to or before from, as may the entries of the child i
+	if !n.leaf() && !n.children[i].descend(from, fn) {
+		return false
+	}
+	for i--; i >= 0; i-- {
+		if !fn(n.entries[i]) {
+			return false
+		}
+		if !n.leaf() && !n.children[i].descend(from, fn) {
+			return false
+		}
+	}
+	return true
+}
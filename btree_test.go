@@ -0,0 +1,148 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"github.com/stretchr/testify/require"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+// requireBTreeValid requires every node of the subtree of n, at the given depth, to hold as many entries as allowed,
+// in order and between lo and hi, and every leaf to be at leafDepth. The depth of the leaves is returned.
+func requireBTreeValid(t *testing.T, n *btreeNode, root bool, lo, hi *EntryID, depth int) int {
+	t.Helper()
+	if !root {
+		require.GreaterOrEqual(t, len(n.entries), btreeDegree-1)
+	}
+	require.LessOrEqual(t, len(n.entries), 2*btreeDegree-1)
+	require.True(t, slices.IsSortedFunc(n.entries, func(a, b Entry) int {
+		return a.ID.Compare(b.ID)
+	}))
+	if lo != nil {
+		require.Positive(t, n.entries[0].ID.Compare(*lo))
+	}
+	if hi != nil {
+		require.Negative(t, n.entries[len(n.entries)-1].ID.Compare(*hi))
+	}
+	if n.leaf() {
+		return depth
+	}
+	require.Len(t, n.children, len(n.entries)+1)
+	leafDepth := -1
+	for i, c := range n.children {
+		clo, chi := lo, hi
+		if i > 0 {
+			clo = &n.entries[i-1].ID
+		}
+		if i < len(n.entries) {
+			chi = &n.entries[i].ID
+		}
+		d := requireBTreeValid(t, c, false, clo, chi, depth+1)
+		if leafDepth >= 0 {
+			require.Equal(t, leafDepth, d)
+		}
+		leafDepth = d
+	}
+	return leafDepth
+}
+
+// TestBTree tests inserting, replacing, deleting and iterating over entries in random order against a sorted slice of
+// the IDs expected to be in the tree.
+func TestBTree(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	ti := time.Unix(0, 0).Add(time.Duration(1734467114191) * time.Millisecond).UTC()
+	var ids []EntryID
+	for ms := range 100 {
+		for seq := range uint64(100) {
+			ids = append(ids, NewEntryID(ti.Add(time.Duration(ms)*time.Millisecond), seq))
+		}
+	}
+	var tree btree
+	var want []EntryID
+	requireTree := func() {
+		t.Helper()
+		require.Equal(t, len(want), tree.size)
+		if tree.root != nil {
+			requireBTreeValid(t, tree.root, true, nil, nil, 0)
+		}
+		got := []EntryID{}
+		tree.ascend(ZeroEntryID, func(e Entry) bool {
+			got = append(got, e.ID)
+			return true
+		})
+		require.Equal(t, want, got)
+	}
+
+	// insert in random order, replacing some entries along the way
+	for _, i := range r.Perm(len(ids)) {
+		tree.set(Entry{ID: ids[i], Payload: i})
+		if i%7 == 0 {
+			tree.set(Entry{ID: ids[i], Payload: -i})
+		}
+	}
+	want = slices.Clone(ids)
+	requireTree()
+	for i, id := range ids {
+		e, ok := tree.get(id)
+		require.True(t, ok)
+		if i%7 == 0 {
+			require.Equal(t, -i, e.Payload)
+		} else {
+			require.Equal(t, i, e.Payload)
+		}
+	}
+
+	// iterate from every position, including IDs between the entries
+	for i := 0; i < len(ids); i += 137 {
+		var got []EntryID
+		tree.ascend(ids[i], func(e Entry) bool {
+			got = append(got, e.ID)
+			return len(got) < 5
+		})
+		require.Equal(t, ids[i:min(i+5, len(ids))], got)
+		got = nil
+		tree.descend(ids[i], func(e Entry) bool {
+			got = append(got, e.ID)
+			return len(got) < 5
+		})
+		wantDesc := slices.Clone(ids[max(i-4, 0) : i+1])
+		slices.Reverse(wantDesc)
+		require.Equal(t, wantDesc, got)
+	}
+	between := NewEntryID(ids[99].time, 100)
+	var got []EntryID
+	tree.ascend(between, func(e Entry) bool {
+		got = append(got, e.ID)
+		return false
+	})
+	require.Equal(t, []EntryID{ids[100]}, got)
+	got = nil
+	tree.descend(between, func(e Entry) bool {
+		got = append(got, e.ID)
+		return false
+	})
+	require.Equal(t, []EntryID{ids[99]}, got)
+	last, ok := tree.last()
+	require.True(t, ok)
+	require.Equal(t, ids[len(ids)-1], last.ID)
+
+	// delete in random order, checking the tree along the way
+	for n, i := range r.Perm(len(ids)) {
+		require.True(t, tree.delete(ids[i]))
+		require.False(t, tree.delete(ids[i]))
+		_, ok := tree.get(ids[i])
+		require.False(t, ok)
+		j, _ := slices.BinarySearchFunc(want, ids[i], EntryID.Compare)
+		want = slices.Delete(want, j, j+1)
+		if n%997 == 0 {
+			requireTree()
+		}
+	}
+	requireTree()
+	require.Nil(t, tree.root)
+	_, ok = tree.last()
+	require.False(t, ok)
+}
@@ -0,0 +1,51 @@
+package historitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+var ErrCorrupted = fmt.Errorf("corrupted data")
+
+// checksumSize is the size, in bytes, of a checksum.
+const checksumSize = 4
+
+// castagnoli is the CRC-32C table used to checksum persisted data.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// appendChecksum appends the CRC-32C checksum of data to b.
+func appendChecksum(b, data []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, crc32.Checksum(data, castagnoli))
+}
+
+// verifyChecksum reports whether sum holds the CRC-32C checksum of data.
+func verifyChecksum(data, sum []byte) bool {
+	return len(sum) == checksumSize && binary.BigEndian.Uint32(sum) == crc32.Checksum(data, castagnoli)
+}
+
+// CorruptionError is returned when persisted data, such as a snapshot or the write-ahead log, fails verification
+// against its checksum. It wraps [ErrCorrupted].
+//
+// Corrupted data can be discarded with [WithLogTruncateCorrupted], which truncates the data back to LastValid.
+type CorruptionError struct {
+	// ID is the ID of the corrupted log entry. It is the zero EntryID if the corruption prevents determining the ID,
+	// or if the corrupted data does not belong to a log entry.
+	ID EntryID
+	// LastValid is the ID of the last log entry preceding the corruption that passed verification. It is the zero
+	// EntryID if no log entry precedes the corruption.
+	LastValid EntryID
+	// Source describes where the corruption was found.
+	Source string
+}
+
+func (e *CorruptionError) Error() string {
+	if e.ID.IsZero() {
+		return fmt.Sprintf("%s: %s, following log entry %s", ErrCorrupted, e.Source, e.LastValid)
+	}
+	return fmt.Sprintf("%s: log entry %s in %s", ErrCorrupted, e.ID, e.Source)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return ErrCorrupted
+}
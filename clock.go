@@ -0,0 +1,35 @@
+package historitor
+
+import (
+	"math"
+	"time"
+)
+
+// Clock returns the current time. It is used by a [Log] to determine the time held by the IDs of new log entries, see
+// [WithLogClock].
+type Clock func() time.Time
+
+// nextEntryID returns the ID of the next log entry written to the log. The ID holds the current time of the clock of
+// the log, unless the clock is at or before the time of the last log entry, e.g. because the wall clock was stepped
+// backwards, in which case the ID holds the time of the last log entry and the sequence number following it. IDs are
+// thereby always ascending, much like in Redis Streams. It should be called with the treeMux locked.
+func (l *Log) nextEntryID() EntryID {
+	clock := l.clock
+	if clock == nil {
+		clock = time.Now
+	}
+	id := NewEntryID(clock(), 0)
+	if id.Compare(l.lastEntry) > 0 {
+		return id
+	}
+	return l.lastEntry.next()
+}
+
+// next returns the smallest EntryID following e, which has the sequence number following the one of e, or if e has
+// the largest sequence number, the following millisecond and sequence number zero.
+func (e EntryID) next() EntryID {
+	if e.seq == math.MaxUint64 {
+		return NewEntryID(e.time.Add(time.Millisecond), 0)
+	}
+	return NewEntryID(e.time, e.seq+1)
+}
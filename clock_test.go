@@ -0,0 +1,80 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock returning a time set by the test.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestLog_nextEntryID(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1734467114191)}
+	l := &Log{clock: clock.Now}
+	require.Equal(t, NewEntryID(clock.now, 0), l.nextEntryID())
+
+	l.lastEntry = NewEntryID(clock.now, 3)
+	require.Equal(t, NewEntryID(clock.now, 4), l.nextEntryID())
+
+	l.lastEntry = NewEntryID(clock.now.Add(time.Second), math.MaxUint64)
+	require.Equal(t, NewEntryID(clock.now.Add(time.Second+time.Millisecond), 0), l.nextEntryID())
+
+	clock.now = clock.now.Add(2 * time.Second)
+	require.Equal(t, NewEntryID(clock.now, 0), l.nextEntryID())
+}
+
+// TestLog_Write_clock_backwards tests that log entries written after the clock went backwards are delivered to
+// Consumer groups that already read the log entries written before.
+func TestLog_Write_clock_backwards(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1734467114191)}
+	l, err := NewLog(WithLogName(t.Name()), WithLogClock(clock.Now))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+
+	id1, err := l.Write("one")
+	require.NoError(t, err)
+	entries, err := l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	clock.now = clock.now.Add(-time.Minute)
+	id2, err := l.Write("two")
+	require.NoError(t, err)
+	require.Equal(t, NewEntryID(id1.time, id1.seq+1), id2)
+	ids, err := l.WriteBatch([]any{"three", "four"})
+	require.NoError(t, err)
+	require.Equal(t, []EntryID{NewEntryID(id1.time, id1.seq+2), NewEntryID(id1.time, id1.seq+3)}, ids)
+
+	entries, err = l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, id2, entries[0].ID)
+}
+
+// TestLog_WriteBatch_seq_rollover tests that a batch rolls over to the following millisecond rather than wrapping the
+// sequence number.
+func TestLog_WriteBatch_seq_rollover(t *testing.T) {
+	clock := &fakeClock{now: time.UnixMilli(1734467114191)}
+	l, err := NewLog(WithLogName(t.Name()), WithLogClock(clock.Now))
+	require.NoError(t, err)
+	require.NoError(t, l.WriteWithID(NewEntryID(clock.now, math.MaxUint64-2), "zero"))
+
+	ids, err := l.WriteBatch([]any{"one", "two", "three"})
+	require.NoError(t, err)
+	require.Equal(t, []EntryID{
+		NewEntryID(clock.now, math.MaxUint64-1),
+		NewEntryID(clock.now, math.MaxUint64),
+		NewEntryID(clock.now.Add(time.Millisecond), 0),
+	}, ids)
+}
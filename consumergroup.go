@@ -9,6 +9,12 @@ import (
 
 // ConsumerGroup is a group of consumers that consume log entries together.
 //
+// Once a ConsumerGroup has been added to a [Log] with the write-ahead log enabled, changes to its start at entry ID,
+// members and Pending Entries List are appended to the write-ahead log of the [Log]. As the methods of ConsumerGroup do
+// not return errors, a failure to append to the write-ahead log is kept as the sticky error of the Consumer group,
+// returned by [ConsumerGroup.Err], and is reported by the next method of the [Log] returning an error, such as
+// [Log.Read], [Log.Acknowledge] and [Log.Close].
+//
 // ConsumerGroup must not be copied.
 type ConsumerGroup struct {
 	name    string
@@ -16,6 +22,9 @@ type ConsumerGroup struct {
 	mut     sync.RWMutex
 	pel     PendingEntriesList
 	startAt EntryID
+	wal     *wal
+	// err is the error appending a change of the Consumer group to the write-ahead log first failed with, see Err.
+	err error
 }
 
 // NewConsumerGroup creates a new Consumer group with the provided options.
@@ -36,6 +45,24 @@ func NewConsumerGroup(options ...ConsumerGroupOption) *ConsumerGroup {
 	}
 }
 
+// Err returns the error appending a change of the Consumer group to the write-ahead log of its [Log] failed with, if
+// any. Once a change could not be appended, the write-ahead log no longer reflects the Consumer group, so Err keeps
+// returning the first such error.
+func (c *ConsumerGroup) Err() error {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.err
+}
+
+// journal appends rec to the write-ahead log of the Consumer group, if any, keeping the error as the sticky error of
+// the Consumer group if appending fails. It should be called with mut locked.
+func (c *ConsumerGroup) journal(rec walRecord) {
+	err := c.wal.append(rec)
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
 // GetStartAt returns the start at entry ID for the Consumer group.
 func (c *ConsumerGroup) GetStartAt() EntryID {
 	c.mut.RLock()
@@ -43,13 +70,27 @@ func (c *ConsumerGroup) GetStartAt() EntryID {
 	return c.startAt
 }
 
-// SetStartAt sets the start at entry ID for the Consumer group.
+// SetStartAt sets the start at entry ID for the Consumer group. If id is [StartFromEnd], it is resolved to the last log
+// entry in the log when the Consumer group is added to a log, see [Log.AddGroup], or if it has already been added, the
+// next time the Consumer group reads from the log.
 func (c *ConsumerGroup) SetStartAt(id EntryID) {
 	c.mut.Lock()
+	c.journal(walRecord{Op: walOpSetStartAt, Group: c.name, ID: id})
 	c.startAt = id
 	c.mut.Unlock()
 }
 
+// SetStartAtTime sets the start at entry ID for the Consumer group, such that the log entries written at or after t, as
+// recorded in their IDs, are read next, regardless of whether they have been read before. As IDs record the
+// millisecond a log entry was written, a time within a millisecond skips the log entries written in that millisecond.
+// Log entries in the Pending Entries List are not affected.
+//
+// Unlike using the ID returned by [Log.SeekTime], the start at entry ID set does not depend on the log entries in the
+// log, so t may be in the future.
+func (c *ConsumerGroup) SetStartAtTime(t time.Time) {
+	c.SetStartAt(entryIDBefore(t))
+}
+
 // GetName returns the name of the Consumer group.
 func (c *ConsumerGroup) GetName() string {
 	c.mut.RLock()
@@ -61,6 +102,7 @@ func (c *ConsumerGroup) GetName() string {
 // exists, this function overwrites it.
 func (c *ConsumerGroup) AddMember(member Consumer) {
 	c.mut.Lock()
+	c.journal(walRecord{Op: walOpAddMember, Group: c.name, Consumer: member.name})
 	c.members[member.name] = member
 	c.mut.Unlock()
 }
@@ -69,6 +111,7 @@ func (c *ConsumerGroup) AddMember(member Consumer) {
 // nothing.
 func (c *ConsumerGroup) RemoveMember(member string) {
 	c.mut.Lock()
+	c.journal(walRecord{Op: walOpRemoveMember, Group: c.name, Consumer: member})
 	delete(c.members, member)
 	c.mut.Unlock()
 }
@@ -124,16 +167,16 @@ func (c *ConsumerGroup) AddPendingEntry(id EntryID, consumer string) {
 	if exists {
 		pe.DeliveryCount++
 		pe.DeliveredAt = time.Now()
-		c.pel[id] = pe
-		c.mut.Unlock()
-		return
-	}
-	c.pel[id] = PendingEntry{
-		ID:            id,
-		Consumer:      consumer,
-		DeliveredAt:   time.Now(),
-		DeliveryCount: 1,
+	} else {
+		pe = PendingEntry{
+			ID:            id,
+			Consumer:      consumer,
+			DeliveredAt:   time.Now(),
+			DeliveryCount: 1,
+		}
 	}
+	c.journal(walRecord{Op: walOpAddPendingEntry, Group: c.name, Pending: pe})
+	c.pel[id] = pe
 	c.mut.Unlock()
 }
 
@@ -141,6 +184,7 @@ func (c *ConsumerGroup) AddPendingEntry(id EntryID, consumer string) {
 // pending entry does not exist, this function does nothing.
 func (c *ConsumerGroup) RemovePendingEntry(id EntryID) {
 	c.mut.Lock()
+	c.journal(walRecord{Op: walOpRemovePendingEntry, Group: c.name, ID: id})
 	delete(c.pel, id)
 	c.mut.Unlock()
 }
@@ -190,5 +234,12 @@ func (cg *ConsumerGroup) UnmarshalBinary(data []byte) error {
 	cg.members = ecg.Members
 	cg.pel = ecg.PEL
 	cg.startAt = ecg.StartAt
+	// gob does not transmit empty maps
+	if cg.members == nil {
+		cg.members = make(map[string]Consumer)
+	}
+	if cg.pel == nil {
+		cg.pel = make(PendingEntriesList)
+	}
 	return nil
 }
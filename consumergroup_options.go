@@ -1,5 +1,9 @@
 package historitor
 
+import (
+	"time"
+)
+
 type consumerGroupOptions struct {
 	Name    string
 	StartAt EntryID
@@ -50,6 +54,14 @@ func WithConsumerGroupStartAt(startAt EntryID) ConsumerGroupOption {
 	})
 }
 
+// WithConsumerGroupStartAtTime returns a ConsumerGroupOption that starts the Consumer group at the log entries written
+// at or after the provided time, see [ConsumerGroup.SetStartAtTime].
+func WithConsumerGroupStartAtTime(t time.Time) ConsumerGroupOption {
+	return newFuncConsumerGroupOption(func(opts *consumerGroupOptions) {
+		opts.StartAt = entryIDBefore(t)
+	})
+}
+
 // WithConsumerGroupMember returns a ConsumerGroupOption that uses the provided member.
 func WithConsumerGroupMember(member Consumer) ConsumerGroupOption {
 	return newFuncConsumerGroupOption(func(opts *consumerGroupOptions) {
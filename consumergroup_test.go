@@ -47,6 +47,59 @@ func TestConsumerGroup_SetStartAt(t *testing.T) {
 	require.Equal(t, StartFromBeginning, cg.startAt)
 }
 
+func TestConsumerGroup_SetStartAtTime(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	cg := NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))
+	require.NoError(t, l.AddGroup(cg))
+	base := time.UnixMilli(1700000000000)
+	for i := 0; i < 3; i++ {
+		id := NewEntryID(base.Add(time.Duration(i)*time.Second), 0)
+		require.NoError(t, l.write(&id, "", i))
+		id = NewEntryID(base.Add(time.Duration(i)*time.Second), 0)
+		require.NoError(t, l.write(&id, "", i*10))
+	}
+	entries, err := l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 6)
+
+	// rewinding re-reads the log entries written at or after the time, including every sequence number
+	cg.SetStartAtTime(base.Add(time.Second))
+	for _, e := range entries {
+		require.NoError(t, l.Acknowledge("group1", "consumer1", e.ID))
+	}
+	entries, err = l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Equal(t, []any{1, 10, 2, 20}, entryPayloads(entries))
+
+	// a time within a millisecond skips the log entries written in that millisecond
+	cg.SetStartAtTime(base.Add(time.Second + 500*time.Microsecond))
+	for _, e := range entries {
+		require.NoError(t, l.Acknowledge("group1", "consumer1", e.ID))
+	}
+	entries, err = l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Equal(t, []any{2, 20}, entryPayloads(entries))
+
+	// the time may be in the future
+	cg.SetStartAtTime(base.Add(time.Hour))
+	id := NewEntryID(base.Add(time.Hour-time.Millisecond), 0)
+	require.NoError(t, l.write(&id, "", "before"))
+	id = NewEntryID(base.Add(time.Hour), 0)
+	require.NoError(t, l.write(&id, "", "after"))
+	entries, err = l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Equal(t, []any{"after"}, entryPayloads(entries))
+}
+
+func TestWithConsumerGroupStartAtTime(t *testing.T) {
+	opts := newDefaultConsumerGroupOptions()
+	cgo := WithConsumerGroupStartAtTime(time.UnixMilli(1700000000000))
+	cgo.apply(&opts)
+	require.Equal(t, entryIDBefore(time.UnixMilli(1700000000000)), opts.StartAt)
+}
+
 func TestConsumerGroup_GetName(t *testing.T) {
 	cg := ConsumerGroup{
 		name: "group1",
@@ -290,3 +343,26 @@ func TestConsumerGroup_UnmarshalBinary_error(t *testing.T) {
 	err := cg.UnmarshalBinary(input)
 	require.Error(t, err)
 }
+
+// TestConsumerGroup_Err tests that a failure to append a change of the Consumer group to the write-ahead log is kept
+// as the sticky error of the Consumer group, while the change itself is still made.
+func TestConsumerGroup_Err(t *testing.T) {
+	cg := NewConsumerGroup(WithConsumerGroupName(t.Name()))
+	cg.SetStartAt(fakeTestEntryID1)
+	require.NoError(t, cg.Err())
+
+	w, err := openWAL(logOptions{WALDir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, w.active().file.Close())
+	cg.wal = w
+	cg.SetStartAt(fakeTestEntryID2)
+	err = cg.Err()
+	require.Error(t, err)
+	require.Equal(t, fakeTestEntryID2, cg.GetStartAt())
+
+	cg.AddMember(NewConsumer(WithConsumerName("consumer1")))
+	cg.AddPendingEntry(fakeTestEntryID1, "consumer1")
+	cg.RemovePendingEntry(fakeTestEntryID1)
+	cg.RemoveMember("consumer1")
+	require.Equal(t, err, cg.Err())
+}
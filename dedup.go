@@ -0,0 +1,213 @@
+package historitor
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+var ErrSequenceTooOld = fmt.Errorf("producer sequence number is older than the deduplication window")
+
+// producer is the deduplication state of a producer writing to a [Log] with [Log.WriteIdempotent]. The sequence
+// numbers of the latest writes of the producer are remembered, up to the deduplication window of the log, along with
+// the IDs of the log entries written.
+type producer struct {
+	// ids maps the remembered sequence numbers to the IDs of the log entries written with them, and seqs holds the
+	// remembered sequence numbers in the order they were written.
+	ids  map[uint64]EntryID
+	seqs []uint64
+	// minSeq is one larger than the greatest sequence number forgotten. A sequence number below minSeq that is not
+	// remembered may have been written before, so it cannot be told apart from a duplicate.
+	minSeq uint64
+}
+
+// externalProducer is used to represent a producer in a way that can easily be encoded and decoded using the gob
+// package.
+type externalProducer struct {
+	Seqs   []uint64
+	IDs    []EntryID
+	MinSeq uint64
+}
+
+// remember remembers that the log entry with the given ID was written with seq, forgetting the oldest sequence numbers
+// if more than window sequence numbers are remembered.
+func (p *producer) remember(seq uint64, id EntryID, window int) {
+	if _, ok := p.ids[seq]; !ok {
+		p.seqs = append(p.seqs, seq)
+	}
+	p.ids[seq] = id
+	for len(p.seqs) > max(window, 1) {
+		forgotten := p.seqs[0]
+		p.seqs = p.seqs[1:]
+		delete(p.ids, forgotten)
+		if forgotten >= p.minSeq {
+			p.minSeq = forgotten + 1
+		}
+	}
+}
+
+// WriteIdempotent writes a new log entry to the log on behalf of a producer, unless the producer already wrote a log
+// entry with the same sequence number. It returns the ID of the log entry, which for a duplicate is the ID of the log
+// entry written originally. This allows producers to retry writes, e.g. after a timeout, without writing duplicates,
+// much like an idempotent producer in Kafka.
+//
+// A producer is identified by producerID, which must not be empty, and numbers its writes with seq. The log remembers
+// the sequence numbers of the latest writes of every producer, up to the number set with [WithLogDedupWindow]. A
+// sequence number older than every remembered sequence number of the producer cannot be told apart from a duplicate,
+// and is rejected with an error wrapping [ErrSequenceTooOld].
+//
+// The deduplication state is saved in snapshots and the write-ahead log, and survives compaction of the log. Like
+// [Log.Write], WriteIdempotent appends the log entry to the write-ahead log, if enabled, and waits for it to be flushed
+// to stable storage.
+//
+// WriteIdempotent is safe for concurrent use.
+func (l *Log) WriteIdempotent(producerID string, seq uint64, payload any) (EntryID, error) {
+	if producerID == "" {
+		return ZeroEntryID, fmt.Errorf("producer ID must not be empty")
+	}
+	l.treeMux.Lock()
+	id, written, err := l.writeEntryIdempotent(producerID, seq, payload)
+	l.treeMux.Unlock()
+	if err != nil {
+		return ZeroEntryID, err
+	}
+	if written {
+		l.written.notify()
+	}
+	// a duplicate waits as well, as the original write may not have been flushed yet
+	return id, l.wal.wait()
+}
+
+// writeEntryIdempotent writes a new log entry to the log on behalf of a producer, see [Log.WriteIdempotent]. It reports
+// whether a log entry was written, which is not the case for duplicates. It is not safe for concurrent use and should
+// be called with the treeMux locked.
+func (l *Log) writeEntryIdempotent(producerID string, seq uint64, payload any) (EntryID, bool, error) {
+	if p, ok := l.producers[producerID]; ok {
+		if id, ok := p.ids[seq]; ok {
+			return id, false, nil
+		}
+		if seq < p.minSeq {
+			return ZeroEntryID, false, fmt.Errorf("%w: %d of producer %s", ErrSequenceTooOld, seq, producerID)
+		}
+	}
+	id, err := l.writeEntryAt(walRecord{ID: l.nextEntryID(), Payload: payload, Producer: producerID, ProducerSeq: seq})
+	if err != nil {
+		return ZeroEntryID, false, err
+	}
+	return id, true, nil
+}
+
+// trackProducer updates the deduplication state of the producer that wrote rec, if any. It should be called with the
+// treeMux locked.
+func (l *Log) trackProducer(rec walRecord) {
+	if rec.Producer == "" {
+		return
+	}
+	if l.producers == nil {
+		l.producers = make(map[string]*producer)
+	}
+	p, ok := l.producers[rec.Producer]
+	if !ok {
+		p = &producer{ids: make(map[uint64]EntryID)}
+		l.producers[rec.Producer] = p
+	}
+	p.remember(rec.ProducerSeq, rec.ID, l.dedupWindow)
+}
+
+// producerRecords returns walOpSetProducer records holding the deduplication state of the producers of the log,
+// ordered by producer ID. It should be called with the treeMux locked.
+func (l *Log) producerRecords() ([]walRecord, error) {
+	producers := l.externalProducers()
+	var recs []walRecord
+	for _, name := range slices.Sorted(maps.Keys(producers)) {
+		var buf bytes.Buffer
+		err := gob.NewEncoder(&buf).Encode(producers[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode producer %s: %w", name, err)
+		}
+		recs = append(recs, walRecord{Op: walOpSetProducer, Producer: name, State: buf.Bytes()})
+	}
+	return recs, nil
+}
+
+// journalProducers appends the deduplication state of the producers of the log to the write-ahead log. Otherwise,
+// the deduplication state is only journaled along with the log entries written by the producers, and would be lost
+// once compaction removes the records writing log entries that were removed, e.g. by key compaction. It is called
+// before the write-ahead log is compacted.
+func (l *Log) journalProducers() error {
+	l.treeMux.RLock()
+	defer l.treeMux.RUnlock()
+	recs, err := l.producerRecords()
+	if err != nil {
+		return err
+	}
+	return l.wal.appendBatch(func(add func(rec walRecord) error) error {
+		for _, rec := range recs {
+			err := add(rec)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// applyProducer replaces the deduplication state of the producer of a walOpSetProducer record with the state it
+// holds. It should be called with the treeMux locked.
+func (l *Log) applyProducer(rec walRecord) error {
+	var ep externalProducer
+	err := gob.NewDecoder(bytes.NewReader(rec.State)).Decode(&ep)
+	if err != nil {
+		return fmt.Errorf("failed to decode producer %s: %w", rec.Producer, err)
+	}
+	if l.producers == nil {
+		l.producers = make(map[string]*producer)
+	}
+	l.producers[rec.Producer] = l.restoreProducer(ep)
+	return nil
+}
+
+// externalProducers returns the deduplication state of the producers of the log, for inclusion in a snapshot. It
+// should be called with the treeMux locked.
+func (l *Log) externalProducers() map[string]externalProducer {
+	if len(l.producers) == 0 {
+		return nil
+	}
+	producers := make(map[string]externalProducer, len(l.producers))
+	for name, p := range l.producers {
+		ep := externalProducer{
+			Seqs:   slices.Clone(p.seqs),
+			IDs:    make([]EntryID, len(p.seqs)),
+			MinSeq: p.minSeq,
+		}
+		for i, seq := range p.seqs {
+			ep.IDs[i] = p.ids[seq]
+		}
+		producers[name] = ep
+	}
+	return producers
+}
+
+// restoreProducers replaces the deduplication state of the producers of the log with the state held by a snapshot. If
+// the snapshot remembers more sequence numbers than the deduplication window of the log, the oldest are forgotten. It
+// should be called with the treeMux locked.
+func (l *Log) restoreProducers(producers map[string]externalProducer) {
+	l.producers = make(map[string]*producer, len(producers))
+	for name, ep := range producers {
+		l.producers[name] = l.restoreProducer(ep)
+	}
+}
+
+// restoreProducer returns the deduplication state held by ep, forgetting the oldest sequence numbers if it remembers
+// more sequence numbers than the deduplication window of the log.
+func (l *Log) restoreProducer(ep externalProducer) *producer {
+	p := &producer{ids: make(map[uint64]EntryID), minSeq: ep.MinSeq}
+	for i, seq := range ep.Seqs {
+		if i < len(ep.IDs) {
+			p.remember(seq, ep.IDs[i], l.dedupWindow)
+		}
+	}
+	return p
+}
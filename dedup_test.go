@@ -0,0 +1,199 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLog_WriteIdempotent(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+
+	id1, err := l.WriteIdempotent("producer1", 1, "one")
+	require.NoError(t, err)
+	retried, err := l.WriteIdempotent("producer1", 1, "one")
+	require.NoError(t, err)
+	require.Equal(t, id1, retried)
+	require.Equal(t, 1, l.Size())
+
+	// sequence numbers are per producer
+	id2, err := l.WriteIdempotent("producer2", 1, "two")
+	require.NoError(t, err)
+	require.NotEqual(t, id1, id2)
+	require.Equal(t, 2, l.Size())
+
+	_, err = l.WriteIdempotent("", 1, "three")
+	require.Error(t, err)
+	require.Equal(t, 2, l.Size())
+}
+
+func TestLog_WriteIdempotent_window(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogDedupWindow(2))
+	require.NoError(t, err)
+	var ids []EntryID
+	for seq := range uint64(3) {
+		id, err := l.WriteIdempotent("producer1", seq, seq)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	_, err = l.WriteIdempotent("producer1", 0, uint64(0))
+	require.ErrorIs(t, err, ErrSequenceTooOld)
+	id, err := l.WriteIdempotent("producer1", 1, uint64(1))
+	require.NoError(t, err)
+	require.Equal(t, ids[1], id)
+	require.Equal(t, 3, l.Size())
+}
+
+func TestLog_WriteIdempotent_MarshalBinary(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogDedupWindow(2))
+	require.NoError(t, err)
+	var ids []EntryID
+	for seq := range uint64(3) {
+		id, err := l.WriteIdempotent("producer1", seq, "payload")
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogDedupWindow(2))
+	require.NoError(t, err)
+	require.NoError(t, l2.UnmarshalBinary(b))
+	id, err := l2.WriteIdempotent("producer1", 2, "payload")
+	require.NoError(t, err)
+	require.Equal(t, ids[2], id)
+	_, err = l2.WriteIdempotent("producer1", 0, "payload")
+	require.ErrorIs(t, err, ErrSequenceTooOld)
+	require.Equal(t, 3, l2.Size())
+
+	// a smaller window forgets the oldest sequence numbers
+	l3, err := NewLog(WithLogName(t.Name()), WithLogDedupWindow(1))
+	require.NoError(t, err)
+	require.NoError(t, l3.UnmarshalBinary(b))
+	_, err = l3.WriteIdempotent("producer1", 1, "payload")
+	require.ErrorIs(t, err, ErrSequenceTooOld)
+}
+
+func TestLog_WriteIdempotent_wal(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	id1, err := l.WriteIdempotent("producer1", 7, "one")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	id, err := l2.WriteIdempotent("producer1", 7, "one")
+	require.NoError(t, err)
+	require.Equal(t, id1, id)
+	require.Equal(t, 1, l2.Size())
+
+	// the deduplication state is journaled again when the write-ahead log is replaced by a snapshot
+	b, err := l2.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, l2.UnmarshalBinary(b))
+	require.NoError(t, l2.Close())
+	l3, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l3.Close()
+	}()
+	id, err = l3.WriteIdempotent("producer1", 7, "one")
+	require.NoError(t, err)
+	require.Equal(t, id1, id)
+	require.Equal(t, 1, l3.Size())
+}
+
+// TestLog_WriteIdempotent_ReadFrom_wal tests that the sequence numbers forgotten before a snapshot was taken are still
+// rejected once the snapshot has been read into a log and the write-ahead log of the log has been replayed.
+func TestLog_WriteIdempotent_ReadFrom_wal(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogDedupWindow(2))
+	require.NoError(t, err)
+	var ids []EntryID
+	for seq := range uint64(3) {
+		id, err := l.WriteIdempotent("producer1", seq, "payload")
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	var buf bytes.Buffer
+	_, err = l.WriteTo(&buf)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogDedupWindow(2))
+	require.NoError(t, err)
+	_, err = l2.ReadFrom(&buf)
+	require.NoError(t, err)
+	require.NoError(t, l2.Close())
+
+	l3, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogDedupWindow(2))
+	require.NoError(t, err)
+	defer func() {
+		_ = l3.Close()
+	}()
+	_, err = l3.WriteIdempotent("producer1", 0, "payload")
+	require.ErrorIs(t, err, ErrSequenceTooOld)
+	id, err := l3.WriteIdempotent("producer1", 2, "payload")
+	require.NoError(t, err)
+	require.Equal(t, ids[2], id)
+	require.Equal(t, 3, l3.Size())
+}
+
+// TestLog_WriteIdempotent_Compact tests that the deduplication state is journaled before the write-ahead log is
+// compacted, that only the latest journaled deduplication state of a producer is kept, and that the deduplication state
+// survives replaying the compacted write-ahead log.
+func TestLog_WriteIdempotent_Compact(t *testing.T) {
+	dir := t.TempDir()
+	// every record is appended to its own segment
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogSegmentMaxBytes(1), WithLogDedupWindow(2))
+	require.NoError(t, err)
+	var ids []EntryID
+	for seq := range uint64(3) {
+		id, err := l.WriteIdempotent("producer1", seq, "payload")
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	journaled := func() int {
+		n := 0
+		for _, s := range l.wal.segments {
+			require.NoError(t, s.scan(func(rec walRecord, _ int64) error {
+				if rec.Op == walOpSetProducer {
+					require.Equal(t, "producer1", rec.Producer)
+					n++
+				}
+				return nil
+			}))
+		}
+		return n
+	}
+
+	// compaction only journals the deduplication state when there are superseded records to remove
+	require.NoError(t, l.Compact())
+	require.Zero(t, journaled())
+	for range 2 {
+		require.True(t, l.UpdateEntry(ids[0], "updated"))
+		require.True(t, l.UpdateEntry(ids[0], "updated"))
+		_, err = l.Write("seal")
+		require.NoError(t, err)
+		require.NoError(t, l.Compact())
+		require.Equal(t, 1, journaled())
+	}
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogDedupWindow(2))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	_, err = l2.WriteIdempotent("producer1", 0, "payload")
+	require.ErrorIs(t, err, ErrSequenceTooOld)
+	id, err := l2.WriteIdempotent("producer1", 1, "payload")
+	require.NoError(t, err)
+	require.Equal(t, ids[1], id)
+	require.Equal(t, 5, l2.Size())
+}
@@ -30,6 +30,9 @@
 // more than [WithLogMaxDeliveryCount] times. If the Consumer has any such entries, the log will update the PEL and
 // include the entries in the response.
 //
+// Rather than polling [Log.Read], a Consumer can call [Log.ReadContext], which blocks until log entries are written to
+// the log or pending entries become eligible for re-delivery, much like XREADGROUP with BLOCK in Redis Streams.
+//
 // To prevent a Consumer from holding onto an entry indefinitely, a housekeeping function called [Log.Cleanup] is
 // implemented. This function, among other things, removes pending entries that have been delivered more than
 // [WithLogMaxDeliveryCount] times and are older than [WithLogAttemptRedeliveryAfter].
@@ -42,9 +45,136 @@
 // a housekeeping function called [Log.Cleanup]. Among other things, this function removed pending entries that are
 // older than [WithLogMaxPendingAge] to allow other consumers to attempt to process the log entry.
 //
+// # Reading outside Consumer groups
+//
+// Log entries can also be read without a Consumer group, e.g. to page through the history of the log, in which case no
+// Pending Entries List (PEL) is changed. [Log.Range] and [Log.RevRange] read the log entries between two IDs, in
+// ascending and descending order respectively, much like XRANGE and XREVRANGE in Redis Streams. Individual log entries
+// can be looked up by their ID with [Log.Get] and [Log.GetMany]. [Log.All], [Log.Between] and [Log.Backward] return
+// iterators for use with range-over-func loops:
+//
+//	for id, payload := range log.All() {
+//		// ...
+//	}
+//
+// These iterators stop early if reading the log fails. [Log.Entries] and [Log.EntriesBackward] yield the error along
+// with the log entries instead.
+//
+// As the IDs of log entries hold the time they were written, [Log.SeekTime] finds the first log entry written at or
+// after a given time, and a Consumer group can be moved to a given time with [ConsumerGroup.SetStartAtTime], e.g. to
+// re-process the log entries written since an incident.
+//
+// Readers that do not need to acknowledge log entries, such as live dashboards, can subscribe to the log with
+// [Log.Subscribe], which streams log entries to a channel as they are written, without a Pending Entries List (PEL).
+// How a subscriber falling behind is handled is configured with [WithSubscribeBackpressure].
+//
+// # Searching
+//
+// [Log.Search] returns the log entries whose payloads match a predicate, optionally bounded by ID or by the time the
+// log entries were written. Large results can be paged through by limiting the number of log entries returned with
+// [WithSearchLimit] and continuing from the cursor of the previous [SearchResult] with [WithSearchCursor].
+//
+// Searching reads every log entry within the bounds. To avoid this, payloads can be indexed with [WithLogIndex], which
+// maps every payload to a set of values, such as a user ID. The index is kept up to date as log entries are written,
+// updated and removed, and a search restricted with [WithSearchIndex] only reads the log entries indexed by a value.
+// Indexes can also be added to an existing log with [Log.CreateIndex], and the IDs of the log entries indexed by a
+// value are returned by [Log.LookupIndex].
+//
+// Indexes are saved in snapshots, but not in the write-ahead log, which only holds the log entries the indexes are
+// rebuilt from when the log is restored. As the functions extracting the values cannot be saved, an index restored
+// from a snapshot is not updated as log entries are written until its function is provided again with
+// [Log.CreateIndex].
+//
+// Log entries with string payloads can also be searched by the words they hold, when the full-text index of the log is
+// enabled with [WithLogFullTextIndex]. [Log.FullTextSearch] supports AND, OR and phrase queries, and returns the
+// positions of the matches in the payloads along with the IDs of the log entries:
+//
+//	matches, err := log.FullTextSearch(`"natural selection" OR variation`)
+//
+// How payloads are split into words is configured with [WithFullTextTokenizer], [WithFullTextLowercase] and
+// [WithFullTextStemmer].
+//
+// # Filters
+//
+// Rather than Go functions, log entries can be matched by declarative filter expressions compiled with
+// [CompileFilter], allowing filters to be read from configuration files or received over the network:
+//
+//	filter, err := historitor.CompileFilter(`payload.level == "error" && payload.latency_ms > 200`)
+//
+// Filters access the fields of maps and structs in payloads by reflection, and support comparisons, boolean logic,
+// list membership and regular expressions, see [Filter]. Filters can be passed to [Log.Read] with [WithReadFilter], to
+// [Log.Range] and [Log.RevRange] with [WithRangeFilter], and to [Log.Search] with [WithSearchFilter].
+//
+// # Writing log entries
+//
+// Log entries are written with [Log.Write], which assigns every log entry an [EntryID] holding the time it was written.
+// IDs are always ascending, even if the wall clock is stepped backwards, so log entries never land behind log entries
+// already read by Consumer groups. The clock can be replaced with [WithLogClock], e.g. in tests.
+// Events made up of several log entries can be written atomically with [Log.WriteBatch]. The log entries of a batch
+// are either all visible to readers or none of them are, and a batch only partially appended to the write-ahead log
+// when the process crashed is discarded in its entirety when the write-ahead log is replayed.
+//
+// Log entries replayed from another system can keep their original IDs by writing them with [Log.WriteWithID], much
+// like XADD with an explicit ID in Redis Streams. IDs must be ascending, and an ID not greater than the ID of the last
+// log entry is rejected with [ErrIDNotMonotonic]. A partial ID created with [NewPartialEntryID] leaves the sequence
+// number to the log.
+//
+// Producers retrying writes, e.g. after a timeout, can avoid writing duplicates by numbering their writes and writing
+// with [Log.WriteIdempotent], which returns the ID of the original log entry for a retried write. The log remembers
+// the latest sequence numbers of every producer, up to the window set with [WithLogDedupWindow].
+//
+// The payloads of log entries can be replaced with [Log.UpdateEntry]. Every update increments the revision of the log
+// entry, see [Entry], and [Log.UpdateEntryIf] only updates a log entry still at a given revision, much like a
+// compare-and-swap, so writers updating the same log entries do not overwrite each other's updates.
+//
+// # Key compaction
+//
+// Log entries can be written with a key using [Log.WriteKey]. When key compaction is enabled with
+// [WithLogKeyCompaction], compacting the log removes every log entry superseded by a later log entry with the same
+// key, so only the latest log entry of every key is kept, much like a compacted Kafka topic. This allows the log to be
+// used as a changelog, e.g. of configuration state, without growing without bound. Log entries still pending in the
+// Pending Entries List (PEL) of a Consumer group are kept until they are no longer pending. The log is compacted in
+// the background when enabled with [WithLogCompactionInterval], or explicitly with [Log.Compact].
+//
 // # Data persistence
 //
+// Log entries are kept in a [Storage]. Unless another [Storage] is provided with [WithLogStorage], the log entries are
+// kept in a [MemoryStorage].
+//
 // The log is a memory construct, with persistence enabled by Go's [encoding/gob] package. The log can be saved to disk
-// and loaded from disk by using [encoding/gob.Encoder] and [encoding/gob.Decoder] in combination with an [io.Writer]
-// and [io.Reader] pointed towards persistent storage.
+// by streaming a snapshot to an [io.Writer] with [Log.WriteTo], and loaded from disk by streaming it back from an
+// [io.Reader] with [Log.ReadFrom]. Log entries are streamed one at a time, so a snapshot is never held in memory in its
+// entirety. The log also implements [encoding.BinaryMarshaler] and [encoding.BinaryUnmarshaler], allowing it to be
+// used with [encoding/gob.Encoder] and [encoding/gob.Decoder].
+//
+// Snapshots carry the version of the snapshot format they were written in. Snapshots written by older versions of this
+// package are upgraded when they are read, whereas snapshots written in a newer version of the snapshot format are
+// rejected with a [*SnapshotVersionError].
+//
+// Saving the log only captures the changes made up until the point it was saved. To avoid losing changes made between
+// saves, a write-ahead log can be enabled with [WithLogWAL]. Every change to the log and its Consumer groups is then
+// appended to the write-ahead log before the method making the change returns, and [NewLog] replays the write-ahead
+// log to restore the log. When the write-ahead log is flushed to stable storage is determined by the [SyncPolicy] set
+// with [WithLogSyncPolicy], and it can be flushed explicitly with [Log.Sync].
+//
+// As appending to the write-ahead log can fail, [Log.Write], [Log.AddGroup] and [Log.RemoveGroup] return an error,
+// which they did not before the write-ahead log was introduced. Code written against earlier versions of this package
+// must be updated to handle the error. The methods of [ConsumerGroup] do not return errors, and keep the error instead,
+// see [ConsumerGroup.Err].
+//
+// The write-ahead log is split into segment files. Records are appended to the newest segment until it grows larger
+// than [WithLogSegmentMaxBytes] or older than [WithLogSegmentMaxAge], at which point the segment is sealed and a new
+// one is started. Nothing is appended to sealed segments, which are only rewritten by compaction, and each is
+// accompanied by a sparse index mapping [EntryID] to the offset in the segment the log entry was written at. Records
+// superseded by later records, such as payloads replaced by [Log.UpdateEntry], are removed from sealed segments by
+// compaction, which runs in the background when enabled with [WithLogCompactionInterval], or explicitly with
+// [Log.Compact].
+//
+// With [WithLogMmap], the payloads of log entries are not kept in the [Storage], which then only holds the IDs and keys
+// of the log entries. Payloads are instead read from the write-ahead log when log entries are read, with sealed
+// segments mapped into memory, allowing a log to hold more log entries than fit in memory.
+//
+// Snapshots and the write-ahead log carry checksums, which are verified when they are read. Corrupted data is reported
+// as a [*CorruptionError], naming the log entry affected. With [WithLogTruncateCorrupted], corrupted data and
+// everything following it is discarded instead.
 package historitor
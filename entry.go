@@ -1,6 +1,12 @@
 package historitor
 
 type Entry struct {
-	ID      EntryID
+	ID EntryID
+	// Key is the key the log entry was written with using [Log.WriteKey], or an empty string if it has no key. See
+	// [WithLogKeyCompaction].
+	Key     string
 	Payload any
+	// Revision is the number of times the payload of the log entry has been updated, see [Log.UpdateEntryIf]. It is
+	// zero for a log entry that was never updated.
+	Revision uint64
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +26,9 @@ var (
 type EntryID struct {
 	time time.Time
 	seq  uint64
+	// partial is set if the sequence number is left to be chosen when the EntryID is written, see
+	// [NewPartialEntryID].
+	partial bool
 }
 
 // NewEntryID creates a new EntryID with the given time and sequence number.
@@ -36,11 +40,68 @@ func NewEntryID(t time.Time, seq uint64) EntryID {
 	}
 }
 
+// NewPartialEntryID creates a new EntryID with the given time, leaving the sequence number to be chosen when a log
+// entry is written with the EntryID using [Log.WriteWithID], much like the <ms>-* IDs of XADD in Redis Streams. The
+// time is truncated to milliseconds and timezone set to UTC.
+func NewPartialEntryID(t time.Time) EntryID {
+	id := NewEntryID(t, 0)
+	id.partial = true
+	return id
+}
+
+// entryIDAtOrAfter returns the smallest EntryID whose time is at or after t. Unlike NewEntryID, t is rounded up to the
+// next millisecond if it falls within a millisecond.
+func entryIDAtOrAfter(t time.Time) EntryID {
+	id := NewEntryID(t, 0)
+	if id.time.Before(t) {
+		id.time = id.time.Add(time.Millisecond)
+	}
+	return id
+}
+
+// entryIDBefore returns the greatest EntryID preceding every EntryID with a time at or after t. Like entryIDAtOrAfter,
+// t is rounded up to the next millisecond if it falls within a millisecond. If t is at or before the Unix epoch,
+// [StartFromBeginning] is returned.
+func entryIDBefore(t time.Time) EntryID {
+	id := entryIDAtOrAfter(t)
+	if id.time.UnixMilli() <= 0 {
+		return StartFromBeginning
+	}
+	return EntryID{
+		time: id.time.Add(-time.Millisecond),
+		seq:  math.MaxUint64,
+	}
+}
+
 func (e EntryID) IsZero() bool {
 	return e == ZeroEntryID
 }
 
+// IsPartial reports whether e was created by [NewPartialEntryID], or parsed from a string with * as its sequence
+// number.
+func (e EntryID) IsPartial() bool {
+	return e.partial
+}
+
+// Compare compares e to other. It returns -1 if e is before other, 0 if they are equal and +1 if e is after other.
+// IDs are ordered by time first and sequence number second.
+func (e EntryID) Compare(other EntryID) int {
+	if c := e.time.Compare(other.time); c != 0 {
+		return c
+	}
+	switch {
+	case e.seq < other.seq:
+		return -1
+	case e.seq > other.seq:
+		return 1
+	}
+	return 0
+}
+
 func (e EntryID) String() string {
+	if e.partial {
+		return fmt.Sprintf("%d-*", e.time.UTC().UnixMilli())
+	}
 	return fmt.Sprintf("%d-%013d", e.time.UTC().UnixMilli(), e.seq)
 }
 
@@ -82,15 +143,23 @@ func (e *EntryID) UnmarshalBinary(data []byte) error {
 
 // ParseEntryID parses a string representation of an EntryID.
 // The string must be in the format "time-seq" where time is the number of milliseconds since the Unix epoch and seq is
-// the sequence number. The time is truncated to milliseconds and timezone set to UTC.
+// the sequence number. The time is truncated to milliseconds and timezone set to UTC. If seq is *, a partial EntryID
+// is returned, see [NewPartialEntryID].
 func ParseEntryID(s string) (EntryID, error) {
 	var e EntryID
-	ms, err := strconv.Atoi(strings.Split(s, "-")[0])
+	msPart, seqPart, ok := strings.Cut(s, "-")
+	if !ok {
+		return ZeroEntryID, fmt.Errorf("failed to parse EntryID: missing sequence number in %q", s)
+	}
+	ms, err := strconv.Atoi(msPart)
 	if err != nil {
 		return ZeroEntryID, fmt.Errorf("failed to parse EntryID: %w", err)
 	}
 	t := time.UnixMilli(int64(ms)).UTC()
-	seq, err := strconv.Atoi(strings.Split(s, "-")[1])
+	if seqPart == "*" {
+		return NewPartialEntryID(t), nil
+	}
+	seq, err := strconv.Atoi(seqPart)
 	if err != nil {
 		return ZeroEntryID, fmt.Errorf("failed to parse EntryID: %w", err)
 	}
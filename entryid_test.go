@@ -58,6 +58,19 @@ func TestEntryID_IsZero(t *testing.T) {
 	})
 }
 
+// TestEntryIDBefore tests that entryIDBefore returns an EntryID preceding every EntryID at or after the given time.
+func TestEntryIDBefore(t *testing.T) {
+	at := time.UnixMilli(1700000000000)
+	before := entryIDBefore(at)
+	require.Negative(t, before.Compare(NewEntryID(at, 0)))
+	require.Positive(t, before.Compare(NewEntryID(at.Add(-time.Millisecond), 1<<62)))
+	// times within a millisecond are rounded up, like with entryIDAtOrAfter
+	require.Equal(t, entryIDBefore(at.Add(time.Millisecond)), entryIDBefore(at.Add(500*time.Microsecond)))
+
+	require.Equal(t, StartFromBeginning, entryIDBefore(time.Time{}))
+	require.Equal(t, StartFromBeginning, entryIDBefore(time.UnixMilli(0)))
+}
+
 // TestEntryID_String tests the String function.
 func TestEntryID_String(t *testing.T) {
 	eid := EntryID{
@@ -183,3 +196,36 @@ func TestParseEntryID_error_bad_seq(t *testing.T) {
 	require.Error(t, err)
 	require.Equal(t, ZeroEntryID, eid)
 }
+
+// TestParseEntryID_partial tests the ParseEntryID function when the sequence number is left to be chosen.
+func TestParseEntryID_partial(t *testing.T) {
+	eid, err := ParseEntryID("1734467114191-*")
+	require.NoError(t, err)
+	require.True(t, eid.IsPartial())
+	require.Equal(t, NewPartialEntryID(time.UnixMilli(1734467114191)), eid)
+	require.Equal(t, "1734467114191-*", eid.String())
+}
+
+// TestParseEntryID_error_missing_seq tests the ParseEntryID function when encountering a string without a sequence
+// number.
+func TestParseEntryID_error_missing_seq(t *testing.T) {
+	eid, err := ParseEntryID("1734467114191")
+	require.Error(t, err)
+	require.Equal(t, ZeroEntryID, eid)
+}
+
+func TestEntryID_Compare(t *testing.T) {
+	require.Equal(t, 0, fakeTestEntryID1.Compare(fakeTestEntryID1))
+	require.Equal(t, -1, fakeTestEntryID1.Compare(fakeTestEntryID2))
+	require.Equal(t, 1, fakeTestEntryID2.Compare(fakeTestEntryID1))
+	require.Equal(t, -1, fakeTestEntryID2.Compare(fakeTestEntryID3))
+	require.Equal(t, 1, fakeTestEntryID3.Compare(fakeTestEntryID2))
+	require.Equal(t, -1, StartFromBeginning.Compare(fakeTestEntryID1))
+}
+
+// TestEntryIDAtOrAfter tests that entryIDAtOrAfter rounds times within a millisecond up to the next millisecond.
+func TestEntryIDAtOrAfter(t *testing.T) {
+	at := time.UnixMilli(1700000000000)
+	require.Equal(t, NewEntryID(at, 0), entryIDAtOrAfter(at))
+	require.Equal(t, NewEntryID(at.Add(time.Millisecond), 0), entryIDAtOrAfter(at.Add(time.Microsecond)))
+}
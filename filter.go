@@ -0,0 +1,404 @@
+package historitor
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidFilter = fmt.Errorf("invalid filter")
+
+// Ensure Filter implements encoding.TextMarshaler and encoding.TextUnmarshaler at compile time
+var (
+	_ encoding.TextMarshaler   = (*Filter)(nil)
+	_ encoding.TextUnmarshaler = (*Filter)(nil)
+)
+
+// FilterError is returned when a filter expression cannot be compiled. It wraps [ErrInvalidFilter].
+type FilterError struct {
+	// Offset is the byte offset in the filter expression of the error.
+	Offset int
+	// Reason describes the error.
+	Reason string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s: %s at offset %d", ErrInvalidFilter, e.Reason, e.Offset)
+}
+
+func (e *FilterError) Unwrap() error {
+	return ErrInvalidFilter
+}
+
+// Filter is a compiled filter expression, matching log entries by their payloads and keys. Filters are created with
+// [CompileFilter], and are safe for concurrent use.
+//
+// A filter expression is a boolean expression, such as:
+//
+//	payload.level == "error" && payload.latency_ms > 200
+//
+// The payload of the log entry is accessed as payload, and its key as key. Fields of maps with string keys and of
+// structs are accessed with a dot, or with a string in brackets, e.g. payload["latency-ms"]. Fields of structs are
+// looked up by name, then by the name in their json struct tag, then by name ignoring case. Elements of slices and
+// arrays are accessed with an integer in brackets, e.g. payload.tags[0]. Pointers and interfaces are followed.
+//
+// Expressions can hold string literals, enclosed in double quotes or backquotes as in Go, numbers, true, false, null,
+// and lists of expressions enclosed in brackets. The supported operators, from the highest to the lowest precedence,
+// are:
+//
+//	!  -                              negation of booleans and numbers
+//	== != < <= > >= =~ !~ in          comparison, regular expression match and list membership
+//	&&                                logical and
+//	||                                logical or
+//
+// Parentheses can be used for grouping. All numbers are compared as floating point numbers, regardless of their Go
+// type. The right operand of =~ and !~ must be a string literal holding a regular expression, using the syntax of the
+// [regexp] package. The right operand of in must be a list, either a list literal or a slice or array in the payload.
+//
+// A field missing from the payload evaluates to null, as does a nil value and the negation of a value that is not a
+// number. null is only equal to null, and comparing it with any other operator is false. Similarly, comparing values
+// of different types, e.g. a string with a number, is false, whereas it is rejected when compiling the filter if the
+// types are known.
+type Filter struct {
+	expr string
+	root filterExpr
+}
+
+// CompileFilter compiles a filter expression, see [Filter] for the syntax. If the expression is invalid, a
+// [*FilterError] is returned, holding the position of the error in the expression.
+func CompileFilter(expr string) (*Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	o, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != filterTokenEOF {
+		return nil, unexpectedFilterToken(t)
+	}
+	err = requireFilterType(o, "filter must be a boolean expression", filterTypeBool)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{expr: expr, root: o.expr}, nil
+}
+
+// Match reports whether e matches the filter.
+func (f *Filter) Match(e Entry) bool {
+	return f.root.eval(e) == true
+}
+
+// String returns the expression the filter was compiled from.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// MarshalText returns the expression the filter was compiled from.
+func (f *Filter) MarshalText() ([]byte, error) {
+	return []byte(f.expr), nil
+}
+
+// UnmarshalText compiles the filter expression text into f, allowing filters to be read from configuration files and
+// other encoded formats.
+func (f *Filter) UnmarshalText(text []byte) error {
+	compiled, err := CompileFilter(string(text))
+	if err != nil {
+		return err
+	}
+	*f = *compiled
+	return nil
+}
+
+// filterExpr is a node of a compiled filter expression.
+type filterExpr interface {
+	// eval evaluates the expression for e. The result is nil, a bool, a float64, a string, a []any, or any other value
+	// read from the payload of e, see normalizeFilterValue.
+	eval(e Entry) any
+}
+
+// filterLiteral is a literal value.
+type filterLiteral struct {
+	v any
+}
+
+func (x *filterLiteral) eval(Entry) any {
+	return x.v
+}
+
+// filterList is a list of expressions.
+type filterList struct {
+	items []filterExpr
+}
+
+func (x *filterList) eval(e Entry) any {
+	out := make([]any, 0, len(x.items))
+	for _, item := range x.items {
+		out = append(out, item.eval(e))
+	}
+	return out
+}
+
+// filterPath accesses the payload or key of a log entry, and the fields and elements of the payload.
+type filterPath struct {
+	// root is either payload or key.
+	root string
+	// steps holds the fields, as strings, and indexes, as ints, accessed in turn.
+	steps []any
+}
+
+func (x *filterPath) eval(e Entry) any {
+	v := reflect.ValueOf(e.Payload)
+	if x.root == "key" {
+		v = reflect.ValueOf(e.Key)
+	}
+	for _, step := range x.steps {
+		v = filterAccess(v, step)
+		if !v.IsValid() {
+			return nil
+		}
+	}
+	return normalizeFilterValue(v)
+}
+
+// filterAccess returns the field or element of v identified by step, or the zero Value if there is none.
+func filterAccess(v reflect.Value, step any) reflect.Value {
+	v = filterIndirect(v)
+	if !v.IsValid() {
+		return v
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		keyType := v.Type().Key()
+		key := reflect.ValueOf(step)
+		switch {
+		case key.Type().AssignableTo(keyType):
+		case key.Kind() == reflect.String && keyType.Kind() == reflect.String,
+			key.Kind() == reflect.Int && keyType.Kind() != reflect.String && key.CanConvert(keyType):
+			// the conversion between integers and strings is excluded, as it converts integers to runes
+			key = key.Convert(keyType)
+		default:
+			return reflect.Value{}
+		}
+		return v.MapIndex(key)
+	case reflect.Struct:
+		name, ok := step.(string)
+		if !ok {
+			return reflect.Value{}
+		}
+		return filterField(v, name)
+	case reflect.Slice, reflect.Array:
+		i, ok := step.(int)
+		if !ok || i < 0 || i >= v.Len() {
+			return reflect.Value{}
+		}
+		return v.Index(i)
+	}
+	return reflect.Value{}
+}
+
+// filterField returns the exported field of the struct v with the given name, looking it up by name, then by the name
+// in its json struct tag, then by name ignoring case.
+func filterField(v reflect.Value, name string) reflect.Value {
+	t := v.Type()
+	if f, ok := t.FieldByName(name); ok && f.IsExported() {
+		// a field promoted through a nil embedded pointer is null
+		field, err := v.FieldByIndexErr(f.Index)
+		if err != nil {
+			return reflect.Value{}
+		}
+		return field
+	}
+	var fold reflect.Value
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == name {
+			return v.Field(i)
+		}
+		if !fold.IsValid() && strings.EqualFold(f.Name, name) {
+			fold = v.Field(i)
+		}
+	}
+	return fold
+}
+
+// filterIndirect follows the pointers and interfaces of v, returning the zero Value if any of them is nil.
+func filterIndirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// normalizeFilterValue converts v to the types filter expressions operate on. Booleans, numbers and strings are
+// converted to bool, float64 and string respectively, regardless of their Go type, and nil values to nil. Other values
+// are returned as is.
+func normalizeFilterValue(v reflect.Value) any {
+	v = filterIndirect(v)
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	if !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// filterNot negates a boolean.
+type filterNot struct {
+	x filterExpr
+}
+
+func (x *filterNot) eval(e Entry) any {
+	b, ok := x.x.eval(e).(bool)
+	return ok && !b
+}
+
+// filterNegate negates a number. Operands that are not numbers evaluate to null.
+type filterNegate struct {
+	x filterExpr
+}
+
+func (x *filterNegate) eval(e Entry) any {
+	f, ok := x.x.eval(e).(float64)
+	if !ok {
+		return nil
+	}
+	return -f
+}
+
+// filterLogical is a logical and or or of two booleans. Operands that are not booleans are false.
+type filterLogical struct {
+	and         bool
+	left, right filterExpr
+}
+
+func (x *filterLogical) eval(e Entry) any {
+	left := x.left.eval(e) == true
+	if left != x.and {
+		// the result is decided by the left operand
+		return left
+	}
+	return x.right.eval(e) == true
+}
+
+// filterCompare compares two values.
+type filterCompare struct {
+	op          string
+	left, right filterExpr
+}
+
+func (x *filterCompare) eval(e Entry) any {
+	left, right := x.left.eval(e), x.right.eval(e)
+	switch x.op {
+	case "==":
+		return filterEqual(left, right)
+	case "!=":
+		return !filterEqual(left, right)
+	}
+	c, ok := filterOrder(left, right)
+	if !ok {
+		return false
+	}
+	switch x.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// filterEqual reports whether two evaluated values are equal.
+func filterEqual(a, b any) bool {
+	switch a.(type) {
+	case nil, bool, float64, string:
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// filterOrder compares two evaluated values, reporting whether they could be compared. Only numbers and strings can be
+// compared, and only with values of the same type.
+func filterOrder(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		switch {
+		case !ok:
+			return 0, false
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, a == b
+	case string:
+		b, ok := b.(string)
+		return strings.Compare(a, b), ok
+	}
+	return 0, false
+}
+
+// filterMatch matches a string against a regular expression.
+type filterMatch struct {
+	left   filterExpr
+	re     *regexp.Regexp
+	negate bool
+}
+
+func (x *filterMatch) eval(e Entry) any {
+	s, ok := x.left.eval(e).(string)
+	if !ok {
+		return false
+	}
+	return x.re.MatchString(s) != x.negate
+}
+
+// filterIn reports whether a value is an element of a list.
+type filterIn struct {
+	left, right filterExpr
+}
+
+func (x *filterIn) eval(e Entry) any {
+	left := x.left.eval(e)
+	v := reflect.ValueOf(x.right.eval(e))
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return false
+	}
+	for i := 0; i < v.Len(); i++ {
+		if filterEqual(left, normalizeFilterValue(v.Index(i))) {
+			return true
+		}
+	}
+	return false
+}
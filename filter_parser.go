@@ -0,0 +1,467 @@
+package historitor
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// filterTokenKind is the kind of a token of a filter expression.
+type filterTokenKind int
+
+const (
+	filterTokenEOF filterTokenKind = iota
+	filterTokenIdent
+	filterTokenString
+	filterTokenNumber
+	filterTokenOperator
+)
+
+// filterToken is a token of a filter expression.
+type filterToken struct {
+	kind filterTokenKind
+	// text is the text of the token in the expression.
+	text string
+	// offset is the byte offset of the token in the expression.
+	offset int
+	// value is the value of string and number literals.
+	value any
+}
+
+// filterOperators holds the operators and punctuation of filter expressions, longest first.
+var filterOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ",",
+	".", "-"}
+
+// filterComparisonOperators holds the comparison operators of filter expressions, except in.
+var filterComparisonOperators = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "=~": true, "!~": true,
+}
+
+// lexFilter splits a filter expression into tokens. The last token is always of kind filterTokenEOF.
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		r, size := utf8.DecodeRuneInString(expr[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '_' || unicode.IsLetter(r):
+			j := i + size
+			for j < len(expr) {
+				r, size := utf8.DecodeRuneInString(expr[j:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				j += size
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenIdent, text: expr[i:j], offset: i})
+			i = j
+		case r >= '0' && r <= '9':
+			j := i + scanFilterNumber(expr[i:])
+			v, err := strconv.ParseFloat(expr[i:j], 64)
+			if err != nil {
+				return nil, &FilterError{Offset: i, Reason: fmt.Sprintf("invalid number %s", expr[i:j])}
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenNumber, text: expr[i:j], offset: i, value: v})
+			i = j
+		case r == '"' || r == '`':
+			j := i + 1
+			for j < len(expr) && expr[j] != byte(r) && expr[j] != '\n' {
+				if r == '"' && expr[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(expr) || expr[j] != byte(r) {
+				return nil, &FilterError{Offset: i, Reason: "unterminated string"}
+			}
+			v, err := strconv.Unquote(expr[i : j+1])
+			if err != nil {
+				return nil, &FilterError{Offset: i, Reason: "invalid string " + expr[i:j+1]}
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenString, text: expr[i : j+1], offset: i, value: v})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range filterOperators {
+				if strings.HasPrefix(expr[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &FilterError{Offset: i, Reason: fmt.Sprintf("unexpected character %q", r)}
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenOperator, text: op, offset: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, filterToken{kind: filterTokenEOF, offset: len(expr)}), nil
+}
+
+// scanFilterNumber returns the length of the number literal at the start of s.
+func scanFilterNumber(s string) int {
+	digits := func(i int) int {
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i
+	}
+	i := digits(0)
+	if i+1 < len(s) && s[i] == '.' && s[i+1] >= '0' && s[i+1] <= '9' {
+		i = digits(i + 1)
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if k := digits(j); k > j {
+			i = k
+		}
+	}
+	return i
+}
+
+// filterType is the type of a filter expression, as far as it is known when the filter is compiled.
+type filterType int
+
+const (
+	// filterTypeDynamic is the type of expressions whose type is only known when evaluated, such as field accesses.
+	filterTypeDynamic filterType = iota
+	filterTypeBool
+	filterTypeNumber
+	filterTypeString
+	filterTypeNull
+	filterTypeList
+)
+
+func (t filterType) String() string {
+	switch t {
+	case filterTypeBool:
+		return "boolean"
+	case filterTypeNumber:
+		return "number"
+	case filterTypeString:
+		return "string"
+	case filterTypeNull:
+		return "null"
+	case filterTypeList:
+		return "list"
+	}
+	return "dynamic"
+}
+
+// filterOperand is a parsed filter expression, along with its type and offset in the filter.
+type filterOperand struct {
+	expr   filterExpr
+	typ    filterType
+	offset int
+}
+
+// filterParser is a recursive descent parser of filter expressions. The grammar, from the lowest to the highest
+// precedence, is:
+//
+//	or         = and { "||" and }
+//	and        = comparison { "&&" comparison }
+//	comparison = unary [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "=~" | "!~" | "in" ) unary ]
+//	unary      = ( "!" | "-" ) unary | primary
+//	primary    = string | number | "true" | "false" | "null" | path | "(" or ")" | "[" [ or { "," or } ] "]"
+//	path       = ( "payload" | "key" ) { "." ident | "[" ( string | number ) "]" }
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// peek returns the next token without consuming it.
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token.
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != filterTokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isOperator reports whether t is the given operator.
+func (t filterToken) isOperator(op string) bool {
+	return t.kind == filterTokenOperator && t.text == op
+}
+
+// unexpectedFilterToken returns an error reporting t as unexpected.
+func unexpectedFilterToken(t filterToken) error {
+	if t.kind == filterTokenEOF {
+		return &FilterError{Offset: t.offset, Reason: "unexpected end of filter"}
+	}
+	return &FilterError{Offset: t.offset, Reason: fmt.Sprintf("unexpected %s", t.text)}
+}
+
+// expect consumes the next token, which must be the given operator.
+func (p *filterParser) expect(op string) error {
+	t := p.next()
+	if !t.isOperator(op) {
+		if t.kind == filterTokenEOF {
+			return &FilterError{Offset: t.offset, Reason: fmt.Sprintf("expected %s", op)}
+		}
+		return &FilterError{Offset: t.offset, Reason: fmt.Sprintf("expected %s, found %s", op, t.text)}
+	}
+	return nil
+}
+
+// requireFilterType returns an error if the type of o is known and not one of types.
+func requireFilterType(o filterOperand, what string, types ...filterType) error {
+	if o.typ == filterTypeDynamic {
+		return nil
+	}
+	for _, t := range types {
+		if o.typ == t {
+			return nil
+		}
+	}
+	return &FilterError{Offset: o.offset, Reason: fmt.Sprintf("%s, found %s", what, o.typ)}
+}
+
+func (p *filterParser) parseOr() (filterOperand, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *filterParser) parseAnd() (filterOperand, error) {
+	return p.parseLogical("&&", p.parseComparison)
+}
+
+// parseLogical parses a sequence of operands separated by the logical operator op.
+func (p *filterParser) parseLogical(op string, operand func() (filterOperand, error)) (filterOperand, error) {
+	left, err := operand()
+	if err != nil {
+		return left, err
+	}
+	for p.peek().isOperator(op) {
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return right, err
+		}
+		for _, o := range []filterOperand{left, right} {
+			err = requireFilterType(o, "operand of "+op+" must be a boolean", filterTypeBool)
+			if err != nil {
+				return o, err
+			}
+		}
+		left = filterOperand{
+			expr:   &filterLogical{and: op == "&&", left: left.expr, right: right.expr},
+			typ:    filterTypeBool,
+			offset: left.offset,
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseComparison() (filterOperand, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return left, err
+	}
+	t := p.peek()
+	var op string
+	switch {
+	case t.kind == filterTokenOperator && filterComparisonOperators[t.text]:
+		op = t.text
+	case t.kind == filterTokenIdent && t.text == "in":
+		op = t.text
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseUnary()
+	if err != nil {
+		return right, err
+	}
+	result := filterOperand{typ: filterTypeBool, offset: left.offset}
+
+	switch op {
+	case "==", "!=":
+		for _, o := range []filterOperand{left, right} {
+			if o.typ == filterTypeList {
+				return o, &FilterError{Offset: o.offset, Reason: "lists cannot be compared, use in"}
+			}
+		}
+		if left.typ != filterTypeDynamic && right.typ != filterTypeDynamic && left.typ != filterTypeNull &&
+			right.typ != filterTypeNull && left.typ != right.typ {
+			return result, &FilterError{
+				Offset: t.offset,
+				Reason: fmt.Sprintf("mismatched types %s and %s", left.typ, right.typ),
+			}
+		}
+		result.expr = &filterCompare{op: op, left: left.expr, right: right.expr}
+	case "<", "<=", ">", ">=":
+		for _, o := range []filterOperand{left, right} {
+			err = requireFilterType(o, "operand of "+op+" must be a number or string", filterTypeNumber,
+				filterTypeString)
+			if err != nil {
+				return o, err
+			}
+		}
+		if left.typ != filterTypeDynamic && right.typ != filterTypeDynamic && left.typ != right.typ {
+			return result, &FilterError{
+				Offset: t.offset,
+				Reason: fmt.Sprintf("mismatched types %s and %s", left.typ, right.typ),
+			}
+		}
+		result.expr = &filterCompare{op: op, left: left.expr, right: right.expr}
+	case "=~", "!~":
+		err = requireFilterType(left, "left operand of "+op+" must be a string", filterTypeString)
+		if err != nil {
+			return left, err
+		}
+		var pattern string
+		isString := false
+		if lit, ok := right.expr.(*filterLiteral); ok {
+			pattern, isString = lit.v.(string)
+		}
+		if !isString {
+			return right, &FilterError{Offset: right.offset, Reason: "right operand of " + op + " must be a string literal"}
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return right, &FilterError{Offset: right.offset, Reason: fmt.Sprintf("invalid regular expression: %s", err)}
+		}
+		result.expr = &filterMatch{left: left.expr, re: re, negate: op == "!~"}
+	case "in":
+		err = requireFilterType(right, "right operand of in must be a list", filterTypeList)
+		if err != nil {
+			return right, err
+		}
+		result.expr = &filterIn{left: left.expr, right: right.expr}
+	}
+	return result, nil
+}
+
+func (p *filterParser) parseUnary() (filterOperand, error) {
+	t := p.peek()
+	switch {
+	case t.isOperator("!"):
+		p.next()
+		o, err := p.parseUnary()
+		if err != nil {
+			return o, err
+		}
+		err = requireFilterType(o, "operand of ! must be a boolean", filterTypeBool)
+		if err != nil {
+			return o, err
+		}
+		return filterOperand{expr: &filterNot{x: o.expr}, typ: filterTypeBool, offset: t.offset}, nil
+	case t.isOperator("-"):
+		p.next()
+		o, err := p.parseUnary()
+		if err != nil {
+			return o, err
+		}
+		err = requireFilterType(o, "operand of - must be a number", filterTypeNumber)
+		if err != nil {
+			return o, err
+		}
+		if lit, ok := o.expr.(*filterLiteral); ok {
+			return filterOperand{expr: &filterLiteral{v: -lit.v.(float64)}, typ: filterTypeNumber, offset: t.offset}, nil
+		}
+		return filterOperand{expr: &filterNegate{x: o.expr}, typ: filterTypeNumber, offset: t.offset}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterOperand, error) {
+	t := p.next()
+	switch t.kind {
+	case filterTokenString:
+		return filterOperand{expr: &filterLiteral{v: t.value}, typ: filterTypeString, offset: t.offset}, nil
+	case filterTokenNumber:
+		return filterOperand{expr: &filterLiteral{v: t.value}, typ: filterTypeNumber, offset: t.offset}, nil
+	case filterTokenIdent:
+		switch t.text {
+		case "true", "false":
+			return filterOperand{expr: &filterLiteral{v: t.text == "true"}, typ: filterTypeBool, offset: t.offset}, nil
+		case "null":
+			return filterOperand{expr: &filterLiteral{}, typ: filterTypeNull, offset: t.offset}, nil
+		case "payload", "key":
+			return p.parsePath(t)
+		}
+		return filterOperand{}, &FilterError{Offset: t.offset, Reason: fmt.Sprintf("unknown identifier %s", t.text)}
+	case filterTokenOperator:
+		switch t.text {
+		case "(":
+			o, err := p.parseOr()
+			if err != nil {
+				return o, err
+			}
+			err = p.expect(")")
+			if err != nil {
+				return o, err
+			}
+			o.offset = t.offset
+			return o, nil
+		case "[":
+			list := &filterList{}
+			for !p.peek().isOperator("]") {
+				if len(list.items) > 0 {
+					err := p.expect(",")
+					if err != nil {
+						return filterOperand{}, err
+					}
+				}
+				o, err := p.parseOr()
+				if err != nil {
+					return o, err
+				}
+				list.items = append(list.items, o.expr)
+			}
+			p.next()
+			return filterOperand{expr: list, typ: filterTypeList, offset: t.offset}, nil
+		}
+	}
+	return filterOperand{}, unexpectedFilterToken(t)
+}
+
+// parsePath parses the field accesses following the identifier root.
+func (p *filterParser) parsePath(root filterToken) (filterOperand, error) {
+	path := &filterPath{root: root.text}
+	for {
+		t := p.peek()
+		switch {
+		case t.isOperator("."):
+			p.next()
+			field := p.next()
+			if field.kind != filterTokenIdent {
+				return filterOperand{}, &FilterError{Offset: field.offset, Reason: "expected field name after ."}
+			}
+			path.steps = append(path.steps, field.text)
+		case t.isOperator("["):
+			p.next()
+			step := p.next()
+			switch step.kind {
+			case filterTokenString:
+				path.steps = append(path.steps, step.value)
+			case filterTokenNumber:
+				f := step.value.(float64)
+				if f != math.Trunc(f) || f > math.MaxInt32 {
+					return filterOperand{}, &FilterError{Offset: step.offset, Reason: "index must be an integer"}
+				}
+				path.steps = append(path.steps, int(f))
+			default:
+				return filterOperand{}, &FilterError{Offset: step.offset, Reason: "expected string or number in []"}
+			}
+			err := p.expect("]")
+			if err != nil {
+				return filterOperand{}, err
+			}
+		default:
+			return filterOperand{expr: path, typ: filterTypeDynamic, offset: root.offset}, nil
+		}
+	}
+}
@@ -0,0 +1,294 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// filterTestRequest is a struct payload used to test filters.
+type filterTestRequest struct {
+	Level     string
+	LatencyMS int `json:"latency_ms"`
+	Tags      []string
+	Meta      map[string]any
+	Parent    *filterTestRequest
+	private   string
+}
+
+func TestCompileFilter_errors(t *testing.T) {
+	tests := []struct {
+		expr   string
+		offset int
+	}{
+		{expr: "", offset: 0},
+		{expr: "payload.level ==", offset: 16},
+		{expr: `payload.level == "error`, offset: 17},
+		{expr: "payload.level # 1", offset: 14},
+		{expr: "level == 1", offset: 0},
+		{expr: "payload.", offset: 8},
+		{expr: "payload[true]", offset: 8},
+		{expr: "payload[1.5]", offset: 8},
+		{expr: "payload[0", offset: 9},
+		{expr: "(payload.a == 1", offset: 15},
+		{expr: "payload.a == 1)", offset: 14},
+		{expr: `payload.a == 1 && "b"`, offset: 18},
+		{expr: `!1`, offset: 1},
+		{expr: `1 == "1"`, offset: 2},
+		{expr: `payload.a < true`, offset: 12},
+		{expr: `1 < "a"`, offset: 2},
+		{expr: `payload.a == [1]`, offset: 13},
+		{expr: `payload.a =~ payload.b`, offset: 13},
+		{expr: `payload.a =~ "("`, offset: 13},
+		{expr: `1 =~ "a"`, offset: 0},
+		{expr: `payload.a in 1`, offset: 13},
+		{expr: `payload.a in [1, 2`, offset: 18},
+		{expr: `payload.a == -"a"`, offset: 14},
+		{expr: `-true`, offset: 1},
+		{expr: `-payload.a == "a"`, offset: 11},
+		{expr: `-`, offset: 1},
+		{expr: `payload.a`, offset: -1},
+		{expr: `"a"`, offset: 0},
+		{expr: `payload.a == 1 payload.b == 2`, offset: 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := CompileFilter(tt.expr)
+			if tt.offset < 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrInvalidFilter)
+			var ferr *FilterError
+			require.ErrorAs(t, err, &ferr)
+			require.Equal(t, tt.offset, ferr.Offset, ferr.Error())
+		})
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	request := filterTestRequest{
+		Level:     "error",
+		LatencyMS: 250,
+		Tags:      []string{"api", "slow"},
+		Meta:      map[string]any{"region": "eu-west-1", "retries": uint8(2), "nil": nil},
+		Parent:    &filterTestRequest{Level: "info"},
+		private:   "secret",
+	}
+	var decoded any
+	require.NoError(t, json.Unmarshal([]byte(`{"level": "error", "latency_ms": 250, "tags": ["api", "slow"]}`), &decoded))
+	entries := map[string]Entry{
+		"struct":  {Key: "request", Payload: request},
+		"pointer": {Key: "request", Payload: &request},
+		"json":    {Key: "request", Payload: decoded},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: `payload.level == "error" && payload.latency_ms > 200`, want: true},
+		{expr: `payload.level == "error" && payload.latency_ms > 300`, want: false},
+		{expr: `payload.level != "error" || payload.latency_ms >= 250`, want: true},
+		{expr: `payload["latency_ms"] <= 250.0`, want: true},
+		{expr: `payload.latency_ms < -1`, want: false},
+		{expr: `!(payload.level == "info")`, want: true},
+		{expr: `payload.level in ["warn", "error"]`, want: true},
+		{expr: `payload.level in ["warn", "info"]`, want: false},
+		{expr: `"slow" in payload.tags`, want: true},
+		{expr: `"fast" in payload.tags`, want: false},
+		{expr: `payload.tags[1] == "slow"`, want: true},
+		{expr: `payload.tags[2] == null`, want: true},
+		{expr: `payload.level =~ "^err"`, want: true},
+		{expr: "payload.level !~ `^err`", want: false},
+		{expr: `payload.missing == null`, want: true},
+		{expr: `payload.missing != null`, want: false},
+		{expr: `payload.missing < 1 || payload.missing >= 1`, want: false},
+		{expr: `payload.level > 1`, want: false},
+		{expr: `key == "request"`, want: true},
+		{expr: `payload.level > "a" && payload.level < "f"`, want: true},
+	}
+	for name, e := range entries {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.expr, func(t *testing.T) {
+				f, err := CompileFilter(tt.expr)
+				require.NoError(t, err)
+				require.Equal(t, tt.want, f.Match(e))
+			})
+		}
+	}
+
+	// fields of structs and maps that are not in the JSON payload
+	structTests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: `payload.Level == "error"`, want: true},
+		{expr: `payload.LatencyMS == 250`, want: true},
+		{expr: `payload.meta.region == "eu-west-1"`, want: true},
+		{expr: `payload.meta.retries == 2`, want: true},
+		{expr: `payload.meta.nil == null`, want: true},
+		{expr: `payload.parent.level == "info"`, want: true},
+		{expr: `payload.parent.parent.level == null`, want: true},
+		{expr: `payload.private == null`, want: true},
+		{expr: `payload.tags[0] == payload.meta.region`, want: false},
+	}
+	for _, tt := range structTests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := CompileFilter(tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, f.Match(entries["pointer"]))
+		})
+	}
+}
+
+// filterTestEmbedded is a struct payload promoting the fields of filterTestRequest through an embedded pointer.
+type filterTestEmbedded struct {
+	*filterTestRequest
+	Name string
+}
+
+// TestFilter_Match_nil_embedded_pointer tests that a field promoted through a nil embedded pointer evaluates to null.
+func TestFilter_Match_nil_embedded_pointer(t *testing.T) {
+	f, err := CompileFilter(`payload.Level == null`)
+	require.NoError(t, err)
+	require.True(t, f.Match(Entry{Payload: filterTestEmbedded{Name: "embedded"}}))
+	require.False(t, f.Match(Entry{Payload: filterTestEmbedded{filterTestRequest: &filterTestRequest{Level: "error"}}}))
+}
+
+func TestFilter_Match_scalars(t *testing.T) {
+	tests := []struct {
+		expr    string
+		payload any
+		want    bool
+	}{
+		{expr: `payload == "value"`, payload: "value", want: true},
+		{expr: `payload`, payload: true, want: true},
+		{expr: `payload`, payload: "true", want: false},
+		{expr: `!payload`, payload: "true", want: false},
+		{expr: `payload && true`, payload: 1, want: false},
+		{expr: `payload == 3`, payload: int64(3), want: true},
+		{expr: `payload == 0.5`, payload: float32(0.5), want: true},
+		{expr: `payload[0] == 1`, payload: [2]int{1, 2}, want: true},
+		{expr: `payload[1] == "one"`, payload: map[int]string{1: "one"}, want: true},
+		{expr: `payload["1"] == "one"`, payload: map[int]string{1: "one"}, want: false},
+		{expr: `payload.a == 1`, payload: map[any]any{"a": 1}, want: true},
+		{expr: `payload == null`, payload: nil, want: true},
+		{expr: `payload == null`, payload: []int(nil), want: true},
+		{expr: `payload.a == null`, payload: 1, want: true},
+		{expr: `-payload == -3`, payload: 3, want: true},
+		{expr: `-payload.a < -1`, payload: map[string]int{"a": 2}, want: true},
+		{expr: `-(payload.a) == --2`, payload: map[string]int{"a": -2}, want: true},
+		{expr: `-payload == null`, payload: "3", want: true},
+		{expr: `-payload.a == null`, payload: 1, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := CompileFilter(tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, f.Match(Entry{Payload: tt.payload}))
+		})
+	}
+}
+
+func TestFilter_Text(t *testing.T) {
+	var config struct {
+		Filter *Filter `json:"filter"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"filter": "payload.level == \"error\""}`), &config))
+	require.True(t, config.Filter.Match(Entry{Payload: map[string]string{"level": "error"}}))
+	require.Equal(t, `payload.level == "error"`, config.Filter.String())
+
+	b, err := json.Marshal(config)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"filter": "payload.level == \"error\""}`, string(b))
+
+	err = json.Unmarshal([]byte(`{"filter": "payload.level =="}`), &config)
+	require.ErrorIs(t, err, ErrInvalidFilter)
+}
+
+// mustCompileFilter compiles expr, failing the test if it is invalid.
+func mustCompileFilter(t *testing.T, expr string) *Filter {
+	t.Helper()
+	f, err := CompileFilter(expr)
+	require.NoError(t, err)
+	return f
+}
+
+func TestLog_Read_filter(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+	for i := 0; i < 10; i++ {
+		_, err = l.Write(map[string]int{"n": i})
+		require.NoError(t, err)
+	}
+	filter := mustCompileFilter(t, "payload.n in [1, 5, 6]")
+
+	entries, err := l.Read("group1", "consumer1", 2, WithReadFilter(filter))
+	require.NoError(t, err)
+	require.Equal(t, []any{map[string]int{"n": 1}, map[string]int{"n": 5}}, entryPayloads(entries))
+	require.Len(t, l.groups["group1"].ListPendingEntries(), 2)
+
+	entries, err = l.Read("group1", "consumer1", 0, WithReadFilter(filter))
+	require.NoError(t, err)
+	require.Equal(t, []any{map[string]int{"n": 6}}, entryPayloads(entries))
+
+	// log entries skipped by the filter are not read by the Consumer group
+	entries, err = l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.Equal(t, l.lastEntry, l.groups["group1"].GetStartAt())
+}
+
+func TestLog_Range_filter(t *testing.T) {
+	l := newRangeTestLog(t)
+	filter := mustCompileFilter(t, `payload != "two"`)
+
+	entries, err := l.Range(StartFromBeginning, StartFromEnd, 2, WithRangeFilter(filter))
+	require.NoError(t, err)
+	require.Equal(t, []any{"one", "three"}, entryPayloads(entries))
+
+	entries, err = l.RevRange(StartFromBeginning, StartFromEnd, 0, WithRangeFilter(filter))
+	require.NoError(t, err)
+	require.Equal(t, []any{"three", "one"}, entryPayloads(entries))
+}
+
+func TestLog_Search_filter(t *testing.T) {
+	l := newSearchTestLog(t)
+	filter := mustCompileFilter(t, "payload >= 5")
+
+	result, err := l.Search(isEven, WithSearchFilter(filter))
+	require.NoError(t, err)
+	require.Equal(t, []any{6, 8}, entryPayloads(result.Entries))
+
+	result, err = l.Search(nil, WithSearchFilter(filter), WithSearchIndex("parity", "odd"))
+	require.NoError(t, err)
+	require.Equal(t, []any{5, 7, 9}, entryPayloads(result.Entries))
+}
+
+func TestWithReadFilter(t *testing.T) {
+	opts := readOptions{}
+	f := mustCompileFilter(t, "true")
+	ro := WithReadFilter(f)
+	ro.apply(&opts)
+	require.Equal(t, f, opts.Filter)
+}
+
+func TestWithRangeFilter(t *testing.T) {
+	opts := rangeOptions{}
+	f := mustCompileFilter(t, "true")
+	ro := WithRangeFilter(f)
+	ro.apply(&opts)
+	require.Equal(t, f, opts.Filter)
+}
+
+func TestWithSearchFilter(t *testing.T) {
+	opts := searchOptions{}
+	f := mustCompileFilter(t, "true")
+	so := WithSearchFilter(f)
+	so.apply(&opts)
+	require.Equal(t, f, opts.Filter)
+}
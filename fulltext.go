@@ -0,0 +1,343 @@
+package historitor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrNoFullTextIndex = fmt.Errorf("full-text index not enabled")
+	ErrInvalidQuery    = fmt.Errorf("invalid query")
+)
+
+// Token is a token of a text, as returned by a [Tokenizer].
+type Token struct {
+	// Text is the text of the token.
+	Text string
+	// Start and End are the byte offsets of the token in the text it was read from. End is exclusive.
+	Start, End int
+}
+
+// Tokenizer splits a text into tokens, in the order they appear in the text.
+type Tokenizer func(text string) []Token
+
+// Stemmer reduces a token to its stem, such that different forms of a word are indexed by the same term. Returning an
+// empty string discards the token, e.g. to discard stop words.
+type Stemmer func(token string) string
+
+// TokenizeWords is the default [Tokenizer]. It splits text into words, a word being a sequence of letters and digits.
+func TokenizeWords(text string) []Token {
+	var tokens []Token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, Token{Text: text[start:i], Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, Token{Text: text[start:], Start: start, End: len(text)})
+	}
+	return tokens
+}
+
+// QueryError is returned when a query cannot be parsed. It wraps [ErrInvalidQuery].
+type QueryError struct {
+	// Offset is the byte offset in the query of the error.
+	Offset int
+	// Reason describes the error.
+	Reason string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s: %s at offset %d", ErrInvalidQuery, e.Reason, e.Offset)
+}
+
+func (e *QueryError) Unwrap() error {
+	return ErrInvalidQuery
+}
+
+// TextPosition is the position of a match in the payload of a log entry.
+type TextPosition struct {
+	// Start and End are the byte offsets of the match in the payload. End is exclusive.
+	Start, End int
+}
+
+// FullTextMatch is a log entry matching a query passed to [Log.FullTextSearch].
+type FullTextMatch struct {
+	// ID is the ID of the log entry.
+	ID EntryID
+	// Positions holds the positions of the terms and phrases of the query matched in the payload of the log entry, in
+	// ascending order.
+	Positions []TextPosition
+}
+
+// fullTextQuery is a parsed full-text query. It is a disjunction of conjunctions of phrases, a phrase being a sequence
+// of terms. A single term is a phrase of length one.
+type fullTextQuery [][][]string
+
+// fullTextIndex is an inverted index of the words of the log entries with string payloads. It is not safe for
+// concurrent use, and is guarded by the treeMux of the log.
+type fullTextIndex struct {
+	opts fullTextOptions
+	// postings maps every term to the log entries holding it, and the positions of the term in the tokens of their
+	// payloads.
+	postings map[string]map[EntryID][]int
+	// docs holds the tokens of the payload of every indexed log entry, with the text of every token replaced by the
+	// term it is indexed by.
+	docs map[EntryID][]Token
+}
+
+// newFullTextIndex creates a new, empty, full-text index with the given options.
+func newFullTextIndex(opts fullTextOptions) *fullTextIndex {
+	return &fullTextIndex{
+		opts:     opts,
+		postings: make(map[string]map[EntryID][]int),
+		docs:     make(map[EntryID][]Token),
+	}
+}
+
+// tokens splits text into tokens using the tokenizer of the index, replacing the text of every token with the term it
+// is indexed by. Tokens discarded by the stemmer are omitted.
+func (x *fullTextIndex) tokens(text string) []Token {
+	tokens := x.opts.Tokenizer(text)
+	out := tokens[:0]
+	for _, tok := range tokens {
+		if x.opts.Lowercase {
+			tok.Text = strings.ToLower(tok.Text)
+		}
+		if x.opts.Stemmer != nil {
+			tok.Text = x.opts.Stemmer(tok.Text)
+		}
+		if tok.Text != "" {
+			out = append(out, tok)
+		}
+	}
+	return out
+}
+
+// terms returns the terms of text, see tokens.
+func (x *fullTextIndex) terms(text string) []string {
+	tokens := x.tokens(text)
+	terms := make([]string, 0, len(tokens))
+	for _, tok := range tokens {
+		terms = append(terms, tok.Text)
+	}
+	return terms
+}
+
+// add indexes the log entry with the given ID, replacing its previous payload in the index, if any. Payloads that are
+// not strings are not indexed.
+func (x *fullTextIndex) add(id EntryID, payload any) {
+	x.remove(id)
+	text, ok := payload.(string)
+	if !ok {
+		return
+	}
+	tokens := x.tokens(text)
+	if len(tokens) == 0 {
+		return
+	}
+	x.docs[id] = tokens
+	for p, tok := range tokens {
+		ids := x.postings[tok.Text]
+		if ids == nil {
+			ids = make(map[EntryID][]int)
+			x.postings[tok.Text] = ids
+		}
+		ids[id] = append(ids[id], p)
+	}
+}
+
+// remove removes the log entry with the given ID from the index.
+func (x *fullTextIndex) remove(id EntryID) {
+	for _, tok := range x.docs[id] {
+		ids := x.postings[tok.Text]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(x.postings, tok.Text)
+		}
+	}
+	delete(x.docs, id)
+}
+
+// reset removes every log entry from the index.
+func (x *fullTextIndex) reset() {
+	clear(x.postings)
+	clear(x.docs)
+}
+
+// parse parses query into a fullTextQuery. Terms are separated by whitespace, and log entries must hold every term to
+// match, unless the terms are separated by OR. Phrases are enclosed in double quotes. AND may be used to separate terms
+// explicitly, and binds tighter than OR.
+//
+// Terms are normalized like the payloads of log entries. Terms that normalize to nothing, such as punctuation, are
+// ignored, and conjunctions holding no other terms match no log entries.
+func (x *fullTextIndex) parse(query string) (fullTextQuery, error) {
+	var q fullTextQuery
+	var conj [][]string
+	// atoms is the number of terms and phrases in the current conjunction, including the ones normalizing to nothing
+	atoms := 0
+	// operator is the offset of the last operator, or -1 if the last token was not an operator
+	operator := -1
+	end := func() {
+		if len(conj) > 0 {
+			q = append(q, conj)
+		}
+		conj = nil
+		atoms = 0
+	}
+	for i := 0; i < len(query); {
+		r, size := utf8.DecodeRuneInString(query[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '"':
+			j := strings.IndexByte(query[i+1:], '"')
+			if j < 0 {
+				return nil, &QueryError{Offset: i, Reason: "unterminated phrase"}
+			}
+			if terms := x.terms(query[i+1 : i+1+j]); len(terms) > 0 {
+				conj = append(conj, terms)
+			}
+			atoms++
+			operator = -1
+			i += j + 2
+		default:
+			j := strings.IndexFunc(query[i:], func(r rune) bool {
+				return unicode.IsSpace(r) || r == '"'
+			})
+			if j < 0 {
+				j = len(query) - i
+			}
+			word := query[i : i+j]
+			switch word {
+			case "OR", "AND":
+				if atoms == 0 || operator >= 0 {
+					return nil, &QueryError{Offset: i, Reason: fmt.Sprintf("%s must follow a term", word)}
+				}
+				if word == "OR" {
+					end()
+				}
+				operator = i
+			default:
+				if terms := x.terms(word); len(terms) > 0 {
+					conj = append(conj, terms)
+				}
+				atoms++
+				operator = -1
+			}
+			i += j
+		}
+	}
+	if operator >= 0 {
+		return nil, &QueryError{Offset: operator, Reason: "operator must be followed by a term"}
+	}
+	if atoms == 0 && len(q) == 0 {
+		return nil, &QueryError{Offset: 0, Reason: "query holds no terms"}
+	}
+	end()
+	return q, nil
+}
+
+// search returns the log entries matching q, in ascending order.
+func (x *fullTextIndex) search(q fullTextQuery) []FullTextMatch {
+	results := make(map[EntryID][]TextPosition)
+	for _, conj := range q {
+		var matched map[EntryID][]TextPosition
+		for i, phrase := range conj {
+			m := x.phrase(phrase)
+			if i == 0 {
+				matched = m
+			} else {
+				for id, positions := range matched {
+					more, ok := m[id]
+					if !ok {
+						delete(matched, id)
+						continue
+					}
+					matched[id] = append(positions, more...)
+				}
+			}
+			if len(matched) == 0 {
+				break
+			}
+		}
+		for id, positions := range matched {
+			results[id] = append(results[id], positions...)
+		}
+	}
+
+	out := make([]FullTextMatch, 0, len(results))
+	for id, positions := range results {
+		slices.SortFunc(positions, func(a, b TextPosition) int {
+			if a.Start != b.Start {
+				return a.Start - b.Start
+			}
+			return a.End - b.End
+		})
+		out = append(out, FullTextMatch{ID: id, Positions: slices.Compact(positions)})
+	}
+	slices.SortFunc(out, func(a, b FullTextMatch) int {
+		return a.ID.Compare(b.ID)
+	})
+	return out
+}
+
+// phrase returns the log entries holding terms in sequence, and the positions of every occurrence of the sequence.
+func (x *fullTextIndex) phrase(terms []string) map[EntryID][]TextPosition {
+	out := make(map[EntryID][]TextPosition)
+	for id, positions := range x.postings[terms[0]] {
+		tokens := x.docs[id]
+		for _, p := range positions {
+			if p+len(terms) > len(tokens) {
+				continue
+			}
+			match := true
+			for i, term := range terms[1:] {
+				if tokens[p+1+i].Text != term {
+					match = false
+					break
+				}
+			}
+			if match {
+				out[id] = append(out[id], TextPosition{Start: tokens[p].Start, End: tokens[p+len(terms)-1].End})
+			}
+		}
+	}
+	return out
+}
+
+// FullTextSearch returns the log entries with string payloads matching query, in ascending order, along with the
+// positions of the matches in their payloads. The full-text index must be enabled with [WithLogFullTextIndex],
+// otherwise [ErrNoFullTextIndex] is returned.
+//
+// The query is a sequence of terms separated by whitespace, and log entries match if they hold every term. Terms may
+// be separated by AND to make this explicit, or by OR to match log entries holding either side, AND binding tighter
+// than OR. A phrase enclosed in double quotes matches log entries holding its terms in sequence:
+//
+//	natural selection OR "struggle for existence"
+//
+// The terms of the query are tokenized, lowercased and stemmed like the payloads of the log entries. A query that
+// cannot be parsed is rejected with a [*QueryError].
+//
+// FullTextSearch is safe for concurrent use.
+func (l *Log) FullTextSearch(query string) ([]FullTextMatch, error) {
+	l.treeMux.RLock()
+	defer l.treeMux.RUnlock()
+
+	if l.fullText == nil {
+		return nil, ErrNoFullTextIndex
+	}
+	q, err := l.fullText.parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return l.fullText.search(q), nil
+}
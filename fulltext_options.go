@@ -0,0 +1,59 @@
+package historitor
+
+type fullTextOptions struct {
+	// Tokenizer splits the text of payloads and queries into tokens.
+	Tokenizer Tokenizer
+	// Lowercase is set if tokens are lowercased before they are stemmed.
+	Lowercase bool
+	// Stemmer reduces tokens to the terms they are indexed by. It may be nil, in which case tokens are indexed as is.
+	Stemmer Stemmer
+}
+
+var defaultFullTextOptions = fullTextOptions{
+	Tokenizer: TokenizeWords,
+	Lowercase: true,
+}
+
+// FullTextOption is an option for configuring the full-text index of a log, see [WithLogFullTextIndex].
+type FullTextOption interface {
+	apply(*fullTextOptions)
+}
+
+// funcFullTextOption is a FullTextOption that calls a function.
+// It is used to wrap a function, so it satisfies the FullTextOption interface.
+type funcFullTextOption struct {
+	f func(*fullTextOptions)
+}
+
+func (fdo *funcFullTextOption) apply(opts *fullTextOptions) {
+	fdo.f(opts)
+}
+
+func newFuncFullTextOption(f func(*fullTextOptions)) *funcFullTextOption {
+	return &funcFullTextOption{
+		f: f,
+	}
+}
+
+// WithFullTextTokenizer sets the [Tokenizer] splitting payloads and queries into tokens. The default is
+// [TokenizeWords].
+func WithFullTextTokenizer(tokenizer Tokenizer) FullTextOption {
+	return newFuncFullTextOption(func(opts *fullTextOptions) {
+		opts.Tokenizer = tokenizer
+	})
+}
+
+// WithFullTextLowercase sets whether tokens are lowercased, making searches case-insensitive. The default is true.
+func WithFullTextLowercase(enabled bool) FullTextOption {
+	return newFuncFullTextOption(func(opts *fullTextOptions) {
+		opts.Lowercase = enabled
+	})
+}
+
+// WithFullTextStemmer sets the [Stemmer] reducing tokens to the terms they are indexed by, e.g. reducing "species" and
+// "specie" to the same term. Tokens are lowercased before they are stemmed. The default is to not stem tokens.
+func WithFullTextStemmer(stemmer Stemmer) FullTextOption {
+	return newFuncFullTextOption(func(opts *fullTextOptions) {
+		opts.Stemmer = stemmer
+	})
+}
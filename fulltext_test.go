@@ -0,0 +1,245 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"github.com/stretchr/testify/require"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeWords(t *testing.T) {
+	tokens := TokenizeWords("On the Origin, of  Species-1859!")
+	require.Equal(t, []Token{
+		{Text: "On", Start: 0, End: 2},
+		{Text: "the", Start: 3, End: 6},
+		{Text: "Origin", Start: 7, End: 13},
+		{Text: "of", Start: 15, End: 17},
+		{Text: "Species", Start: 19, End: 26},
+		{Text: "1859", Start: 27, End: 31},
+	}, tokens)
+	require.Empty(t, TokenizeWords(" ,.! "))
+	require.Equal(t, []Token{{Text: "Æsop", Start: 1, End: 6}}, TokenizeWords(" Æsop"))
+}
+
+// newFullTextTestLog returns a log with the full-text index enabled using the given options, holding a log entry for
+// every payload.
+func newFullTextTestLog(t *testing.T, payloads []any, options ...FullTextOption) (*Log, []EntryID) {
+	t.Helper()
+	l, err := NewLog(WithLogName(t.Name()), WithLogFullTextIndex(options...), WithLogKeyCompaction(true))
+	require.NoError(t, err)
+	ids := make([]EntryID, 0, len(payloads))
+	for _, payload := range payloads {
+		id, err := l.Write(payload)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	return l, ids
+}
+
+// matchedText returns the text matched at every position of match, given the payloads of the log entries.
+func matchedText(match FullTextMatch, payload string) []string {
+	out := make([]string, 0, len(match.Positions))
+	for _, p := range match.Positions {
+		out = append(out, payload[p.Start:p.End])
+	}
+	return out
+}
+
+func TestLog_FullTextSearch(t *testing.T) {
+	payloads := []any{
+		"Natural Selection acts by the preservation of variations",
+		"the struggle for existence",
+		"selection, natural or otherwise; natural selection again",
+		42,
+	}
+	l, ids := newFullTextTestLog(t, payloads)
+	tests := []struct {
+		name  string
+		query string
+		want  map[int][]string
+	}{
+		{name: "term", query: "struggle", want: map[int][]string{1: {"struggle"}}},
+		{name: "case_insensitive", query: "NATURAL", want: map[int][]string{
+			0: {"Natural"},
+			2: {"natural", "natural"},
+		}},
+		{name: "and", query: "natural variations", want: map[int][]string{0: {"Natural", "variations"}}},
+		{name: "explicit_and", query: "natural AND variations", want: map[int][]string{0: {"Natural", "variations"}}},
+		{name: "or", query: "variations OR existence", want: map[int][]string{
+			0: {"variations"},
+			1: {"existence"},
+		}},
+		{name: "and_binds_tighter", query: "natural variations OR struggle", want: map[int][]string{
+			0: {"Natural", "variations"},
+			1: {"struggle"},
+		}},
+		{name: "phrase", query: `"natural selection"`, want: map[int][]string{
+			0: {"Natural Selection"},
+			2: {"natural selection"},
+		}},
+		{name: "phrase_punctuation", query: `"selection natural"`, want: map[int][]string{2: {"selection, natural"}}},
+		{name: "phrase_and_term", query: `"natural selection" again`, want: map[int][]string{
+			2: {"natural selection", "again"},
+		}},
+		{name: "hyphenated_term_is_phrase", query: "struggle-for", want: map[int][]string{1: {"struggle for"}}},
+		{name: "no_match", query: "pigeons", want: map[int][]string{}},
+		{name: "no_terms", query: `"..."`, want: map[int][]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := l.FullTextSearch(tt.query)
+			require.NoError(t, err)
+			got := make(map[int][]string)
+			for _, m := range matches {
+				i := slices.Index(ids, m.ID)
+				require.GreaterOrEqual(t, i, 0)
+				got[i] = matchedText(m, payloads[i].(string))
+			}
+			require.Equal(t, tt.want, got)
+			for i := 1; i < len(matches); i++ {
+				require.Negative(t, matches[i-1].ID.Compare(matches[i].ID))
+			}
+		})
+	}
+}
+
+func TestLog_FullTextSearch_invalid(t *testing.T) {
+	l, _ := newFullTextTestLog(t, nil)
+	tests := []struct {
+		query  string
+		offset int
+	}{
+		{query: "", offset: 0},
+		{query: "   ", offset: 0},
+		{query: `a "unterminated`, offset: 2},
+		{query: "OR a", offset: 0},
+		{query: "a OR", offset: 2},
+		{query: "a AND OR b", offset: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := l.FullTextSearch(tt.query)
+			require.ErrorIs(t, err, ErrInvalidQuery)
+			var qerr *QueryError
+			require.ErrorAs(t, err, &qerr)
+			require.Equal(t, tt.offset, qerr.Offset)
+		})
+	}
+}
+
+func TestLog_FullTextSearch_disabled(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	_, err = l.FullTextSearch("term")
+	require.ErrorIs(t, err, ErrNoFullTextIndex)
+}
+
+func TestLog_FullTextSearch_maintained(t *testing.T) {
+	l, ids := newFullTextTestLog(t, []any{"the origin of species"})
+	count := func(query string) int {
+		t.Helper()
+		matches, err := l.FullTextSearch(query)
+		require.NoError(t, err)
+		return len(matches)
+	}
+	require.Equal(t, 1, count("origin"))
+
+	require.True(t, l.UpdateEntry(ids[0], "the descent of man"))
+	require.Equal(t, 0, count("origin"))
+	require.Equal(t, 1, count("descent"))
+	require.Empty(t, l.fullText.postings["origin"])
+
+	require.True(t, l.UpdateEntry(ids[0], 1))
+	require.Equal(t, 0, count("descent"))
+
+	_, err := l.WriteKey("k", "finches")
+	require.NoError(t, err)
+	_, err = l.WriteKey("k", "pigeons")
+	require.NoError(t, err)
+	require.NoError(t, l.Compact())
+	require.Equal(t, 0, count("finches"))
+	require.Equal(t, 1, count("pigeons"))
+
+	snapshot, _ := newFullTextTestLog(t, []any{"barnacles"})
+	b, err := snapshot.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, l.UnmarshalBinary(b))
+	require.Equal(t, 0, count("pigeons"))
+	require.Equal(t, 1, count("barnacles"))
+}
+
+func TestLog_FullTextSearch_wal(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogFullTextIndex(), WithLogMmap(true))
+	require.NoError(t, err)
+	_, err = l.Write("variation under domestication")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	l, err = NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogFullTextIndex(), WithLogMmap(true))
+	require.NoError(t, err)
+	defer l.Close()
+	matches, err := l.FullTextSearch("domestication")
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+}
+
+func TestLog_FullTextSearch_options(t *testing.T) {
+	// stem plurals, and discard stop words
+	stemmer := func(token string) string {
+		if token == "the" {
+			return ""
+		}
+		return strings.TrimSuffix(token, "s")
+	}
+	l, _ := newFullTextTestLog(t, []any{"The Species", "the specie"},
+		WithFullTextLowercase(false), WithFullTextStemmer(stemmer))
+
+	matches, err := l.FullTextSearch("Species")
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, []TextPosition{{Start: 4, End: 11}}, matches[0].Positions)
+
+	matches, err = l.FullTextSearch("specie")
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+
+	// the stop word is discarded from the query as well, so the phrase matches
+	matches, err = l.FullTextSearch(`"The Species"`)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+
+	tokenizer := func(text string) []Token {
+		return []Token{{Text: text, Start: 0, End: len(text)}}
+	}
+	l, _ = newFullTextTestLog(t, []any{"one token"}, WithFullTextTokenizer(tokenizer))
+	matches, err = l.FullTextSearch(`"one token"`)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	matches, err = l.FullTextSearch("one")
+	require.NoError(t, err)
+	require.Empty(t, matches)
+}
+
+func TestWithFullTextTokenizer(t *testing.T) {
+	opts := fullTextOptions{}
+	fo := WithFullTextTokenizer(TokenizeWords)
+	fo.apply(&opts)
+	require.NotNil(t, opts.Tokenizer)
+}
+
+func TestWithFullTextLowercase(t *testing.T) {
+	opts := fullTextOptions{}
+	fo := WithFullTextLowercase(true)
+	fo.apply(&opts)
+	require.True(t, opts.Lowercase)
+}
+
+func TestWithFullTextStemmer(t *testing.T) {
+	opts := fullTextOptions{}
+	fo := WithFullTextStemmer(strings.ToUpper)
+	fo.apply(&opts)
+	require.NotNil(t, opts.Stemmer)
+}
@@ -2,10 +2,7 @@ module github.com/MadsRC/historitor
 
 go 1.23.4
 
-require (
-	github.com/plar/go-adaptive-radix-tree/v2 v2.0.3
-	github.com/stretchr/testify v1.10.0
-)
+require github.com/stretchr/testify v1.10.0
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -0,0 +1,247 @@
+package historitor
+
+import (
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrNoSuchIndex = fmt.Errorf("no such index")
+	ErrIndexExists = fmt.Errorf("index already exists")
+)
+
+// IndexExtractor extracts the values a log entry is indexed by from its payload. A log entry may be indexed by any
+// number of values, and is not indexed if no values are returned.
+type IndexExtractor func(payload any) []string
+
+// index is a secondary index of the log entries of a log, mapping values extracted from the payloads of the log
+// entries to the IDs of the log entries. It is not safe for concurrent use, and is guarded by the treeMux of the log.
+//
+// An index restored from a snapshot has no extractor until one is attached with [Log.CreateIndex]. Until then, log
+// entries are removed from it, but log entries written or updated are not indexed.
+type index struct {
+	// extract is nil if the index was restored from a snapshot, and no extractor has been attached.
+	extract IndexExtractor
+	// postings maps every value to the IDs of the log entries indexed by it, in ascending order.
+	postings map[string][]EntryID
+	// values maps the ID of every indexed log entry to the values it is indexed by, so the log entry can be removed
+	// from the index without extracting the values from its previous payload.
+	values map[EntryID][]string
+}
+
+// newIndex creates a new, empty, index using the given extractor.
+func newIndex(extract IndexExtractor) *index {
+	return &index{
+		extract:  extract,
+		postings: make(map[string][]EntryID),
+		values:   make(map[EntryID][]string),
+	}
+}
+
+// restoreIndex creates an index without an extractor from the postings of an index encoded in a snapshot.
+func restoreIndex(postings map[string][]EntryID) *index {
+	x := newIndex(nil)
+	for v, ids := range postings {
+		if len(ids) == 0 {
+			continue
+		}
+		x.postings[v] = ids
+		for _, id := range ids {
+			x.values[id] = append(x.values[id], v)
+		}
+	}
+	for _, values := range x.values {
+		slices.Sort(values)
+	}
+	return x
+}
+
+// add indexes the log entry with the given ID by the values extracted from payload, replacing the values it was
+// previously indexed by, if any. If the index has no extractor, the index is left unchanged.
+func (x *index) add(id EntryID, payload any) {
+	if x.extract == nil {
+		return
+	}
+	x.remove(id)
+	values := x.extract(payload)
+	if len(values) == 0 {
+		return
+	}
+	values = slices.Compact(slices.Sorted(slices.Values(values)))
+	for _, v := range values {
+		ids := x.postings[v]
+		// log entries are almost always indexed in order, so check the end of the postings before searching them
+		if len(ids) == 0 || ids[len(ids)-1].Compare(id) < 0 {
+			x.postings[v] = append(ids, id)
+			continue
+		}
+		i, found := slices.BinarySearchFunc(ids, id, EntryID.Compare)
+		if !found {
+			x.postings[v] = slices.Insert(ids, i, id)
+		}
+	}
+	x.values[id] = values
+}
+
+// remove removes the log entry with the given ID from the index.
+func (x *index) remove(id EntryID) {
+	for _, v := range x.values[id] {
+		ids := x.postings[v]
+		i, found := slices.BinarySearchFunc(ids, id, EntryID.Compare)
+		if !found {
+			continue
+		}
+		ids = slices.Delete(ids, i, i+1)
+		if len(ids) == 0 {
+			delete(x.postings, v)
+		} else {
+			x.postings[v] = ids
+		}
+	}
+	delete(x.values, id)
+}
+
+// lookup returns the IDs of the log entries indexed by value, in ascending order. The returned slice must not be
+// modified.
+func (x *index) lookup(value string) []EntryID {
+	return x.postings[value]
+}
+
+// retain removes every log entry for which keep returns false from the index.
+func (x *index) retain(keep func(id EntryID) bool) {
+	for id := range x.values {
+		if !keep(id) {
+			x.remove(id)
+		}
+	}
+}
+
+// reset removes every log entry from the index.
+func (x *index) reset() {
+	clear(x.postings)
+	clear(x.values)
+}
+
+// insert inserts e into the storage of the log, see stored, and adds it to the indexes of the log. It should be called
+// with the treeMux locked.
+func (l *Log) insert(e Entry) error {
+	err := l.entries.Insert(l.stored(e))
+	if err != nil {
+		return err
+	}
+	l.indexEntry(e)
+	return nil
+}
+
+// remove removes the log entry with the given ID from the storage, the indexes and the full-text index of the log. It
+// should be called with the treeMux locked.
+func (l *Log) remove(id EntryID) error {
+	err := l.entries.Delete(id)
+	if err != nil {
+		return err
+	}
+	for _, x := range l.indexes {
+		x.remove(id)
+	}
+	if l.fullText != nil {
+		l.fullText.remove(id)
+	}
+	return nil
+}
+
+// indexEntry adds e to the indexes and the full-text index of the log. It should be called with the treeMux locked.
+func (l *Log) indexEntry(e Entry) {
+	for _, x := range l.indexes {
+		x.add(e.ID, e.Payload)
+	}
+	if l.fullText != nil {
+		l.fullText.add(e.ID, e.Payload)
+	}
+}
+
+// CreateIndex adds a secondary index with the given name to the log, indexing every log entry by the values extract
+// returns for its payload, like [WithLogIndex]. The log entries already in the log are indexed before CreateIndex
+// returns, during which the log is locked.
+//
+// If the log already has an index with the given name, an error wrapping [ErrIndexExists] is returned, unless the
+// index was restored from a snapshot by [Log.ReadFrom] without an extractor. In that case, extract is attached to the
+// index, and the index is rebuilt.
+//
+// extract is called with the lock of the log held, so it must not call any methods of the log.
+func (l *Log) CreateIndex(name string, extract IndexExtractor) error {
+	l.treeMux.Lock()
+	defer l.treeMux.Unlock()
+
+	if x, ok := l.indexes[name]; ok && x.extract != nil {
+		return fmt.Errorf("%w: %s", ErrIndexExists, name)
+	}
+	x := newIndex(extract)
+	var resolveErr error
+	err := l.entries.Ascend(ZeroEntryID, func(e Entry) bool {
+		e, resolveErr = l.resolve(e)
+		if resolveErr != nil {
+			return false
+		}
+		x.add(e.ID, e.Payload)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if resolveErr != nil {
+		return resolveErr
+	}
+	if l.indexes == nil {
+		l.indexes = make(map[string]*index)
+	}
+	l.indexes[name] = x
+	return nil
+}
+
+// LookupIndex returns the IDs of the log entries indexed by value in the index with the given name, in ascending
+// order. If no index with the given name exists, an error wrapping [ErrNoSuchIndex] is returned.
+//
+// LookupIndex is safe for concurrent use.
+func (l *Log) LookupIndex(name, value string) ([]EntryID, error) {
+	l.treeMux.RLock()
+	defer l.treeMux.RUnlock()
+
+	x, ok := l.indexes[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchIndex, name)
+	}
+	return append(make([]EntryID, 0), x.lookup(value)...), nil
+}
+
+// restoreIndexes replaces the indexes of the log restored from a previous snapshot with the indexes encoded in a
+// snapshot, see restoreIndex. Indexes with an extractor are not restored, as they are rebuilt from the payloads of the
+// log entries as the snapshot is read. It should be called with the treeMux locked.
+func (l *Log) restoreIndexes(indexes map[string]map[string][]EntryID) {
+	for name, x := range l.indexes {
+		if x.extract == nil {
+			delete(l.indexes, name)
+		}
+	}
+	if l.indexes == nil {
+		l.indexes = make(map[string]*index, len(indexes))
+	}
+	for name, postings := range indexes {
+		if _, ok := l.indexes[name]; ok {
+			continue
+		}
+		l.indexes[name] = restoreIndex(postings)
+	}
+}
+
+// externalIndexes returns the postings of the indexes of the log, for encoding in a snapshot. The postings are not
+// copied, so it should be called with the treeMux locked, and the postings must not be used once it is unlocked.
+func (l *Log) externalIndexes() map[string]map[string][]EntryID {
+	if len(l.indexes) == 0 {
+		return nil
+	}
+	out := make(map[string]map[string][]EntryID, len(l.indexes))
+	for name, x := range l.indexes {
+		out[name] = x.postings
+	}
+	return out
+}
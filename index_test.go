@@ -0,0 +1,187 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	x := newIndex(func(payload any) []string {
+		return payload.([]string)
+	})
+	x.add(fakeTestEntryID3, []string{"a", "b", "a"})
+	x.add(fakeTestEntryID1, []string{"a"})
+	x.add(fakeTestEntryID2, []string{})
+	require.Equal(t, []EntryID{fakeTestEntryID1, fakeTestEntryID3}, x.lookup("a"))
+	require.Equal(t, []EntryID{fakeTestEntryID3}, x.lookup("b"))
+	require.Empty(t, x.lookup("c"))
+
+	// re-indexing a log entry replaces the values it was indexed by
+	x.add(fakeTestEntryID3, []string{"c"})
+	require.Equal(t, []EntryID{fakeTestEntryID1}, x.lookup("a"))
+	require.Empty(t, x.lookup("b"))
+	require.Equal(t, []EntryID{fakeTestEntryID3}, x.lookup("c"))
+
+	x.remove(fakeTestEntryID1)
+	require.Empty(t, x.lookup("a"))
+	require.NotContains(t, x.postings, "a")
+
+	x.reset()
+	require.Empty(t, x.postings)
+	require.Empty(t, x.values)
+}
+
+func TestRestoreIndex(t *testing.T) {
+	x := restoreIndex(map[string][]EntryID{
+		"a":     {fakeTestEntryID1, fakeTestEntryID3},
+		"b":     {fakeTestEntryID3},
+		"empty": {},
+	})
+	require.Equal(t, []EntryID{fakeTestEntryID1, fakeTestEntryID3}, x.lookup("a"))
+	require.NotContains(t, x.postings, "empty")
+	require.Equal(t, []string{"a", "b"}, x.values[fakeTestEntryID3])
+
+	// without an extractor, log entries are not re-indexed, but can still be removed
+	x.add(fakeTestEntryID1, "payload")
+	require.Equal(t, []EntryID{fakeTestEntryID1, fakeTestEntryID3}, x.lookup("a"))
+	x.retain(func(id EntryID) bool {
+		return id != fakeTestEntryID3
+	})
+	require.Equal(t, []EntryID{fakeTestEntryID1}, x.lookup("a"))
+	require.Empty(t, x.lookup("b"))
+}
+
+func TestLog_CreateIndex(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err = l.Write(i)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, l.CreateIndex("parity", parity))
+	even, err := l.LookupIndex("parity", "even")
+	require.NoError(t, err)
+	require.Len(t, even, 2)
+
+	// the index is maintained once created
+	id, err := l.Write(4)
+	require.NoError(t, err)
+	even, err = l.LookupIndex("parity", "even")
+	require.NoError(t, err)
+	require.Len(t, even, 3)
+	require.Equal(t, id, even[2])
+
+	err = l.CreateIndex("parity", parity)
+	require.ErrorIs(t, err, ErrIndexExists)
+}
+
+func TestLog_CreateIndex_mmap(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(t.TempDir()), WithLogMmap(true))
+	require.NoError(t, err)
+	defer l.Close()
+	for i := 0; i < 4; i++ {
+		_, err = l.Write(i)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, l.CreateIndex("parity", parity))
+	odd, err := l.LookupIndex("parity", "odd")
+	require.NoError(t, err)
+	require.Len(t, odd, 2)
+}
+
+func TestLog_LookupIndex(t *testing.T) {
+	l := newSearchTestLog(t)
+
+	ids, err := l.LookupIndex("parity", "none")
+	require.NoError(t, err)
+	require.NotNil(t, ids)
+	require.Empty(t, ids)
+
+	_, err = l.LookupIndex("missing", "value")
+	require.ErrorIs(t, err, ErrNoSuchIndex)
+
+	// the IDs returned are a copy of the index
+	ids, err = l.LookupIndex("parity", "even")
+	require.NoError(t, err)
+	ids[0] = ZeroEntryID
+	ids, err = l.LookupIndex("parity", "even")
+	require.NoError(t, err)
+	require.Equal(t, NewEntryID(searchTestEpoch, 0), ids[0])
+}
+
+func TestLog_ReadFrom_indexes(t *testing.T) {
+	l := newSearchTestLog(t)
+	require.NoError(t, l.CreateIndex("empty", func(any) []string { return nil }))
+	want, err := l.LookupIndex("parity", "even")
+	require.NoError(t, err)
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+
+	// without an extractor, the indexes are restored from the snapshot
+	var restored Log
+	require.NoError(t, restored.UnmarshalBinary(b))
+	got, err := restored.LookupIndex("parity", "even")
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	_, err = restored.LookupIndex("empty", "value")
+	require.NoError(t, err)
+	id, err := restored.Write(10)
+	require.NoError(t, err)
+	got, err = restored.LookupIndex("parity", "even")
+	require.NoError(t, err)
+	require.NotContains(t, got, id)
+
+	// attaching an extractor rebuilds the index
+	require.NoError(t, restored.CreateIndex("parity", parity))
+	got, err = restored.LookupIndex("parity", "even")
+	require.NoError(t, err)
+	require.Equal(t, append(want, id), got)
+
+	// with an extractor, the index is rebuilt from the log entries
+	rebuilt, err := NewLog(WithLogIndex("parity", func(payload any) []string {
+		return []string{"rebuilt"}
+	}))
+	require.NoError(t, err)
+	require.NoError(t, rebuilt.UnmarshalBinary(b))
+	got, err = rebuilt.LookupIndex("parity", "rebuilt")
+	require.NoError(t, err)
+	require.Len(t, got, 10)
+
+	// indexes restored from an earlier snapshot are replaced
+	empty, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	b, err = empty.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, restored.UnmarshalBinary(b))
+	_, err = restored.LookupIndex("empty", "value")
+	require.ErrorIs(t, err, ErrNoSuchIndex)
+}
+
+func TestLog_ReadFrom_indexes_truncated(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogIndex("all", func(any) []string { return []string{"all"} }))
+	require.NoError(t, err)
+	var ids []EntryID
+	for _, payload := range []string{"first", "second", "third"} {
+		id, err := l.Write(payload)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+	i := bytes.Index(b, []byte("second"))
+	require.Positive(t, i)
+	b[i] ^= 0x01
+
+	// the restored index only holds the log entries preceding the corruption
+	l2, err := NewLog(WithLogTruncateCorrupted(true))
+	require.NoError(t, err)
+	require.NoError(t, l2.UnmarshalBinary(b))
+	got, err := l2.LookupIndex("all", "all")
+	require.NoError(t, err)
+	require.Equal(t, ids[:1], got)
+}
@@ -0,0 +1,116 @@
+package historitor
+
+import (
+	"iter"
+)
+
+// iteratorChunkSize is the number of log entries the iterators of a log read each time they acquire the lock of the
+// log.
+const iteratorChunkSize = 256
+
+// All returns an iterator over the IDs and payloads of the log entries in the log, in ascending order. It is
+// equivalent to calling [Log.Between] with [StartFromBeginning] and [StartFromEnd].
+func (l *Log) All() iter.Seq2[EntryID, any] {
+	return l.Between(StartFromBeginning, StartFromEnd)
+}
+
+// Between returns an iterator over the IDs and payloads of the log entries with IDs between start and end, inclusive,
+// in ascending order. The special IDs [StartFromBeginning] and [StartFromEnd] can be used as either bound, like with
+// [Log.Range].
+//
+// The iterator does not hold the lock of the log while yielding, so the log may be changed while iterating, including
+// from the body of the loop. The bounds are determined when iteration starts, so log entries written after iteration
+// started are not yielded. Log entries are read in chunks, so log entries removed or updated while iterating may or may
+// not be observed.
+//
+// If reading the log fails, e.g. as the payload of a log entry cannot be read, see [WithLogMmap], iteration stops
+// early, which cannot be told apart from reaching the end of the log. Use [Log.Entries] to observe the error.
+//
+// Like [Log.Range], the iterator does not change the Pending Entries List of any Consumer group.
+func (l *Log) Between(start, end EntryID) iter.Seq2[EntryID, any] {
+	return payloads(l.Entries(start, end))
+}
+
+// Backward returns an iterator over the IDs and payloads of the log entries in the log, in descending order, starting
+// with the last log entry at the time iteration starts. The iterator behaves like the one returned by [Log.Between]
+// under concurrent changes to the log and when reading the log fails. Use [Log.EntriesBackward] to observe errors.
+func (l *Log) Backward() iter.Seq2[EntryID, any] {
+	return payloads(l.EntriesBackward(StartFromBeginning, StartFromEnd))
+}
+
+// Entries returns an iterator over the log entries with IDs between start and end, inclusive, in ascending order,
+// paired with the error reading them. If reading the log fails, the error is yielded along with the zero Entry, and
+// iteration stops. Otherwise, the iterator behaves like the one returned by [Log.Between]:
+//
+//	for e, err := range log.Entries(historitor.StartFromBeginning, historitor.StartFromEnd) {
+//		if err != nil {
+//			return err
+//		}
+//		// ...
+//	}
+func (l *Log) Entries(start, end EntryID) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		l.treeMux.RLock()
+		from, to, _ := l.rangeBounds(start, end, nil)
+		l.treeMux.RUnlock()
+		exclusive := false
+		for {
+			entries, err := l.Range(from, to, iteratorChunkSize, WithRangeExclusiveStart(exclusive))
+			if err != nil {
+				yield(Entry{}, err)
+				return
+			}
+			for _, e := range entries {
+				if !yield(e, nil) {
+					return
+				}
+			}
+			if len(entries) < iteratorChunkSize {
+				return
+			}
+			from = entries[len(entries)-1].ID
+			exclusive = true
+		}
+	}
+}
+
+// EntriesBackward returns an iterator over the log entries with IDs between start and end, inclusive, in descending
+// order, starting with the log entry at end. Otherwise, the iterator behaves like the one returned by [Log.Entries],
+// and the bounds are given in the same order.
+func (l *Log) EntriesBackward(start, end EntryID) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		l.treeMux.RLock()
+		from, to, _ := l.rangeBounds(start, end, nil)
+		l.treeMux.RUnlock()
+		exclusive := false
+		for {
+			entries, err := l.RevRange(from, to, iteratorChunkSize, WithRangeExclusiveEnd(exclusive))
+			if err != nil {
+				yield(Entry{}, err)
+				return
+			}
+			for _, e := range entries {
+				if !yield(e, nil) {
+					return
+				}
+			}
+			if len(entries) < iteratorChunkSize {
+				return
+			}
+			to = entries[len(entries)-1].ID
+			exclusive = true
+		}
+	}
+}
+
+// payloads returns an iterator over the IDs and payloads of the log entries yielded by entries, stopping at the first
+// error.
+func payloads(entries iter.Seq2[Entry, error]) iter.Seq2[EntryID, any] {
+	return func(yield func(EntryID, any) bool) {
+		for e, err := range entries {
+			if err != nil || !yield(e.ID, e.Payload) {
+				return
+			}
+		}
+	}
+}
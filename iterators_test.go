@@ -0,0 +1,160 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"iter"
+	"testing"
+)
+
+func TestLog_All(t *testing.T) {
+	l := newRangeTestLog(t)
+	var ids []EntryID
+	var payloads []any
+	for id, payload := range l.All() {
+		ids = append(ids, id)
+		payloads = append(payloads, payload)
+	}
+	require.Equal(t, []EntryID{fakeTestEntryID1, fakeTestEntryID2, fakeTestEntryID3}, ids)
+	require.Equal(t, []any{"one", "two", "three"}, payloads)
+
+	// breaking out of the loop stops the iteration
+	n := 0
+	for range l.All() {
+		n++
+		break
+	}
+	require.Equal(t, 1, n)
+}
+
+func TestLog_Between(t *testing.T) {
+	l := newRangeTestLog(t)
+	var payloads []any
+	for _, payload := range l.Between(fakeTestEntryID2, StartFromEnd) {
+		payloads = append(payloads, payload)
+	}
+	require.Equal(t, []any{"two", "three"}, payloads)
+}
+
+func TestLog_Backward(t *testing.T) {
+	l := newRangeTestLog(t)
+	var payloads []any
+	for _, payload := range l.Backward() {
+		payloads = append(payloads, payload)
+	}
+	require.Equal(t, []any{"three", "two", "one"}, payloads)
+}
+
+// TestLog_All_chunks tests that the iterators yield every log entry when the log holds more than one chunk, and that
+// log entries written while iterating are not yielded.
+func TestLog_All_chunks(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	var want []EntryID
+	for i := 0; i < iteratorChunkSize*2+10; i++ {
+		id, err := l.Write(i)
+		require.NoError(t, err)
+		want = append(want, id)
+	}
+
+	var got []EntryID
+	for id := range l.All() {
+		got = append(got, id)
+		// writing from the body of the loop does not deadlock
+		_, err := l.Write("late")
+		require.NoError(t, err)
+	}
+	require.Equal(t, want, got)
+
+	got = got[:0]
+	for id := range l.Backward() {
+		got = append(got, id)
+	}
+	require.Len(t, got, len(want)*2)
+	require.Equal(t, want[0], got[len(got)-1])
+}
+
+func TestLog_Entries(t *testing.T) {
+	l := newRangeTestLog(t)
+	var ids []EntryID
+	for e, err := range l.Entries(fakeTestEntryID2, StartFromEnd) {
+		require.NoError(t, err)
+		ids = append(ids, e.ID)
+	}
+	require.Equal(t, []EntryID{fakeTestEntryID2, fakeTestEntryID3}, ids)
+
+	ids = nil
+	for e, err := range l.EntriesBackward(StartFromBeginning, fakeTestEntryID2) {
+		require.NoError(t, err)
+		ids = append(ids, e.ID)
+	}
+	require.Equal(t, []EntryID{fakeTestEntryID2, fakeTestEntryID1}, ids)
+}
+
+// failingStorage is a Storage failing to read entries once err is set.
+type failingStorage struct {
+	*MemoryStorage
+	err error
+}
+
+func (s *failingStorage) Ascend(from EntryID, fn func(entry Entry) bool) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.MemoryStorage.Ascend(from, fn)
+}
+
+func (s *failingStorage) Descend(from EntryID, fn func(entry Entry) bool) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.MemoryStorage.Descend(from, fn)
+}
+
+// TestLog_Entries_error tests that an error reading the log is yielded by Entries and EntriesBackward, and stops the
+// iterators that cannot report it.
+func TestLog_Entries_error(t *testing.T) {
+	storage := &failingStorage{MemoryStorage: NewMemoryStorage()}
+	l, err := NewLog(WithLogName(t.Name()), WithLogStorage(storage))
+	require.NoError(t, err)
+	for i := 0; i < iteratorChunkSize+1; i++ {
+		_, err := l.Write(i)
+		require.NoError(t, err)
+	}
+	errRead := errors.New("read failed")
+
+	for name, entries := range map[string]func() iter.Seq2[Entry, error]{
+		"Entries": func() iter.Seq2[Entry, error] {
+			return l.Entries(StartFromBeginning, StartFromEnd)
+		},
+		"EntriesBackward": func() iter.Seq2[Entry, error] {
+			return l.EntriesBackward(StartFromBeginning, StartFromEnd)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			storage.err = nil
+			n := 0
+			var got error
+			for _, err := range entries() {
+				if err != nil {
+					got = err
+					continue
+				}
+				n++
+				// the first chunk is read, then reading the second chunk fails
+				storage.err = errRead
+			}
+			require.Equal(t, iteratorChunkSize, n)
+			require.ErrorIs(t, got, errRead)
+		})
+	}
+
+	storage.err = errRead
+	n := 0
+	for range l.All() {
+		n++
+	}
+	require.Zero(t, n)
+}
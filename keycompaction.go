@@ -0,0 +1,121 @@
+package historitor
+
+import (
+	"time"
+)
+
+// trackKey records that the log entry e was written to the log, superseding the log entry previously written with the
+// same key. Log entries may be tracked out of order, in which case e may be superseded itself. trackKey does nothing
+// unless key compaction is enabled. It should be called with the treeMux locked.
+func (l *Log) trackKey(e Entry) {
+	if !l.keyCompaction || e.Key == "" {
+		return
+	}
+	prev, ok := l.keys[e.Key]
+	switch {
+	case !ok:
+		l.keys[e.Key] = e.ID
+	case prev.Compare(e.ID) < 0:
+		l.superseded[prev] = struct{}{}
+		l.keys[e.Key] = e.ID
+	case prev != e.ID:
+		l.superseded[e.ID] = struct{}{}
+	}
+}
+
+// pending reports whether the log entry with the given ID is pending in any Consumer group. It should be called with
+// the treeMux locked.
+func (l *Log) pending(id EntryID) bool {
+	for _, g := range l.groups {
+		if _, ok := g.GetPendingEntry(id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compactKeys removes the log entries superseded by a later log entry with the same key, except those pending in a
+// Consumer group, which are removed by a later compaction once they are no longer pending. Every removal is appended
+// to the write-ahead log as a walOpDelete record. It returns the number of log entries removed.
+func (l *Log) compactKeys() (int, error) {
+	l.treeMux.Lock()
+	defer l.treeMux.Unlock()
+
+	// the log entries to remove are determined before the batch is started, as a Consumer group holds its own lock
+	// while appending to the write-ahead log
+	var ids []EntryID
+	for id := range l.superseded {
+		if !l.pending(id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	removed := 0
+	err := l.wal.appendBatch(func(add func(rec walRecord) error) error {
+		for _, id := range ids {
+			err := add(walRecord{Op: walOpDelete, ID: id})
+			if err != nil {
+				return err
+			}
+			err = l.remove(id)
+			if err != nil {
+				return err
+			}
+			delete(l.superseded, id)
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// compact removes superseded log entries, if key compaction is enabled, and compacts the write-ahead log, see
+// [Log.Compact]. If force is not set, only the segments of the write-ahead log holding enough superseded records are
+// compacted. The work done is added to the compaction statistics of the log.
+func (l *Log) compact(force bool) error {
+	if !l.keyCompaction && l.wal == nil {
+		return nil
+	}
+	l.compactMut.Lock()
+	defer l.compactMut.Unlock()
+
+	start := time.Now()
+	var stats CompactionStats
+	var err error
+	if l.keyCompaction {
+		stats.EntriesRemoved, err = l.compactKeys()
+	}
+	if err == nil {
+		err = l.wal.compact(force, &stats, l.journalProducers)
+	}
+
+	l.statsMut.Lock()
+	defer l.statsMut.Unlock()
+	l.compactionStats.Runs++
+	l.compactionStats.EntriesRemoved += stats.EntriesRemoved
+	l.compactionStats.SegmentsCompacted += stats.SegmentsCompacted
+	l.compactionStats.SegmentsRemoved += stats.SegmentsRemoved
+	l.compactionStats.RecordsRemoved += stats.RecordsRemoved
+	l.compactionStats.BytesReclaimed += stats.BytesReclaimed
+	l.compactionStats.LastRun = start
+	l.compactionStats.LastDuration = time.Since(start)
+	l.compactionStats.LastError = err
+	return err
+}
+
+// compactEvery compacts the log every interval, until the log is closed.
+func (l *Log) compactEvery(interval time.Duration) {
+	defer l.background.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-t.C:
+			_ = l.compact(false)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLog_Compact_keys(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogKeyCompaction(true))
+	require.NoError(t, err)
+	_, err = l.WriteKey("a", "a1")
+	require.NoError(t, err)
+	_, err = l.WriteKey("b", "b1")
+	require.NoError(t, err)
+	_, err = l.Write("no key")
+	require.NoError(t, err)
+	id, err := l.WriteKey("a", "a2")
+	require.NoError(t, err)
+	_, err = l.WriteKey("a", "a3")
+	require.NoError(t, err)
+
+	require.NoError(t, l.Compact())
+	requirePayloads(t, l, "b1", "no key", "a3")
+	require.Equal(t, 2, l.CompactionStats().EntriesRemoved)
+	require.Empty(t, l.superseded)
+	_, ok, err := l.entries.Search(id)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestLog_Compact_keys_disabled(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	id, err := l.WriteKey("a", "a1")
+	require.NoError(t, err)
+	_, err = l.WriteKey("a", "a2")
+	require.NoError(t, err)
+
+	require.NoError(t, l.Compact())
+	requirePayloads(t, l, "a1", "a2")
+	e, ok, err := l.entries.Search(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "a", e.Key)
+}
+
+// TestLog_Compact_keys_pending tests that superseded log entries are kept while they are pending in a Consumer group.
+func TestLog_Compact_keys_pending(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogKeyCompaction(true))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+	id, err := l.WriteKey("a", "a1")
+	require.NoError(t, err)
+	entries, err := l.Read("group1", "consumer1", 1)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	_, err = l.WriteKey("a", "a2")
+	require.NoError(t, err)
+
+	require.NoError(t, l.Compact())
+	requirePayloads(t, l, "a1", "a2")
+	require.Zero(t, l.CompactionStats().EntriesRemoved)
+
+	require.NoError(t, l.Acknowledge("group1", "consumer1", id))
+	require.NoError(t, l.Compact())
+	requirePayloads(t, l, "a2")
+	require.Equal(t, 1, l.CompactionStats().EntriesRemoved)
+}
+
+// TestLog_Compact_keys_start_at tests that a Consumer group whose start at entry ID was removed by key compaction
+// keeps reading the log entries after it.
+func TestLog_Compact_keys_start_at(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogKeyCompaction(true))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	cg := NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))
+	require.NoError(t, l.AddGroup(cg))
+	id, err := l.WriteKey("a", "a1")
+	require.NoError(t, err)
+	entries, err := l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NoError(t, l.Acknowledge("group1", "consumer1", id))
+	_, err = l.WriteKey("a", "a2")
+	require.NoError(t, err)
+
+	require.NoError(t, l.Compact())
+	require.Equal(t, 1, l.CompactionStats().EntriesRemoved)
+	require.Equal(t, id, cg.GetStartAt())
+	entries, err = l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "a2", entries[0].Payload)
+}
+
+// TestLog_Compact_keys_wal tests that removed log entries stay removed when the write-ahead log is replayed, and that
+// the records writing, updating and deleting them are removed from the write-ahead log.
+func TestLog_Compact_keys_wal(t *testing.T) {
+	dir := t.TempDir()
+	// every record is appended to its own segment
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogSegmentMaxBytes(1), WithLogKeyCompaction(true))
+	require.NoError(t, err)
+	id, err := l.WriteKey("a", "a1")
+	require.NoError(t, err)
+	require.True(t, l.UpdateEntry(id, "a1-b"))
+	_, err = l.WriteKey("a", "a2")
+	require.NoError(t, err)
+
+	// the first compaction removes the log entry, the second the record deleting it
+	require.NoError(t, l.Compact())
+	requirePayloads(t, l, "a2")
+	require.Contains(t, l.wal.deleted, id)
+	_, err = l.WriteKey("b", "b1")
+	require.NoError(t, err)
+	require.NoError(t, l.Compact())
+	require.NotContains(t, l.wal.deleted, id)
+	stats := l.CompactionStats()
+	require.Equal(t, 1, stats.EntriesRemoved)
+	require.Equal(t, 3, stats.RecordsRemoved)
+	require.Equal(t, 3, stats.SegmentsRemoved)
+	_, err = l.wal.lookup(id)
+	require.ErrorIs(t, err, ErrNoSuchEntry)
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogKeyCompaction(true))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	requirePayloads(t, l2, "a2", "b1")
+	_, err = l2.WriteKey("b", "b2")
+	require.NoError(t, err)
+	require.NoError(t, l2.Compact())
+	requirePayloads(t, l2, "a2", "b2")
+}
+
+// TestLog_Compact_keys_update_kept tests that the write-ahead log can be replayed when the record writing a removed log
+// entry has been removed, but an update of the log entry has not.
+func TestLog_Compact_keys_update_kept(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogSegmentMaxBytes(1), WithLogKeyCompaction(true))
+	require.NoError(t, err)
+	id, err := l.WriteKey("a", "a1")
+	require.NoError(t, err)
+	require.True(t, l.UpdateEntry(id, "a1-b"))
+	_, err = l.WriteKey("a", "a2")
+	require.NoError(t, err)
+	_, err = l.compactKeys()
+	require.NoError(t, err)
+	_, err = l.WriteKey("b", "b1")
+	require.NoError(t, err)
+	// only compact the segment holding the record writing the log entry
+	var stats CompactionStats
+	require.NoError(t, l.wal.compactSegment(l.wal.segments[0], &stats))
+	require.Equal(t, 1, stats.SegmentsRemoved)
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogKeyCompaction(true))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	requirePayloads(t, l2, "a2", "b1")
+}
+
+// TestLog_ReadFrom_keys tests that keys survive a snapshot, and that log entries superseded in the snapshot are removed
+// by the next compaction.
+func TestLog_ReadFrom_keys(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	_, err = l.WriteKey("a", "a1")
+	require.NoError(t, err)
+	id, err := l.WriteKey("a", "a2")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = l.WriteTo(&buf)
+	require.NoError(t, err)
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogKeyCompaction(true))
+	require.NoError(t, err)
+	_, err = l2.ReadFrom(&buf)
+	require.NoError(t, err)
+	require.NoError(t, l2.Compact())
+	requirePayloads(t, l2, "a2")
+	e, ok, err := l2.entries.Search(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "a", e.Key)
+}
@@ -2,22 +2,27 @@ package historitor
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
 	"errors"
 	"fmt"
-	"github.com/plar/go-adaptive-radix-tree/v2"
+	"math"
 	"sync"
 	"time"
 )
 
 var (
-	ErrNoSuchGroup    = fmt.Errorf("no such Consumer group")
-	ErrNoSuchConsumer = fmt.Errorf("no such Consumer")
-	ErrNoSuchEntry    = fmt.Errorf("no such entry")
-	ErrNoMoreEntries  = fmt.Errorf("no more entries")
+	ErrNoSuchGroup      = fmt.Errorf("no such Consumer group")
+	ErrNoSuchConsumer   = fmt.Errorf("no such Consumer")
+	ErrNoSuchEntry      = fmt.Errorf("no such entry")
+	ErrNoMoreEntries    = fmt.Errorf("no more entries")
+	ErrIDNotMonotonic   = fmt.Errorf("entry ID is not greater than the ID of the last entry")
+	ErrRevisionMismatch = fmt.Errorf("entry revision mismatch")
 )
 
 // externalLog is used to represent a Log in a way that can easily be encoded and decoded using the gob package.
+//
+// Entries is only set in logs encoded before [Log.WriteTo] was introduced. Since then, the log entries follow the
+// externalLog in the gob stream, one [Entry] at a time.
 type externalLog struct {
 	Name                   string
 	Groups                 map[string]*ConsumerGroup
@@ -27,9 +32,14 @@ type externalLog struct {
 	MaxPendingAge          time.Duration
 	MaxDeliveryCount       int
 	AttemptRedeliveryAfter time.Duration
+	// Indexes holds the postings of the secondary indexes of the log by name, see index.
+	Indexes map[string]map[string][]EntryID
+	// Producers holds the deduplication state of the idempotent producers of the log by producer ID, see producer.
+	Producers map[string]externalProducer
 }
 
-// Log is a transactional log that allows for multiple readers and writers. It is backed by an in-memory radix tree.
+// Log is a transactional log that allows for multiple readers and writers. Log entries are kept in a [Storage], which
+// by default is an in-memory B-tree, see [MemoryStorage].
 //
 // Instances of [Log] should have their [Log.Cleanup] method called periodically to ensure that non-acknowledged log
 // entries are released for re-delivery.
@@ -37,15 +47,50 @@ type Log struct {
 	name                   string
 	groups                 map[string]*ConsumerGroup
 	treeMux                sync.RWMutex
-	entries                art.Tree
+	entries                Storage
 	firstEntry             EntryID
 	lastEntry              EntryID
 	maxPendingAge          time.Duration
 	maxDeliveryCount       int
 	attemptRedeliveryAfter time.Duration
+	truncateCorrupted      bool
+	wal                    *wal
+	// clock determines the time held by the IDs of new log entries. A nil clock means [time.Now] is used.
+	clock Clock
+	// diskPayloads is set if payloads are read from the write-ahead log, in which case the entries hold walPayload in
+	// place of their payloads.
+	diskPayloads bool
+	// keyCompaction is set if superseded log entries are removed when the log is compacted. keys maps every key to
+	// the ID of the latest log entry written with it, and superseded holds the IDs of the log entries superseded by a
+	// later log entry with the same key. They are only maintained if keyCompaction is set.
+	keyCompaction bool
+	keys          map[string]EntryID
+	superseded    map[EntryID]struct{}
+	// compactMut serializes compactions, and statsMut guards compactionStats.
+	compactMut      sync.Mutex
+	statsMut        sync.Mutex
+	compactionStats CompactionStats
+	// indexes holds the secondary indexes of the log by name.
+	indexes map[string]*index
+	// fullText is the full-text index of the log. It is nil if the full-text index is disabled.
+	fullText *fullTextIndex
+	// producers holds the deduplication state of the producers writing with [Log.WriteIdempotent] by producer ID, and
+	// dedupWindow is the number of sequence numbers remembered per producer.
+	producers   map[string]*producer
+	dedupWindow int
+	// written is notified whenever log entries are written to the log, waking up the goroutines waiting in
+	// [Log.ReadContext].
+	written broadcast
+	// stop is closed when the log is closed, stopping the goroutines in background.
+	stop       chan struct{}
+	stopOnce   sync.Once
+	background sync.WaitGroup
 }
 
 // NewLog creates a new log with the provided options.
+//
+// If the write-ahead log is enabled with [WithLogWAL], it is replayed before NewLog returns. Logs using a write-ahead
+// log should be closed with [Log.Close] when no longer needed.
 func NewLog(options ...LogOption) (*Log, error) {
 	opts := defaultLogOptions
 	for _, opt := range GlobalLogOptions {
@@ -55,31 +100,429 @@ func NewLog(options ...LogOption) (*Log, error) {
 		opt.apply(&opts)
 	}
 
-	return &Log{
+	l := &Log{
 		name:                   opts.Name,
 		maxPendingAge:          opts.MaxPendingAge,
 		maxDeliveryCount:       opts.MaxDeliveryCount,
 		attemptRedeliveryAfter: opts.AttemptRedeliveryAfter,
+		truncateCorrupted:      opts.TruncateCorrupted,
+		keyCompaction:          opts.KeyCompaction,
 		groups:                 make(map[string]*ConsumerGroup),
 		treeMux:                sync.RWMutex{},
-		entries:                art.New(),
-	}, nil
+		entries:                opts.Storage,
+		clock:                  opts.Clock,
+		producers:              make(map[string]*producer),
+		dedupWindow:            opts.DedupWindow,
+		stop:                   make(chan struct{}),
+	}
+	if l.entries == nil {
+		l.entries = NewMemoryStorage()
+	}
+	l.indexes = make(map[string]*index, len(opts.Indexes))
+	for name, extract := range opts.Indexes {
+		l.indexes[name] = newIndex(extract)
+	}
+	if opts.FullText != nil {
+		l.fullText = newFullTextIndex(*opts.FullText)
+	}
+	if l.keyCompaction {
+		l.keys = make(map[string]EntryID)
+		l.superseded = make(map[EntryID]struct{})
+	}
+
+	if opts.WALDir != "" {
+		w, err := openWAL(opts)
+		if err != nil {
+			return nil, err
+		}
+		l.diskPayloads = opts.Mmap
+		err = w.replay(l.apply)
+		if err != nil {
+			_ = w.close()
+			return nil, err
+		}
+		l.wal = w
+		for _, g := range l.groups {
+			g.wal = w
+		}
+	}
+	if opts.CompactionInterval > 0 {
+		l.background.Add(1)
+		go l.compactEvery(opts.CompactionInterval)
+	}
+
+	return l, nil
+}
+
+// apply applies a record from the write-ahead log to the log. It is used when replaying the write-ahead log, and
+// must be called before the log is attached to the write-ahead log, as it would otherwise journal the changes again.
+func (l *Log) apply(rec walRecord) error {
+	// records of Consumer groups that are not in the log are skipped, as they may have been appended by a Consumer
+	// group that was removed, or replaced by ReadFrom, while the record was being appended
+	g, hasGroup := l.groups[rec.Group]
+
+	switch rec.Op {
+	case walOpWrite:
+		e := Entry{ID: rec.ID, Key: rec.Key, Payload: rec.Payload, Revision: rec.Revision}
+		err := l.insert(e)
+		if err != nil {
+			return err
+		}
+		l.lastEntry = rec.ID
+		l.trackKey(e)
+		l.trackProducer(rec)
+	case walOpUpdateEntry:
+		e, ok, err := l.entries.Search(rec.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// the record writing the log entry was removed by compaction, as the log entry was deleted later
+			return nil
+		}
+		e.Payload = rec.Payload
+		// superseded updates may have been removed by compaction, and records appended before log entries carried
+		// revisions have none
+		e.Revision = max(e.Revision+1, rec.Revision)
+		err = l.insert(e)
+		if err != nil {
+			return err
+		}
+	case walOpDelete:
+		err := l.remove(rec.ID)
+		if err != nil {
+			return err
+		}
+		delete(l.superseded, rec.ID)
+	case walOpAddGroup:
+		g := NewConsumerGroup()
+		err := g.UnmarshalBinary(rec.State)
+		if err != nil {
+			return err
+		}
+		l.groups[g.name] = g
+	case walOpRemoveGroup:
+		delete(l.groups, rec.Group)
+	case walOpSetStartAt:
+		if hasGroup {
+			g.startAt = rec.ID
+		}
+	case walOpAddMember:
+		if hasGroup {
+			g.members[rec.Consumer] = NewConsumer(WithConsumerName(rec.Consumer))
+		}
+	case walOpRemoveMember:
+		if hasGroup {
+			delete(g.members, rec.Consumer)
+		}
+	case walOpAddPendingEntry:
+		if hasGroup {
+			g.pel[rec.Pending.ID] = rec.Pending
+		}
+	case walOpRemovePendingEntry:
+		if hasGroup {
+			delete(g.pel, rec.ID)
+		}
+	case walOpSetProducer:
+		return l.applyProducer(rec)
+	case walOpReset:
+		l.groups = make(map[string]*ConsumerGroup)
+		l.lastEntry = ZeroEntryID
+		return l.clearEntries()
+	default:
+		return fmt.Errorf("unknown WAL operation %d", rec.Op)
+	}
+	return nil
+}
+
+// Close stops the background compactor, if any, and flushes the write-ahead log of the log, if any, to stable
+// storage and closes it. If an earlier change to the log could not be appended to the write-ahead log, that error is
+// returned.
+//
+// The log must not be used after Close has been called. Calling Close again does nothing, and returns the same error
+// as the first call.
+func (l *Log) Close() error {
+	l.stopOnce.Do(func() {
+		if l.stop != nil {
+			close(l.stop)
+		}
+	})
+	// the write-ahead log is closed before waiting for the background compactor, as closing it interrupts a
+	// compaction of the write-ahead log in progress
+	l.treeMux.Lock()
+	err := l.wal.close()
+	l.treeMux.Unlock()
+	l.background.Wait()
+	return err
+}
+
+// Sync flushes the write-ahead log of the log to stable storage, regardless of the [SyncPolicy] of the log. Once Sync
+// returns, every change made to the log before Sync was called is durable. If the write-ahead log is not enabled,
+// Sync does nothing.
+//
+// Sync is safe for concurrent use.
+func (l *Log) Sync() error {
+	return l.wal.sync()
+}
+
+// Compact compacts the log. If [WithLogKeyCompaction] is set, the log entries superseded by a later log entry with
+// the same key are removed, except those pending in a Consumer group. The write-ahead log is then compacted, removing
+// every record superseded by a later record from the sealed segments, regardless of how many records of a segment are
+// superseded. See [WithLogCompactionInterval] for compacting the log in the background. If neither key compaction nor
+// the write-ahead log is enabled, Compact does nothing.
+//
+// Compact only blocks other methods of the log while removing superseded log entries, and is safe for concurrent use.
+func (l *Log) Compact() error {
+	return l.compact(true)
+}
+
+// CompactionStats returns statistics about the compactions of the log.
+//
+// CompactionStats is safe for concurrent use.
+func (l *Log) CompactionStats() CompactionStats {
+	l.statsMut.Lock()
+	defer l.statsMut.Unlock()
+	return l.compactionStats
 }
 
 // Size returns the number of log entries in the log.
 func (l *Log) Size() int {
+	l.treeMux.RLock()
+	defer l.treeMux.RUnlock()
 	return l.entries.Size()
 }
 
+// walPayload is kept in the Storage of a log in place of the payload of a log entry, when payloads are read from the
+// write-ahead log, see [WithLogMmap].
+type walPayload struct{}
+
+// stored returns e as it should be kept in the Storage of the log.
+func (l *Log) stored(e Entry) Entry {
+	if l.diskPayloads {
+		e.Payload = walPayload{}
+	}
+	return e
+}
+
+// resolve returns e with its payload, reading the payload from the write-ahead log if it is not kept in the Storage of
+// the log. It should be called with the treeMux locked.
+func (l *Log) resolve(e Entry) (Entry, error) {
+	if _, ok := e.Payload.(walPayload); !ok {
+		return e, nil
+	}
+	payload, err := l.wal.payload(e.ID)
+	if err != nil {
+		return e, fmt.Errorf("failed to read payload of log entry %s: %w", e.ID, err)
+	}
+	e.Payload = payload
+	return e, nil
+}
+
+// clearEntries removes all log entries from the storage of the log, along with the keys, indexes and deduplication
+// state kept for them. It is not safe for concurrent use and should be called with the treeMux locked.
+func (l *Log) clearEntries() error {
+	var ids []EntryID
+	err := l.entries.Ascend(ZeroEntryID, func(e Entry) bool {
+		ids = append(ids, e.ID)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err = l.entries.Delete(id)
+		if err != nil {
+			return err
+		}
+	}
+	clear(l.keys)
+	clear(l.superseded)
+	clear(l.producers)
+	for _, x := range l.indexes {
+		x.reset()
+	}
+	if l.fullText != nil {
+		l.fullText.reset()
+	}
+	return nil
+}
+
 // Write writes a new log entry to the log. It returns the ID of the log entry.
 //
+// The ID holds the current time, as determined by the [Clock] set with [WithLogClock]. IDs are always ascending: if
+// the clock is at or before the time of the last log entry, e.g. because the wall clock was stepped backwards, the ID
+// holds the time of the last log entry and the sequence number following it, much like in Redis Streams.
+//
+// If the write-ahead log is enabled, the log entry is appended to it before Write returns. If that fails, the log
+// entry is discarded and an error is returned. Write also waits for the log entry to be flushed to stable storage, as
+// determined by the [SyncPolicy] of the log. If flushing fails, the ID of the log entry is returned along with the
+// error, as the log entry has been written, but may not survive a crash.
+//
 // Write is safe for concurrent use.
-func (l *Log) Write(payload any) EntryID {
+func (l *Log) Write(payload any) (EntryID, error) {
+	return l.WriteKey("", payload)
+}
+
+// WriteKey writes a new log entry with the given key to the log. It returns the ID of the log entry. If
+// [WithLogKeyCompaction] is set, writing the log entry supersedes the log entry previously written with the same key,
+// which is removed when the log is next compacted. An empty key means the log entry has no key, in which case WriteKey
+// behaves like [Log.Write].
+//
+// WriteKey is safe for concurrent use.
+func (l *Log) WriteKey(key string, payload any) (EntryID, error) {
+	l.treeMux.Lock()
+	id, err := l.writeEntry(key, payload)
+	l.treeMux.Unlock()
+	if err != nil {
+		return ZeroEntryID, err
+	}
+	l.written.notify()
+	// wait without holding the lock, allowing concurrent writes to share a flush
+	return id, l.wal.wait()
+}
+
+// WriteWithID writes a new log entry with the given ID to the log, e.g. when replaying log entries from another system
+// while keeping their original IDs. The ID must be greater than the ID of every log entry previously written to the
+// log, otherwise an error wrapping [ErrIDNotMonotonic] is returned.
+//
+// If id is a partial EntryID, see [NewPartialEntryID], the sequence number is chosen by the log: it is one greater than
+// the sequence number of the last log entry if that was written in the same millisecond, and zero otherwise.
+//
+// Like [Log.Write], WriteWithID appends the log entry to the write-ahead log, if enabled, and waits for it to be
+// flushed to stable storage.
+//
+// WriteWithID is safe for concurrent use.
+func (l *Log) WriteWithID(id EntryID, payload any) error {
+	l.treeMux.Lock()
+	err := l.writeEntryWithID(id, payload)
+	l.treeMux.Unlock()
+	if err != nil {
+		return err
+	}
+	l.written.notify()
+	return l.wal.wait()
+}
+
+// writeEntryWithID writes a new log entry with the given ID to the log, see [Log.WriteWithID]. It is not safe for
+// concurrent use and should be called with the treeMux locked.
+func (l *Log) writeEntryWithID(id EntryID, payload any) error {
+	if id.partial {
+		id.partial = false
+		if id.time.Equal(l.lastEntry.time) {
+			if l.lastEntry.seq == math.MaxUint64 {
+				return fmt.Errorf("%w: no sequence number left after %s", ErrIDNotMonotonic, l.lastEntry)
+			}
+			id.seq = l.lastEntry.seq + 1
+		}
+	}
+	// the zero time is reserved for the special IDs, such as StartFromBeginning
+	if id.time.IsZero() || id.Compare(l.lastEntry) <= 0 {
+		return fmt.Errorf("%w: %s is not greater than %s", ErrIDNotMonotonic, id, l.lastEntry)
+	}
+	_, exists, err := l.entries.Search(id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: %s already exists", ErrIDNotMonotonic, id)
+	}
+	_, err = l.writeEntryAt(walRecord{ID: id, Payload: payload})
+	return err
+}
+
+// writeEntry writes a new log entry to the log and appends it to the write-ahead log. It is not safe for concurrent use
+// and should be called with the treeMux locked.
+func (l *Log) writeEntry(key string, payload any) (EntryID, error) {
+	return l.writeEntryAt(walRecord{ID: l.nextEntryID(), Key: key, Payload: payload})
+}
+
+// writeEntryAt writes a new log entry to the log and appends it to the write-ahead log. The log entry is described by
+// rec, which is appended to the write-ahead log as a walOpWrite record. If a log entry with the ID of rec already
+// exists, the sequence number is incremented, see write. It is not safe for concurrent use and should be called with
+// the treeMux locked.
+func (l *Log) writeEntryAt(rec walRecord) (EntryID, error) {
+	prev := l.lastEntry
+	err := l.write(&rec.ID, rec.Key, rec.Payload)
+	if err != nil {
+		return ZeroEntryID, err
+	}
+	rec.Op = walOpWrite
+	err = l.wal.append(rec)
+	if err != nil {
+		// nobody can have observed the entry, as we are still holding the lock
+		l.lastEntry = prev
+		return ZeroEntryID, errors.Join(err, l.remove(rec.ID))
+	}
+	l.trackKey(Entry{ID: rec.ID, Key: rec.Key})
+	l.trackProducer(rec)
+	return rec.ID, nil
+}
+
+// WriteBatch writes a new log entry to the log for every payload, in order. It returns the IDs of the log entries,
+// which are ascending and contiguous: every ID has the sequence number following the one of the previous ID, rolling
+// over to the following millisecond once the sequence numbers of a millisecond are exhausted.
+//
+// The log entries are written atomically: no log entry of the batch can be read before every log entry of the batch
+// has been written, and if writing any of them fails, none of them are written and an error is returned. If the
+// write-ahead log is enabled, the log entries are appended to it as a single batch, which is discarded in its entirety
+// when the write-ahead log is replayed if the process crashed before the whole batch was appended. Like [Log.Write],
+// WriteBatch waits for the batch to be flushed to stable storage, returning the IDs along with the error if that fails.
+//
+// WriteBatch is safe for concurrent use.
+func (l *Log) WriteBatch(payloads []any) ([]EntryID, error) {
+	if len(payloads) == 0 {
+		return nil, nil
+	}
 	l.treeMux.Lock()
-	id := NewEntryID(time.Now().Truncate(time.Millisecond).UTC(), 0)
-	l.write(&id, payload)
+	ids, err := l.writeBatch(payloads)
 	l.treeMux.Unlock()
-	return id
+	if err != nil {
+		return nil, err
+	}
+	l.written.notify()
+	return ids, l.wal.wait()
+}
+
+// writeBatch writes a new log entry to the log for every payload and appends them to the write-ahead log as a single
+// batch. It is not safe for concurrent use and should be called with the treeMux locked.
+func (l *Log) writeBatch(payloads []any) ([]EntryID, error) {
+	prev := l.lastEntry
+	ids := make([]EntryID, 0, len(payloads))
+	discard := func(err error) ([]EntryID, error) {
+		// nobody can have observed the entries, as we are still holding the lock
+		l.lastEntry = prev
+		errs := []error{err}
+		for _, id := range ids {
+			errs = append(errs, l.remove(id))
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	id := l.nextEntryID()
+	for _, payload := range payloads {
+		err := l.write(&id, "", payload)
+		if err != nil {
+			return discard(err)
+		}
+		ids = append(ids, id)
+		id = id.next()
+	}
+	err := l.wal.appendBatch(func(add func(rec walRecord) error) error {
+		for i, id := range ids {
+			err := add(walRecord{Op: walOpWrite, ID: id, Payload: payloads[i], Continued: i < len(ids)-1})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return discard(err)
+	}
+	for _, id := range ids {
+		l.trackKey(Entry{ID: id})
+	}
+	return ids, nil
 }
 
 // write is not safe for concurrent use. It should be called with the treeMux locked.
@@ -87,18 +530,23 @@ func (l *Log) Write(payload any) EntryID {
 // increment the sequence number and try again by calling itself.
 //
 // The time of the EntryID is truncated to milliseconds.
-func (l *Log) write(id *EntryID, payload any) {
+func (l *Log) write(id *EntryID, key string, payload any) error {
 	id.time = id.time.Truncate(time.Millisecond)
-	ov, upd := l.entries.Insert(art.Key(id.String()), payload)
-	if upd {
-		// restore the value we just overwrote
-		l.entries.Insert(art.Key(id.String()), ov)
+	_, exists, err := l.entries.Search(*id)
+	if err != nil {
+		return err
+	}
+	if exists {
 		// increment the sequence number and try again
 		id.seq++
-		l.write(id, payload)
+		return l.write(id, key, payload)
+	}
+	err = l.insert(Entry{ID: *id, Key: key, Payload: payload})
+	if err != nil {
+		return err
 	}
 	l.lastEntry = *id
-	return
+	return nil
 }
 
 // Read reads up to maxMessages log entries from the log. If maxMessages is 0, it will read all log entries.
@@ -114,8 +562,19 @@ func (l *Log) write(id *EntryID, payload any) {
 //
 // If there are no more events to read from the log, the method will return an empty slice.
 //
+// Log entries are read from after the start at entry ID of the Consumer group, whether or not a log entry with that
+// ID exists, e.g. as it was removed by key compaction, see [WithLogKeyCompaction], or set with
+// [ConsumerGroup.SetStartAtTime].
+//
+// Only log entries matching a [Filter] are read if one is set with [WithReadFilter].
+//
 // Read is safe for concurrent use.
-func (l *Log) Read(g, c string, maxMessages int) ([]Entry, error) {
+func (l *Log) Read(g, c string, maxMessages int, options ...ReadOption) ([]Entry, error) {
+	opts := defaultReadOptions
+	for _, opt := range options {
+		opt.apply(&opts)
+	}
+
 	group, ok := l.getGroup(g)
 	if !ok {
 		return nil, fmt.Errorf("%w: %s", ErrNoSuchGroup, g)
@@ -139,32 +598,104 @@ func (l *Log) Read(g, c string, maxMessages int) ([]Entry, error) {
 		return out, nil
 	}
 	// no more pending entries, read from log
-	out, err = l.addEntries(group, *consumer, maxMessages, out)
+	out, last, err := l.addEntries(group, *consumer, maxMessages, opts.Filter, out)
 	if err != nil {
-		if !errors.Is(err, ErrNoMoreEntries) {
-			return nil, err
-		}
+		return nil, err
 	}
-	if len(out) > 0 && !errors.Is(err, ErrNoMoreEntries) {
-		// update the startAt for the group to the last entry read if we actually read something off the log
-		group.SetStartAt(out[len(out)-1].ID)
+	if !last.IsZero() {
+		// update the startAt for the group to the last entry read if we actually read something off the log, including
+		// log entries skipped by the filter
+		group.SetStartAt(last)
+	}
+	err = l.wal.Err()
+	if err != nil {
+		return nil, err
 	}
 
 	return out, nil
 }
 
+// ReadContext reads up to maxMessages log entries from the log, like [Log.Read]. Unlike Read, if there are no log
+// entries to read, ReadContext blocks until a log entry is written to the log, a log entry pending for the Consumer
+// becomes eligible for re-delivery, or ctx is done, in which case the error of ctx is returned. Use
+// [context.WithTimeout] to bound the time ReadContext blocks. The options are those of Read.
+//
+// ReadContext is safe for concurrent use.
+func (l *Log) ReadContext(ctx context.Context, g, c string, maxMessages int, options ...ReadOption) ([]Entry, error) {
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return nil, err
+		}
+		// the channel is obtained before reading, so log entries written after reading wake us up
+		written := l.written.wait()
+		entries, err := l.Read(g, c, maxMessages, options...)
+		if err != nil || len(entries) > 0 {
+			return entries, err
+		}
+
+		var timer *time.Timer
+		var redeliver <-chan time.Time
+		if at, ok := l.nextRedelivery(g, c); ok {
+			timer = time.NewTimer(time.Until(at))
+			redeliver = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-written:
+		case <-redeliver:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// nextRedelivery returns the time at which the first log entry pending for the Consumer becomes eligible for
+// re-delivery by [Log.Read]. If no log entry pending for the Consumer will become eligible, it returns false.
+func (l *Log) nextRedelivery(g, c string) (time.Time, bool) {
+	l.treeMux.RLock()
+	group, ok := l.groups[g]
+	maxDeliveryCount := l.maxDeliveryCount
+	attemptRedeliveryAfter := l.attemptRedeliveryAfter
+	l.treeMux.RUnlock()
+	if !ok {
+		return time.Time{}, false
+	}
+	var next time.Time
+	for _, pe := range group.GetPendingEntriesForConsumer(c) {
+		if pe.DeliveryCount >= maxDeliveryCount {
+			continue
+		}
+		// Read re-delivers log entries pending for longer than attemptRedeliveryAfter
+		at := pe.DeliveredAt.Add(attemptRedeliveryAfter + time.Nanosecond)
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next, !next.IsZero()
+}
+
 func (l *Log) addPendingEntries(group *ConsumerGroup, consumer Consumer, maxMessages int, entries []Entry) ([]Entry, error) {
 	for _, pe := range group.GetPendingEntriesForConsumer(consumer.name) {
 		if time.Since(pe.DeliveredAt) > l.attemptRedeliveryAfter && pe.DeliveryCount < l.maxDeliveryCount {
 			group.AddPendingEntry(pe.ID, consumer.name)
-			p, ok := l.entries.Search(art.Key(pe.ID.String()))
+			e, ok, err := l.entries.Search(pe.ID)
+			if err != nil {
+				return entries, err
+			}
 			if !ok {
 				return entries, fmt.Errorf("couldn't locate PEL entry in log: %w: %s", ErrNoSuchEntry, pe.ID)
 			}
-			entries = append(entries, Entry{
-				ID:      pe.ID,
-				Payload: p,
-			})
+			e, err = l.resolve(e)
+			if err != nil {
+				return entries, err
+			}
+			entries = append(entries, e)
 			if maxMessages > 0 && len(entries) >= maxMessages {
 				break
 			}
@@ -174,43 +705,54 @@ func (l *Log) addPendingEntries(group *ConsumerGroup, consumer Consumer, maxMess
 	return entries, nil
 }
 
-func (l *Log) addEntries(group *ConsumerGroup, consumer Consumer, maxMessages int, entries []Entry) ([]Entry, error) {
-	var iter art.Iterator
-	if group.GetStartAt() == StartFromBeginning {
-		iter = l.entries.Iterator()
-	} else {
-		iter = newIterateFrom(art.Key(group.GetStartAt().String()), l.entries.Iterator())
+// addEntries adds log entries after the startAt of the group to entries. The startAt itself is not included, whether
+// or not a log entry with that ID exists, and a startAt of [StartFromEnd] is resolved to the last log entry in the log.
+// Log entries not matching filter, if set, are skipped. The ID of the last log entry read or skipped is returned along
+// with entries, and is the zero EntryID if none were.
+func (l *Log) addEntries(group *ConsumerGroup, consumer Consumer, maxMessages int, filter *Filter,
+	entries []Entry) ([]Entry, EntryID, error) {
+	startAt := group.GetStartAt()
+	if startAt == StartFromEnd {
+		// the Consumer group was set to start at StartFromEnd after it was added to the log, or restored from a
+		// snapshot doing so, and StartFromEnd orders before every log entry
+		startAt = l.lastEntry
+		group.SetStartAt(startAt)
 	}
-	for iter.HasNext() {
-		n, err := iter.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		eid, err := ParseEntryID(string(n.Key()))
-		if err != nil {
-			return entries, err
+	var last EntryID
+	var resolveErr error
+	err := l.entries.Ascend(startAt, func(e Entry) bool {
+		if e.ID == startAt {
+			return true
 		}
 
 		// check if entry is pending
-		_, ok := group.GetPendingEntry(eid)
+		_, ok := group.GetPendingEntry(e.ID)
 		if ok {
-			continue
+			return true
 		}
 
+		e, resolveErr = l.resolve(e)
+		if resolveErr != nil {
+			return false
+		}
+		last = e.ID
+		if filter != nil && !filter.Match(e) {
+			return true
+		}
 		// add entry to Pending Entries List
-		group.AddPendingEntry(eid, consumer.name)
-		entries = append(entries, Entry{
-			ID:      eid,
-			Payload: n.Value(),
-		})
+		group.AddPendingEntry(e.ID, consumer.name)
+		entries = append(entries, e)
 
-		if maxMessages > 0 && len(entries) >= maxMessages {
-			break
-		}
+		return maxMessages <= 0 || len(entries) < maxMessages
+	})
+	if err != nil {
+		return nil, ZeroEntryID, err
+	}
+	if resolveErr != nil {
+		return nil, ZeroEntryID, resolveErr
 	}
 
-	return entries, nil
+	return entries, last, nil
 }
 
 func (l *Log) getGroup(name string) (*ConsumerGroup, bool) {
@@ -220,18 +762,55 @@ func (l *Log) getGroup(name string) (*ConsumerGroup, bool) {
 	return g, ok
 }
 
-// AddGroup adds a Consumer group to the log.
-func (l *Log) AddGroup(group *ConsumerGroup) {
+// AddGroup adds a Consumer group to the log. If the Consumer group starts at [StartFromEnd], its start at entry ID is
+// set to the last log entry in the log, so only log entries written after the Consumer group was added are read.
+//
+// If the write-ahead log is enabled, the Consumer group is appended to it, and any subsequent change to the Consumer
+// group is journaled as well. If appending the Consumer group fails, it is not added to the log and an error is
+// returned.
+func (l *Log) AddGroup(group *ConsumerGroup) error {
 	l.treeMux.Lock()
+	defer l.treeMux.Unlock()
+	group.mut.Lock()
+	defer group.mut.Unlock()
+	if group.startAt == StartFromEnd {
+		group.startAt = l.lastEntry
+	}
+	if l.wal != nil {
+		state, err := group.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		err = l.wal.append(walRecord{Op: walOpAddGroup, Group: group.name, State: state})
+		if err != nil {
+			return err
+		}
+		group.wal = l.wal
+	}
 	l.groups[group.name] = group
-	l.treeMux.Unlock()
+	return nil
 }
 
 // RemoveGroup removes a Consumer group from the log.
-func (l *Log) RemoveGroup(name string) {
+//
+// If the write-ahead log is enabled and the removal cannot be appended to it, the Consumer group is kept and an error
+// is returned.
+func (l *Log) RemoveGroup(name string) error {
 	l.treeMux.Lock()
+	defer l.treeMux.Unlock()
+	// wake up ReadContext, which returns an error once the Consumer group is removed
+	defer l.written.notify()
+	err := l.wal.append(walRecord{Op: walOpRemoveGroup, Group: name})
+	if err != nil {
+		return err
+	}
+	if g, ok := l.groups[name]; ok {
+		g.mut.Lock()
+		g.wal = nil
+		g.mut.Unlock()
+	}
 	delete(l.groups, name)
-	l.treeMux.Unlock()
+	return nil
 }
 
 // ListGroups returns a list of all Consumer groups.
@@ -266,7 +845,7 @@ func (l *Log) Acknowledge(g, c string, id EntryID) error {
 
 	group.RemovePendingEntry(id)
 
-	return nil
+	return l.wal.Err()
 }
 
 // Cleanup runs a series of housekeeping actions on the log.
@@ -292,76 +871,156 @@ func (l *Log) Cleanup() {
 	}
 }
 
-// UpdateEntry updates the payload of a log entry. If the log entry does not exist, it will return false.
+// UpdateEntry updates the payload of a log entry, incrementing its revision. If the log entry does not exist, it will
+// return false. See [Log.UpdateEntryIf] for updating a log entry only if it was not updated concurrently.
+//
+// If the write-ahead log is enabled and the update cannot be appended to it, the log entry is left unchanged and
+// UpdateEntry returns false.
 //
 // UpdateEntry is safe for concurrent use.
 func (l *Log) UpdateEntry(id EntryID, payload any) bool {
 	l.treeMux.Lock()
 	defer l.treeMux.Unlock()
 
-	_, upd := l.entries.Insert(art.Key(id.String()), payload)
-	if !upd {
-		l.entries.Delete(art.Key(id.String()))
-	}
-
-	return upd
+	_, err := l.updateEntry(id, payload, func(Entry) error {
+		return nil
+	})
+	return err == nil
 }
 
-// MarshalBinary encodes a Log into a gob-encoded byte slice.
-func (l *Log) MarshalBinary() ([]byte, error) {
-	l.treeMux.RLock()
-	defer l.treeMux.RUnlock()
-	el := externalLog{
-		Name:                   l.name,
-		Groups:                 l.groups,
-		Entries:                make([]Entry, 0),
-		FirstEntry:             l.firstEntry,
-		LastEntry:              l.lastEntry,
-		MaxPendingAge:          l.maxPendingAge,
-		MaxDeliveryCount:       l.maxDeliveryCount,
-		AttemptRedeliveryAfter: l.attemptRedeliveryAfter,
-	}
-	l.entries.ForEach(func(node art.Node) (cont bool) {
-		id, err := ParseEntryID(string(node.Key()))
-		if err != nil {
-			return false
+// UpdateEntryIf updates the payload of a log entry if its revision is expectedRevision, much like a compare-and-swap.
+// It returns the new revision of the log entry. This allows several writers to update the same log entries without
+// overwriting each other's updates: a writer reads the log entry, computes the new payload from it, and updates the
+// log entry with the revision it read. If the log entry was updated in the meantime, an error wrapping
+// [ErrRevisionMismatch] is returned, and the writer can read the log entry again and retry.
+//
+// If the log entry does not exist, an error wrapping [ErrNoSuchEntry] is returned. If the write-ahead log is enabled
+// and the update cannot be appended to it, the log entry is left unchanged and the error is returned.
+//
+// UpdateEntryIf is safe for concurrent use.
+func (l *Log) UpdateEntryIf(id EntryID, expectedRevision uint64, payload any) (uint64, error) {
+	l.treeMux.Lock()
+	defer l.treeMux.Unlock()
+
+	return l.updateEntry(id, payload, func(e Entry) error {
+		if e.Revision != expectedRevision {
+			return fmt.Errorf("%w: log entry %s is at revision %d, not %d", ErrRevisionMismatch, id, e.Revision,
+				expectedRevision)
 		}
-		el.Entries = append(el.Entries, Entry{
-			ID:      id,
-			Payload: node.Value(),
-		})
-		return true
+		return nil
 	})
+}
+
+// updateEntry updates the payload of a log entry and appends the update to the write-ahead log, if check returns no
+// error for the log entry as it is before the update. It returns the new revision of the log entry. It is not safe for
+// concurrent use and should be called with the treeMux locked.
+func (l *Log) updateEntry(id EntryID, payload any, check func(e Entry) error) (uint64, error) {
+	e, ok, err := l.entries.Search(id)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrNoSuchEntry, id)
+	}
+	err = check(e)
+	if err != nil {
+		return 0, err
+	}
+	e.Payload = payload
+	e.Revision++
+	err = l.wal.append(walRecord{Op: walOpUpdateEntry, ID: id, Payload: payload, Revision: e.Revision})
+	if err != nil {
+		return 0, err
+	}
+	err = l.insert(e)
+	if err != nil {
+		return 0, err
+	}
+	return e.Revision, nil
+}
+
+// MarshalBinary encodes a Log into a gob-encoded byte slice. It uses the same encoding as [Log.WriteTo].
+func (l *Log) MarshalBinary() ([]byte, error) {
 	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(el)
+	_, err := l.WriteTo(&buf)
 	if err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
 }
 
-// UnmarshalBinary decodes a gob-encoded byte slice into a Log.
+// UnmarshalBinary decodes a gob-encoded byte slice into a Log. It accepts the same encodings as [Log.ReadFrom].
+//
+// If the write-ahead log is enabled, the decoded log replaces the contents of the write-ahead log.
 func (l *Log) UnmarshalBinary(data []byte) error {
-	l.treeMux.Lock()
-	defer l.treeMux.Unlock()
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	var el externalLog
-	err := dec.Decode(&el)
+	_, err := l.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// journalAll replaces the contents of the write-ahead log with the current state of the log. It is not safe for
+// concurrent use and should be called with the treeMux locked.
+func (l *Log) journalAll() error {
+	if l.wal == nil {
+		return nil
+	}
+	// the Consumer groups are encoded before the batch is started, as a Consumer group holds its own lock while
+	// appending to the write-ahead log
+	var groups []walRecord
+	for _, g := range l.groups {
+		state, err := g.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		groups = append(groups, walRecord{Op: walOpAddGroup, Group: g.name, State: state})
+	}
+	producers, err := l.producerRecords()
+	if err != nil {
+		return err
+	}
+	err = l.wal.appendBatch(func(add func(rec walRecord) error) error {
+		err := add(walRecord{Op: walOpReset})
+		if err != nil {
+			return err
+		}
+		for _, rec := range groups {
+			err = add(rec)
+			if err != nil {
+				return err
+			}
+			l.groups[rec.Group].wal = l.wal
+		}
+		// the deduplication state is journaled as a whole, as the sequence numbers of log entries that were removed
+		// and the sequence numbers already forgotten cannot be derived from the log entries
+		for _, rec := range producers {
+			err = add(rec)
+			if err != nil {
+				return err
+			}
+		}
+		var journalErr error
+		err = l.entries.Ascend(ZeroEntryID, func(e Entry) bool {
+			journalErr = add(walRecord{Op: walOpWrite, ID: e.ID, Key: e.Key, Payload: e.Payload, Revision: e.Revision})
+			return journalErr == nil
+		})
+		return errors.Join(err, journalErr)
+	})
+	if err != nil || !l.diskPayloads {
+		return err
+	}
+	// the payloads are now read from the write-ahead log, so they are no longer kept in the storage
+	var entries []Entry
+	err = l.entries.Ascend(ZeroEntryID, func(e Entry) bool {
+		entries = append(entries, l.stored(e))
+		return true
+	})
 	if err != nil {
 		return err
 	}
-	l.name = el.Name
-	l.groups = el.Groups
-	l.firstEntry = el.FirstEntry
-	l.lastEntry = el.LastEntry
-	l.maxPendingAge = el.MaxPendingAge
-	l.maxDeliveryCount = el.MaxDeliveryCount
-	l.attemptRedeliveryAfter = el.AttemptRedeliveryAfter
-	l.entries = art.New()
-	for _, e := range el.Entries {
-		l.entries.Insert([]byte(e.ID.String()), e.Payload)
+	for _, e := range entries {
+		err = l.entries.Insert(e)
+		if err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
@@ -10,12 +10,45 @@ type logOptions struct {
 	MaxPendingAge          time.Duration
 	MaxDeliveryCount       int
 	AttemptRedeliveryAfter time.Duration
+	// WALDir is the directory holding the write-ahead log. An empty string disables the write-ahead log.
+	WALDir string
+	// SegmentMaxBytes is the size at which a segment of the write-ahead log is sealed.
+	SegmentMaxBytes int64
+	// SegmentMaxAge is the age at which a segment of the write-ahead log is sealed. Zero disables time-based sealing.
+	SegmentMaxAge time.Duration
+	// Storage is the storage the log entries are kept in. A nil Storage means a new MemoryStorage is used.
+	Storage Storage
+	// TruncateCorrupted is set if corrupted data should be discarded, rather than causing an error, when it is read.
+	TruncateCorrupted bool
+	// SyncPolicy determines when the write-ahead log is flushed to stable storage.
+	SyncPolicy SyncPolicy
+	// CompactionInterval is the interval at which the log is compacted. Zero disables compaction in the background.
+	CompactionInterval time.Duration
+	// CompactionBytesPerSecond limits the rate at which the write-ahead log is rewritten when compacted. Zero means
+	// unlimited.
+	CompactionBytesPerSecond int64
+	// KeyCompaction is set if log entries superseded by a later log entry with the same key are removed when the log
+	// is compacted.
+	KeyCompaction bool
+	// Mmap is set if sealed segments of the write-ahead log are mapped into memory, and payloads are read from the
+	// write-ahead log rather than kept in the Storage.
+	Mmap bool
+	// Indexes maps the names of the secondary indexes of the log to their extractors.
+	Indexes map[string]IndexExtractor
+	// FullText holds the options of the full-text index of the log. It is nil if the full-text index is disabled.
+	FullText *fullTextOptions
+	// Clock determines the time held by the IDs of new log entries. A nil Clock means [time.Now] is used.
+	Clock Clock
+	// DedupWindow is the number of sequence numbers remembered per idempotent producer.
+	DedupWindow int
 }
 
 var defaultLogOptions = logOptions{
 	MaxPendingAge:          4 * time.Second,
 	MaxDeliveryCount:       3,
 	AttemptRedeliveryAfter: time.Second,
+	SegmentMaxBytes:        64 << 20,
+	DedupWindow:            1024,
 }
 
 var GlobalLogOptions []LogOption
@@ -70,3 +103,162 @@ func WithLogAttemptRedeliveryAfter(attemptRedeliveryAfter time.Duration) LogOpti
 		opts.AttemptRedeliveryAfter = attemptRedeliveryAfter
 	})
 }
+
+// WithLogWAL enables the write-ahead log, kept in the provided directory. Every change to the log and its Consumer
+// groups is appended to the write-ahead log before the method making the change returns, and [NewLog] replays the
+// write-ahead log to restore the state of the log. The directory is created if it does not exist.
+//
+// A directory must not be used by more than one log at a time.
+func WithLogWAL(dir string) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.WALDir = dir
+	})
+}
+
+// WithLogSegmentMaxBytes sets the size, in bytes, a segment of the write-ahead log may grow to before it is sealed and
+// records are appended to a new segment. Sealed segments are only rewritten by compaction. The default is 64 MiB.
+func WithLogSegmentMaxBytes(maxBytes int64) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.SegmentMaxBytes = maxBytes
+	})
+}
+
+// WithLogSegmentMaxAge sets the age a segment of the write-ahead log may reach before it is sealed and records are
+// appended to a new segment. The age of a segment is measured from when it was created.
+// The default is zero, which means segments are only sealed based on their size.
+func WithLogSegmentMaxAge(maxAge time.Duration) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.SegmentMaxAge = maxAge
+	})
+}
+
+// WithLogStorage sets the [Storage] the log entries are kept in. By default, a new [MemoryStorage] is used.
+//
+// A Storage must not be used by more than one log at a time.
+func WithLogStorage(storage Storage) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.Storage = storage
+	})
+}
+
+// WithLogTruncateCorrupted sets whether corrupted data is discarded when the log is restored, rather than causing an
+// error. Persisted data is verified against checksums when it is read, and by default any data failing verification
+// causes a [*CorruptionError] to be returned.
+//
+// If set, [NewLog] truncates the write-ahead log to the last record preceding the first corrupted record, and
+// [Log.ReadFrom] keeps the log entries preceding the first corrupted log entry of the snapshot. Everything after the
+// corruption is lost.
+func WithLogTruncateCorrupted(truncate bool) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.TruncateCorrupted = truncate
+	})
+}
+
+// WithLogSyncPolicy sets when changes appended to the write-ahead log are flushed to stable storage. See [SyncPolicy]
+// for the available policies. The default is [SyncNone].
+func WithLogSyncPolicy(policy SyncPolicy) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.SyncPolicy = policy
+	})
+}
+
+// WithLogCompactionInterval enables compaction of the log in the background, at the provided interval. Compaction
+// removes the log entries superseded by later log entries with the same key, if [WithLogKeyCompaction] is set, and
+// the records of the write-ahead log superseded by later records, such as the payloads of log entries replaced by
+// [Log.UpdateEntry], by rewriting sealed segments. Only segments where a large enough part of the records are
+// superseded are rewritten. The default is zero, which disables compaction in the background.
+//
+// The log can also be compacted explicitly with [Log.Compact].
+func WithLogCompactionInterval(interval time.Duration) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.CompactionInterval = interval
+	})
+}
+
+// WithLogCompactionBytesPerSecond limits the rate, in bytes per second, at which segments of the write-ahead log are
+// rewritten when the write-ahead log is compacted, limiting the disk bandwidth used by compaction. The default is zero,
+// which means the rate is unlimited.
+func WithLogCompactionBytesPerSecond(bytesPerSecond int64) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.CompactionBytesPerSecond = bytesPerSecond
+	})
+}
+
+// WithLogKeyCompaction sets whether log entries written with a key using [Log.WriteKey] are removed when the log is
+// compacted, once a later log entry has been written with the same key. Only the latest log entry of every key is
+// kept, much like a compacted Kafka topic, which keeps the log from growing without bound when it is used as a
+// changelog. Log entries that are pending in a Consumer group are kept until they are no longer pending, and log
+// entries without a key are never removed. The default is false.
+//
+// See [WithLogCompactionInterval] and [Log.Compact] for when the log is compacted.
+func WithLogKeyCompaction(enabled bool) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.KeyCompaction = enabled
+	})
+}
+
+// WithLogMmap sets whether the payloads of log entries are read from the write-ahead log when needed, rather than kept
+// in the [Storage] of the log, allowing a log to hold more log entries than fit in memory. The Storage then only holds
+// the IDs and keys of the log entries. Sealed segments of the write-ahead log are mapped into memory, so payloads are
+// decoded directly from the mapping. The default is false.
+//
+// WithLogMmap has no effect unless the write-ahead log is enabled with [WithLogWAL]. Reading a snapshot with
+// [Log.ReadFrom] still holds the payloads of the snapshot in memory until they have been appended to the write-ahead
+// log. On platforms without support for memory mapping, payloads are read from the segment files instead.
+func WithLogMmap(enabled bool) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.Mmap = enabled
+	})
+}
+
+// WithLogIndex adds a secondary index with the given name to the log, indexing every log entry by the values extract
+// returns for its payload. The index is maintained as log entries are written, updated and removed, can be queried
+// with [Log.LookupIndex], and is used by [Log.Search] when searching with [WithSearchIndex], rather than scanning every
+// log entry. Adding an index with the same name as an earlier index replaces the earlier index. Indexes can also be
+// added after the log is created with [Log.CreateIndex].
+//
+// extract is called with the lock of the log held, so it must not call any methods of the log.
+func WithLogIndex(name string, extract IndexExtractor) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		if opts.Indexes == nil {
+			opts.Indexes = make(map[string]IndexExtractor)
+		}
+		opts.Indexes[name] = extract
+	})
+}
+
+// WithLogClock sets the clock determining the time held by the IDs of new log entries, e.g. to write log entries at
+// deterministic times in tests. The default is [time.Now].
+//
+// The IDs of new log entries are always greater than the ID of the last log entry, even if the clock goes backwards,
+// see [Log.Write].
+func WithLogClock(clock Clock) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.Clock = clock
+	})
+}
+
+// WithLogDedupWindow sets the number of sequence numbers remembered per producer writing with [Log.WriteIdempotent].
+// A retried write is only recognized as a duplicate while its sequence number is remembered. The default is 1024, and
+// windows smaller than 1 are treated as 1.
+func WithLogDedupWindow(window int) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		opts.DedupWindow = max(window, 1)
+	})
+}
+
+// WithLogFullTextIndex enables the full-text index of the log, indexing the words of every log entry with a string
+// payload, so the log entries can be searched with [Log.FullTextSearch]. The index is configured with the given
+// options.
+//
+// The full-text index is kept in memory, and rebuilt from the log entries when the log is restored from a snapshot or
+// the write-ahead log.
+func WithLogFullTextIndex(options ...FullTextOption) LogOption {
+	return newFuncLogOption(func(opts *logOptions) {
+		ftOpts := defaultFullTextOptions
+		for _, opt := range options {
+			opt.apply(&ftOpts)
+		}
+		opts.FullText = &ftOpts
+	})
+}
@@ -52,3 +52,109 @@ func TestWithLogAttemptRedeliveryAfter(t *testing.T) {
 	lo.apply(&opts)
 	require.Equal(t, time.Duration(1), opts.AttemptRedeliveryAfter)
 }
+
+func TestWithLogWAL(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogWAL("some/dir")
+	lo.apply(&opts)
+	require.Equal(t, "some/dir", opts.WALDir)
+}
+
+func TestWithLogSegmentMaxBytes(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogSegmentMaxBytes(1024)
+	lo.apply(&opts)
+	require.Equal(t, int64(1024), opts.SegmentMaxBytes)
+}
+
+func TestWithLogSegmentMaxAge(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogSegmentMaxAge(time.Hour)
+	lo.apply(&opts)
+	require.Equal(t, time.Hour, opts.SegmentMaxAge)
+}
+
+func TestWithLogStorage(t *testing.T) {
+	opts := logOptions{}
+	storage := NewMemoryStorage()
+	lo := WithLogStorage(storage)
+	lo.apply(&opts)
+	require.Same(t, storage, opts.Storage)
+}
+
+func TestWithLogTruncateCorrupted(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogTruncateCorrupted(true)
+	lo.apply(&opts)
+	require.True(t, opts.TruncateCorrupted)
+}
+
+func TestWithLogSyncPolicy(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogSyncPolicy(SyncInterval(time.Second))
+	lo.apply(&opts)
+	require.Equal(t, SyncPolicy{interval: time.Second}, opts.SyncPolicy)
+}
+
+func TestWithLogCompactionInterval(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogCompactionInterval(time.Minute)
+	lo.apply(&opts)
+	require.Equal(t, time.Minute, opts.CompactionInterval)
+}
+
+func TestWithLogCompactionBytesPerSecond(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogCompactionBytesPerSecond(1024)
+	lo.apply(&opts)
+	require.Equal(t, int64(1024), opts.CompactionBytesPerSecond)
+}
+
+func TestWithLogKeyCompaction(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogKeyCompaction(true)
+	lo.apply(&opts)
+	require.True(t, opts.KeyCompaction)
+}
+
+func TestWithLogMmap(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogMmap(true)
+	lo.apply(&opts)
+	require.True(t, opts.Mmap)
+}
+
+func TestWithLogIndex(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogIndex("name", func(any) []string { return nil })
+	lo.apply(&opts)
+	require.Contains(t, opts.Indexes, "name")
+}
+
+func TestWithLogFullTextIndex(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogFullTextIndex(WithFullTextLowercase(false))
+	lo.apply(&opts)
+	require.NotNil(t, opts.FullText)
+	require.False(t, opts.FullText.Lowercase)
+	require.NotNil(t, opts.FullText.Tokenizer)
+}
+
+func TestWithLogClock(t *testing.T) {
+	opts := logOptions{}
+	now := time.UnixMilli(1734467114191)
+	lo := WithLogClock(func() time.Time { return now })
+	lo.apply(&opts)
+	require.Equal(t, now, opts.Clock())
+}
+
+func TestWithLogDedupWindow(t *testing.T) {
+	opts := logOptions{}
+	lo := WithLogDedupWindow(10)
+	lo.apply(&opts)
+	require.Equal(t, 10, opts.DedupWindow)
+
+	lo = WithLogDedupWindow(0)
+	lo.apply(&opts)
+	require.Equal(t, 1, opts.DedupWindow)
+}
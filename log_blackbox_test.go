@@ -9,6 +9,7 @@ import (
 	"github.com/MadsRC/historitor"
 	"github.com/stretchr/testify/require"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -111,7 +112,7 @@ func TestLog_Read(t *testing.T) {
 	require.NoError(t, err)
 	l.AddGroup(cg)
 
-	_ = l.Write("value")
+	_, _ = l.Write("value")
 
 	entries, err := l.Read(cg.GetName(), c.GetName(), 1)
 	require.NoError(t, err)
@@ -165,9 +166,12 @@ func TestLog_UpdateEntry(t *testing.T) {
 	require.NoError(t, err)
 	l.AddGroup(cg)
 
-	entryID1 := l.Write("valueOne")
-	entryID2 := l.Write("valueTwo")
-	entryID3 := l.Write("valueThree")
+	entryID1, err := l.Write("valueOne")
+	require.NoError(t, err)
+	entryID2, err := l.Write("valueTwo")
+	require.NoError(t, err)
+	entryID3, err := l.Write("valueThree")
+	require.NoError(t, err)
 
 	ok := l.UpdateEntry(entryID2, "valueTwoUpdated")
 	require.True(t, ok)
@@ -193,7 +197,7 @@ func TestLog_Read_twice(t *testing.T) {
 	require.NoError(t, err)
 	l.AddGroup(cg)
 
-	_ = l.Write("value")
+	_, _ = l.Write("value")
 
 	entries1, err := l.Read(cg.GetName(), c.GetName(), 1)
 	require.NoError(t, err)
@@ -238,3 +242,93 @@ func TestLogBinaryEncodingAndDecoding(t *testing.T) {
 	require.Equal(t, len(l.ListGroups()), len(l2.ListGroups()))
 	require.Equal(t, l.ListGroups()[0].GetName(), l2.ListGroups()[0].GetName())
 }
+
+// TestLog_WAL_restart tests that a log using a write-ahead log can be closed and re-opened without losing any log
+// entries. The segment size is kept small to have the write-ahead log span many segments.
+func TestLog_WAL_restart(t *testing.T) {
+	dir := t.TempDir()
+	l, err := historitor.NewLog(historitor.WithLogName(t.Name()), historitor.WithLogWAL(dir), historitor.WithLogSegmentMaxBytes(64<<10))
+	require.NoError(t, err)
+
+	n := forLine(t, func(line string) {
+		_, err := l.Write(line)
+		require.NoError(t, err)
+	})
+	require.NoError(t, l.Close())
+
+	l2, err := historitor.NewLog(historitor.WithLogName(t.Name()), historitor.WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	require.Equal(t, n, l2.Size())
+}
+
+// TestLog_WriteTo_ReadFrom tests that a log can be streamed to an io.Writer and read back from an io.Reader without
+// losing any log entries.
+func TestLog_WriteTo_ReadFrom(t *testing.T) {
+	l, err := historitor.NewLog(historitor.WithLogName(t.Name()))
+	require.NoError(t, err)
+	written := make([]string, 0)
+	forLine(t, func(line string) {
+		written = append(written, line)
+		_, err := l.Write(line)
+		require.NoError(t, err)
+	})
+
+	buf := new(bytes.Buffer)
+	_, err = l.WriteTo(buf)
+	require.NoError(t, err)
+	l2, err := historitor.NewLog()
+	require.NoError(t, err)
+	_, err = l2.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, l.Size(), l2.Size())
+
+	c := historitor.NewConsumer(historitor.WithConsumerName(t.Name()))
+	cg := historitor.NewConsumerGroup(historitor.WithConsumerGroupName(t.Name()), historitor.WithConsumerGroupMember(c))
+	require.NoError(t, l2.AddGroup(cg))
+	entries, err := l2.Read(cg.GetName(), c.GetName(), 0)
+	require.NoError(t, err)
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.Payload.(string))
+	}
+	require.Equal(t, written, out)
+}
+
+// normalizeSpace lowercases s and replaces every sequence of whitespace with a single space, as the lines of the book
+// are not consistently spaced.
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// TestLog_FullTextSearch tests searching the entire "On the Origin of Species" book using the full-text index, and
+// compares the results with a plain scan of the lines of the book.
+func TestLog_FullTextSearch(t *testing.T) {
+	l, err := historitor.NewLog(historitor.WithLogName(t.Name()), historitor.WithLogFullTextIndex())
+	require.NoError(t, err)
+	lines := make(map[historitor.EntryID]string)
+	want := 0
+	forLine(t, func(line string) {
+		id, err := l.Write(line)
+		require.NoError(t, err)
+		lines[id] = line
+		if strings.Contains(normalizeSpace(line), "natural selection") {
+			want++
+		}
+	})
+
+	matches, err := l.FullTextSearch(`"natural selection"`)
+	require.NoError(t, err)
+	require.Equal(t, want, len(matches))
+	for _, m := range matches {
+		for _, p := range m.Positions {
+			require.Equal(t, "natural selection", normalizeSpace(lines[m.ID][p.Start:p.End]))
+		}
+	}
+
+	matches, err = l.FullTextSearch("pigeons OR finches")
+	require.NoError(t, err)
+	require.NotEmpty(t, matches)
+}
@@ -3,8 +3,10 @@
 package historitor
 
 import (
-	art "github.com/plar/go-adaptive-radix-tree/v2"
+	"context"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -26,50 +28,61 @@ func TestNewLog(t *testing.T) {
 
 func TestLog_Size(t *testing.T) {
 	l := &Log{
-		entries: art.New(),
+		entries: NewMemoryStorage(),
 	}
 	require.Equal(t, 0, l.Size())
-	l.entries.Insert(art.Key("key"), "value")
+	require.NoError(t, l.entries.Insert(Entry{ID: fakeTestEntryID1, Payload: "value"}))
 	require.Equal(t, 1, l.Size())
 }
 
+func TestNewLog_storage(t *testing.T) {
+	storage := NewMemoryStorage()
+	l, err := NewLog(WithLogName(t.Name()), WithLogStorage(storage))
+	require.NoError(t, err)
+	_, err = l.Write("value")
+	require.NoError(t, err)
+	require.Equal(t, 1, storage.Size())
+}
+
 func TestLog_Write_id_has_timezone_set_to_utc(t *testing.T) {
 	l, err := NewLog(WithLogName(t.Name()))
 	require.NoError(t, err)
 
-	id := l.Write("value")
+	id, err := l.Write("value")
+	require.NoError(t, err)
 	require.Equal(t, "UTC", id.time.Location().String())
 }
 
 func TestLog_write_key_already_exists(t *testing.T) {
 	id := fakeTestEntryID1
 	l := &Log{
-		entries: art.New(),
+		entries: NewMemoryStorage(),
 	}
-	l.entries.Insert(art.Key(id.String()), "value")
-	l.write(&id, "value")
+	require.NoError(t, l.entries.Insert(Entry{ID: id, Payload: "value"}))
+	require.NoError(t, l.write(&id, "", "value"))
 	require.Equal(t, 2, l.entries.Size())
-	e1, ok := l.entries.Search(art.Key(fakeTestEntryID1.String()))
+	e1, ok, err := l.entries.Search(fakeTestEntryID1)
+	require.NoError(t, err)
 	require.True(t, ok)
-	require.Equal(t, "value", e1)
+	require.Equal(t, "value", e1.Payload)
 	require.Equal(t, uint64(1), fakeTestEntryID1.seq)
-	e2, ok := l.entries.Search(art.Key(id.String()))
+	e2, ok, err := l.entries.Search(id)
+	require.NoError(t, err)
 	require.True(t, ok)
-	require.Equal(t, "value", e2)
+	require.Equal(t, "value", e2.Payload)
 	require.Equal(t, uint64(2), id.seq)
 }
 
 func TestLog_Read_id_has_timezone_set_to_utc(t *testing.T) {
-	tree := art.New()
-	keyOne := fakeTestEntryID1.String()
-	tree.Insert(art.Key(keyOne), "value")
+	storage := NewMemoryStorage()
+	require.NoError(t, storage.Insert(Entry{ID: fakeTestEntryID1, Payload: "value"}))
 	c := NewConsumer(WithConsumerName(t.Name()))
 	cg := NewConsumerGroup(WithConsumerGroupName(t.Name()), WithConsumerGroupMember(c))
 	l, err := NewLog(WithLogName(t.Name()))
 	require.NoError(t, err)
 	l.AddGroup(cg)
 
-	l.entries = tree
+	l.entries = storage
 
 	entries, err := l.Read(cg.GetName(), c.GetName(), 1)
 	require.NoError(t, err)
@@ -80,14 +93,11 @@ func TestLog_Read_id_has_timezone_set_to_utc(t *testing.T) {
 func TestLog_Read_from_beginning(t *testing.T) {
 	l, err := NewLog(WithLogName("test"))
 	require.NoError(t, err)
-	tree := art.New()
-	keyOne := fakeTestEntryID1.String()
-	keyTwo := fakeTestEntryID2.String()
-	keyThree := fakeTestEntryID3.String()
-	tree.Insert(art.Key(keyOne), "one")
-	tree.Insert(art.Key(keyTwo), "two")
-	tree.Insert(art.Key(keyThree), "three")
-	l.entries = tree
+	storage := NewMemoryStorage()
+	require.NoError(t, storage.Insert(Entry{ID: fakeTestEntryID1, Payload: "one"}))
+	require.NoError(t, storage.Insert(Entry{ID: fakeTestEntryID2, Payload: "two"}))
+	require.NoError(t, storage.Insert(Entry{ID: fakeTestEntryID3, Payload: "three"}))
+	l.entries = storage
 
 	groupMembers := map[string]Consumer{
 		"consumer1": {
@@ -150,3 +160,598 @@ func TestLog_Cleanup(t *testing.T) {
 	l.Cleanup()
 	require.Len(t, l.groups["group1"].pel, 0)
 }
+
+// TestNewLog_wal_replay tests that a log using a write-ahead log is restored to the state it had before it was closed.
+func TestNewLog_wal_replay(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group2"))))
+	id1, err := l.Write("one")
+	require.NoError(t, err)
+	id2, err := l.Write("two")
+	require.NoError(t, err)
+	require.True(t, l.UpdateEntry(id2, "two updated"))
+	entries, err := l.Read("group1", "consumer1", 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.NoError(t, l.Acknowledge("group1", "consumer1", id1))
+	require.NoError(t, l.RemoveGroup("group2"))
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	require.Equal(t, 2, l2.Size())
+	require.Equal(t, id2, l2.lastEntry)
+	e, ok, err := l2.entries.Search(id2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "two updated", e.Payload)
+	require.Len(t, l2.groups, 1)
+	g := l2.groups["group1"]
+	require.Equal(t, id2, g.GetStartAt())
+	_, ok = g.GetMember("consumer1")
+	require.True(t, ok)
+	require.Len(t, g.ListPendingEntries(), 1)
+	_, ok = g.GetPendingEntry(id2)
+	require.True(t, ok)
+
+	// changes made after the replay are journaled as well
+	require.NoError(t, l2.Acknowledge("group1", "consumer1", id2))
+	require.NoError(t, l2.Close())
+	l3, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l3.Close()
+	}()
+	require.Empty(t, l3.groups["group1"].ListPendingEntries())
+}
+
+func TestNewLog_wal_corrupted(t *testing.T) {
+	dir := t.TempDir()
+	corruptWAL(t, dir)
+
+	_, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.ErrorIs(t, err, ErrCorrupted)
+
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogTruncateCorrupted(true))
+	require.NoError(t, err)
+	defer func() {
+		_ = l.Close()
+	}()
+	require.Equal(t, 1, l.Size())
+	require.Equal(t, fakeTestEntryID1, l.lastEntry)
+}
+
+// TestLog_Write_sync_interval tests that concurrent writes wait for, and share, the flush following them.
+func TestLog_Write_sync_interval(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(t.TempDir()), WithLogSyncPolicy(SyncInterval(10*time.Millisecond)))
+	require.NoError(t, err)
+	defer func() {
+		_ = l.Close()
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := l.Write(i)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	l.wal.mut.Lock()
+	defer l.wal.mut.Unlock()
+	require.Equal(t, l.wal.appended, l.wal.synced)
+}
+
+func TestLog_Sync(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(t.TempDir()))
+	require.NoError(t, err)
+	_, err = l.Write("value")
+	require.NoError(t, err)
+	require.NoError(t, l.Sync())
+	require.Equal(t, uint64(1), l.wal.synced)
+	require.NoError(t, l.Close())
+	require.Error(t, l.Sync())
+
+	var l2 Log
+	require.NoError(t, l2.Sync())
+}
+
+func TestLog_Close_twice(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(t.TempDir()))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	require.NoError(t, l.Close())
+
+	// an error is reported by every call
+	l, err = NewLog(WithLogName(t.Name()), WithLogWAL(t.TempDir()))
+	require.NoError(t, err)
+	require.NoError(t, l.wal.active().file.Close())
+	_, err = l.Write("value")
+	require.Error(t, err)
+	err = l.Close()
+	require.Error(t, err)
+	require.Equal(t, err, l.Close())
+
+	l, err = NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	require.NoError(t, l.Close())
+}
+
+func TestLog_Write_wal_failure(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(t.TempDir()))
+	require.NoError(t, err)
+	require.NoError(t, l.wal.active().file.Close())
+
+	_, err = l.Write("value")
+	require.Error(t, err)
+	require.Equal(t, 0, l.Size())
+	require.True(t, l.lastEntry.IsZero())
+}
+
+func TestLog_WriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	_, err = l.Write("zero")
+	require.NoError(t, err)
+	ids, err := l.WriteBatch([]any{"one", "two", "three"})
+	require.NoError(t, err)
+	require.Len(t, ids, 3)
+	for i := 1; i < len(ids); i++ {
+		require.Equal(t, ids[0].time, ids[i].time)
+		require.Equal(t, ids[i-1].seq+1, ids[i].seq)
+	}
+	require.Equal(t, ids[2], l.lastEntry)
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l2.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+	entries, err := l2.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	for i, payload := range []any{"one", "two", "three"} {
+		require.Equal(t, ids[i], entries[i+1].ID)
+		require.Equal(t, payload, entries[i+1].Payload)
+	}
+
+	ids, err = l2.WriteBatch(nil)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+}
+
+func TestLog_WriteBatch_wal_failure(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(t.TempDir()))
+	require.NoError(t, err)
+	require.NoError(t, l.wal.active().file.Close())
+
+	ids, err := l.WriteBatch([]any{"one", "two"})
+	require.Error(t, err)
+	require.Nil(t, ids)
+	require.Equal(t, 0, l.Size())
+	require.True(t, l.lastEntry.IsZero())
+}
+
+func TestLog_WriteWithID(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	base := time.UnixMilli(1734467114191)
+	require.NoError(t, l.WriteWithID(NewEntryID(base, 5), "one"))
+	require.NoError(t, l.WriteWithID(NewPartialEntryID(base), "two"))
+	require.Equal(t, NewEntryID(base, 6), l.lastEntry)
+	require.NoError(t, l.WriteWithID(NewPartialEntryID(base.Add(time.Millisecond)), "three"))
+	require.Equal(t, NewEntryID(base.Add(time.Millisecond), 0), l.lastEntry)
+
+	for _, id := range []EntryID{
+		NewEntryID(base, 7),
+		NewEntryID(base.Add(time.Millisecond), 0),
+		NewPartialEntryID(base),
+		StartFromEnd,
+	} {
+		err = l.WriteWithID(id, "rejected")
+		require.ErrorIs(t, err, ErrIDNotMonotonic, id.String())
+	}
+	require.Equal(t, 3, l.Size())
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	e, ok, err := l2.entries.Search(NewEntryID(base, 6))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "two", e.Payload)
+	require.Equal(t, NewEntryID(base.Add(time.Millisecond), 0), l2.lastEntry)
+}
+
+func TestLog_UpdateEntryIf(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	id, err := l.Write("one")
+	require.NoError(t, err)
+
+	revision, err := l.UpdateEntryIf(id, 0, "one-a")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), revision)
+	// a writer that read the log entry before the update does not overwrite it
+	_, err = l.UpdateEntryIf(id, 0, "one-b")
+	require.ErrorIs(t, err, ErrRevisionMismatch)
+	require.True(t, l.UpdateEntry(id, "one-b"))
+	revision, err = l.UpdateEntryIf(id, 2, "one-c")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), revision)
+
+	_, err = l.UpdateEntryIf(fakeTestEntryID1, 0, "missing")
+	require.ErrorIs(t, err, ErrNoSuchEntry)
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	e, ok, err := l2.entries.Search(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, Entry{ID: id, Payload: "one-c", Revision: 3}, e)
+
+	l3, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	require.NoError(t, l3.UnmarshalBinary(b))
+	revision, err = l3.UpdateEntryIf(id, 3, "one-d")
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), revision)
+}
+
+// TestLog_UnmarshalBinary_wal tests that decoding a log into a log using a write-ahead log replaces the contents of
+// the write-ahead log.
+func TestLog_UnmarshalBinary_wal(t *testing.T) {
+	src, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	require.NoError(t, src.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"))))
+	id, err := src.Write("value")
+	require.NoError(t, err)
+	b, err := src.MarshalBinary()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	_, err = l.Write("discarded")
+	require.NoError(t, err)
+	require.NoError(t, l.UnmarshalBinary(b))
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	require.Equal(t, 1, l2.Size())
+	require.Equal(t, id, l2.lastEntry)
+	require.Contains(t, l2.groups, "group1")
+}
+
+// TestLog_UnmarshalBinary_wal_replaced_group tests that a Consumer group replaced by decoding a log into a log using a
+// write-ahead log no longer journals its changes, so the log can be reopened after the old Consumer group is used.
+func TestLog_UnmarshalBinary_wal_replaced_group(t *testing.T) {
+	src, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	require.NoError(t, src.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"))))
+	b, err := src.MarshalBinary()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	g := NewConsumerGroup(WithConsumerGroupName("group1"))
+	require.NoError(t, l.AddGroup(g))
+	require.NoError(t, l.UnmarshalBinary(b))
+	g.AddMember(NewConsumer(WithConsumerName("consumer1")))
+	g.SetStartAt(fakeTestEntryID1)
+	require.NoError(t, g.Err())
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	require.Contains(t, l2.groups, "group1")
+	require.Empty(t, l2.groups["group1"].members)
+	require.NotEqual(t, fakeTestEntryID1, l2.groups["group1"].startAt)
+}
+
+// TestNewLog_wal_replay_unknown_group tests that replaying records of Consumer groups that are not in the log skips
+// them.
+func TestNewLog_wal_replay_unknown_group(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	require.NoError(t, l.wal.append(walRecord{Op: walOpSetStartAt, Group: "group1", ID: fakeTestEntryID1}))
+	require.NoError(t, l.wal.append(walRecord{Op: walOpAddMember, Group: "group1", Consumer: "consumer1"}))
+	require.NoError(t, l.wal.append(walRecord{Op: walOpRemoveMember, Group: "group1", Consumer: "consumer1"}))
+	require.NoError(t, l.wal.append(walRecord{
+		Op:      walOpAddPendingEntry,
+		Group:   "group1",
+		Pending: PendingEntry{ID: fakeTestEntryID1, Consumer: "consumer1"},
+	}))
+	require.NoError(t, l.wal.append(walRecord{Op: walOpRemovePendingEntry, Group: "group1", ID: fakeTestEntryID1}))
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	require.Empty(t, l2.groups)
+}
+
+// TestLog_Read_start_at_missing_entry tests that a Consumer group starting at an ID that does not exist in the log
+// reads the log entries after that ID.
+func TestLog_Read_start_at_missing_entry(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	require.NoError(t, l.entries.Insert(Entry{ID: fakeTestEntryID1, Payload: "one"}))
+	require.NoError(t, l.entries.Insert(Entry{ID: fakeTestEntryID3, Payload: "three"}))
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(
+		WithConsumerGroupName("group1"),
+		WithConsumerGroupMember(c),
+		WithConsumerGroupStartAt(fakeTestEntryID2),
+	)))
+
+	entries, err := l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{ID: fakeTestEntryID3, Payload: "three"}}, entries)
+}
+
+// TestLog_Read_redelivery_keeps_start_at tests that re-delivering pending entries does not move the start at entry ID
+// of the Consumer group backwards.
+func TestLog_Read_redelivery_keeps_start_at(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogAttemptRedeliveryAfter(0))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	cg := NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))
+	require.NoError(t, l.AddGroup(cg))
+	require.NoError(t, l.entries.Insert(Entry{ID: fakeTestEntryID1, Payload: "one"}))
+	require.NoError(t, l.entries.Insert(Entry{ID: fakeTestEntryID2, Payload: "two"}))
+
+	_, err = l.Read("group1", "consumer1", 2)
+	require.NoError(t, err)
+	require.NoError(t, l.Acknowledge("group1", "consumer1", fakeTestEntryID2))
+	entries, err := l.Read("group1", "consumer1", 1)
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{ID: fakeTestEntryID1, Payload: "one"}}, entries)
+	require.Equal(t, fakeTestEntryID2, cg.GetStartAt())
+}
+
+// requireResolvedPayloads requires the log entries of l to hold the given payloads, in order, once resolved, and
+// requires the payloads not to be kept in the storage of l.
+func requireResolvedPayloads(t *testing.T, l *Log, payloads ...any) {
+	t.Helper()
+	var got []any
+	require.NoError(t, l.entries.Ascend(ZeroEntryID, func(e Entry) bool {
+		require.Equal(t, walPayload{}, e.Payload)
+		e, err := l.resolve(e)
+		require.NoError(t, err)
+		got = append(got, e.Payload)
+		return true
+	}))
+	require.Equal(t, payloads, got)
+}
+
+// TestNewLog_mmap tests that payloads are read from the write-ahead log rather than kept in the storage when log
+// entries are read, and that they survive compaction, snapshots and replaying the write-ahead log.
+func TestNewLog_mmap(t *testing.T) {
+	dir := t.TempDir()
+	opts := []LogOption{WithLogName(t.Name()), WithLogWAL(dir), WithLogSegmentMaxBytes(1), WithLogMmap(true)}
+	l, err := NewLog(opts...)
+	require.NoError(t, err)
+	id, err := l.Write("one")
+	require.NoError(t, err)
+	_, err = l.Write("two")
+	require.NoError(t, err)
+	require.True(t, l.UpdateEntry(id, "one-a"))
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+	if runtime.GOOS != "windows" && runtime.GOOS != "js" && runtime.GOOS != "wasip1" {
+		require.NotNil(t, l.wal.segments[0].data)
+	}
+
+	entries, err := l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "one-a", entries[0].Payload)
+	require.Equal(t, "two", entries[1].Payload)
+	requireResolvedPayloads(t, l, "one-a", "two")
+
+	require.NoError(t, l.Compact())
+	requireResolvedPayloads(t, l, "one-a", "two")
+
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, l.UnmarshalBinary(b))
+	requireResolvedPayloads(t, l, "one-a", "two")
+	require.True(t, l.UpdateEntry(id, "one-b"))
+	requireResolvedPayloads(t, l, "one-b", "two")
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(opts...)
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	requireResolvedPayloads(t, l2, "one-b", "two")
+}
+
+func TestLog_ReadContext(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+
+	done := make(chan []Entry)
+	go func() {
+		entries, err := l.ReadContext(context.Background(), "group1", "consumer1", 0)
+		assert.NoError(t, err)
+		done <- entries
+	}()
+	select {
+	case <-done:
+		t.Fatal("ReadContext returned before a log entry was written")
+	case <-time.After(10 * time.Millisecond):
+	}
+	id, err := l.Write("one")
+	require.NoError(t, err)
+	select {
+	case entries := <-done:
+		require.Len(t, entries, 1)
+		require.Equal(t, id, entries[0].ID)
+	case <-time.After(time.Second):
+		t.Fatal("ReadContext was not woken up by Write")
+	}
+}
+
+// TestLog_ReadContext_start_from_end tests that ReadContext for a Consumer group starting at StartFromEnd waits for,
+// and only returns, the log entries written after the Consumer group was added.
+func TestLog_ReadContext_start_from_end(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	_, err = l.Write("before")
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(
+		WithConsumerGroupName("group1"),
+		WithConsumerGroupMember(c),
+		WithConsumerGroupStartAt(StartFromEnd),
+	)))
+
+	done := make(chan []Entry)
+	go func() {
+		entries, err := l.ReadContext(context.Background(), "group1", "consumer1", 0)
+		assert.NoError(t, err)
+		done <- entries
+	}()
+	select {
+	case <-done:
+		t.Fatal("ReadContext returned before a log entry was written")
+	case <-time.After(10 * time.Millisecond):
+	}
+	id, err := l.Write("after")
+	require.NoError(t, err)
+	select {
+	case entries := <-done:
+		require.Equal(t, []Entry{{ID: id, Payload: "after"}}, entries)
+	case <-time.After(time.Second):
+		t.Fatal("ReadContext was not woken up by Write")
+	}
+}
+
+// TestLog_Read_set_start_from_end tests that a Consumer group set to start at StartFromEnd after it was added to the
+// log, or restored from a snapshot doing so, only reads the log entries written after its next read.
+func TestLog_Read_set_start_from_end(t *testing.T) {
+	newLog := func(t *testing.T) *Log {
+		l, err := NewLog(WithLogName(t.Name()))
+		require.NoError(t, err)
+		_, err = l.Write("one")
+		require.NoError(t, err)
+		_, err = l.Write("two")
+		require.NoError(t, err)
+		c := NewConsumer(WithConsumerName("consumer1"))
+		require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+		l.groups["group1"].SetStartAt(StartFromEnd)
+		return l
+	}
+	requireReadsFromEnd := func(t *testing.T, l *Log) {
+		entries, err := l.Read("group1", "consumer1", 0)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+		id, err := l.Write("three")
+		require.NoError(t, err)
+		entries, err = l.Read("group1", "consumer1", 0)
+		require.NoError(t, err)
+		require.Equal(t, []Entry{{ID: id, Payload: "three"}}, entries)
+	}
+
+	t.Run("added", func(t *testing.T) {
+		requireReadsFromEnd(t, newLog(t))
+	})
+	t.Run("restored", func(t *testing.T) {
+		b, err := newLog(t).MarshalBinary()
+		require.NoError(t, err)
+		l, err := NewLog(WithLogName(t.Name()))
+		require.NoError(t, err)
+		require.NoError(t, l.UnmarshalBinary(b))
+		require.Equal(t, StartFromEnd, l.groups["group1"].GetStartAt())
+		requireReadsFromEnd(t, l)
+	})
+}
+
+func TestLog_ReadContext_cancelled(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	entries, err := l.ReadContext(ctx, "group1", "consumer1", 0)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Empty(t, entries)
+
+	_, err = l.ReadContext(context.Background(), "group2", "consumer1", 0)
+	require.ErrorIs(t, err, ErrNoSuchGroup)
+}
+
+// TestLog_ReadContext_redelivery tests that ReadContext wakes up when a pending log entry becomes eligible for
+// re-delivery.
+func TestLog_ReadContext_redelivery(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogAttemptRedeliveryAfter(20*time.Millisecond))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+	id, err := l.Write("one")
+	require.NoError(t, err)
+	entries, err := l.Read("group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	entries, err = l.ReadContext(ctx, "group1", "consumer1", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, id, entries[0].ID)
+	require.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+}
+
+func TestBroadcast(t *testing.T) {
+	var b broadcast
+	ch := b.wait()
+	require.Equal(t, ch, b.wait())
+	b.notify()
+	_, open := <-ch
+	require.False(t, open)
+	require.NotEqual(t, ch, b.wait())
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package historitor
+
+import (
+	"os"
+)
+
+// mmap is not supported on this platform, so segments are read from their files instead.
+func mmap(_ *os.File, _ int64) ([]byte, error) {
+	return nil, nil
+}
+
+// munmap unmaps memory mapped by mmap.
+func munmap(_ []byte) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package historitor
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps the first size bytes of f into memory, read-only.
+func mmap(f *os.File, size int64) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmap unmaps memory mapped by mmap.
+func munmap(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	return syscall.Munmap(b)
+}
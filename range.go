@@ -0,0 +1,188 @@
+package historitor
+
+import (
+	"fmt"
+	"time"
+)
+
+// Get returns the log entry with the given ID. If no such log entry exists, an error wrapping [ErrNoSuchEntry] is
+// returned.
+//
+// Like [Log.Range], Get does not change the Pending Entries List of any Consumer group.
+//
+// Get is safe for concurrent use.
+func (l *Log) Get(id EntryID) (Entry, error) {
+	l.treeMux.RLock()
+	defer l.treeMux.RUnlock()
+
+	e, ok, err := l.entries.Search(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	if !ok {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNoSuchEntry, id)
+	}
+	return l.resolve(e)
+}
+
+// GetMany returns the log entries with the given IDs, in the order the IDs are given. IDs of log entries that do not
+// exist are skipped, so the returned slice may be shorter than ids. The log entries are read while holding the lock
+// of the log once, so they are consistent with each other.
+//
+// GetMany is safe for concurrent use.
+func (l *Log) GetMany(ids []EntryID) ([]Entry, error) {
+	l.treeMux.RLock()
+	defer l.treeMux.RUnlock()
+
+	out := make([]Entry, 0, len(ids))
+	for _, id := range ids {
+		e, ok, err := l.entries.Search(id)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		e, err = l.resolve(e)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// Range returns up to count log entries with IDs between start and end, inclusive, in ascending order. If count is 0
+// or less, every log entry in the range is returned. Use [WithRangeExclusiveStart] and [WithRangeExclusiveEnd] to
+// exclude the bounds from the range.
+//
+// The special IDs [StartFromBeginning] and [StartFromEnd] can be used as either bound, and refer to the first and last
+// log entry of the log, respectively.
+//
+// Unlike [Log.Read], Range reads the log outside of Consumer groups, and does not change the Pending Entries List of
+// any Consumer group.
+//
+// Range is safe for concurrent use.
+func (l *Log) Range(start, end EntryID, count int, options ...RangeOption) ([]Entry, error) {
+	l.treeMux.RLock()
+	defer l.treeMux.RUnlock()
+
+	from, to, opts := l.rangeBounds(start, end, options)
+	out := make([]Entry, 0)
+	var resolveErr error
+	err := l.entries.Ascend(from, func(e Entry) bool {
+		if opts.ExclusiveStart && e.ID == from {
+			return true
+		}
+		c := e.ID.Compare(to)
+		if c > 0 || (c == 0 && opts.ExclusiveEnd) {
+			return false
+		}
+		e, resolveErr = l.resolve(e)
+		if resolveErr != nil {
+			return false
+		}
+		if opts.Filter != nil && !opts.Filter.Match(e) {
+			return true
+		}
+		out = append(out, e)
+		return count <= 0 || len(out) < count
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resolveErr != nil {
+		return nil, resolveErr
+	}
+	return out, nil
+}
+
+// RevRange returns up to count log entries with IDs between start and end, inclusive, in descending order, starting
+// with the log entry at end. Otherwise, RevRange behaves like [Log.Range], and the bounds are given in the same order.
+//
+// RevRange is safe for concurrent use.
+func (l *Log) RevRange(start, end EntryID, count int, options ...RangeOption) ([]Entry, error) {
+	l.treeMux.RLock()
+	defer l.treeMux.RUnlock()
+
+	from, to, opts := l.rangeBounds(start, end, options)
+	out := make([]Entry, 0)
+	if to.IsZero() {
+		// the log is empty, and Descend would otherwise start at the last log entry
+		return out, nil
+	}
+	var resolveErr error
+	err := l.entries.Descend(to, func(e Entry) bool {
+		if opts.ExclusiveEnd && e.ID == to {
+			return true
+		}
+		c := e.ID.Compare(from)
+		if c < 0 || (c == 0 && opts.ExclusiveStart) {
+			return false
+		}
+		e, resolveErr = l.resolve(e)
+		if resolveErr != nil {
+			return false
+		}
+		if opts.Filter != nil && !opts.Filter.Match(e) {
+			return true
+		}
+		out = append(out, e)
+		return count <= 0 || len(out) < count
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resolveErr != nil {
+		return nil, resolveErr
+	}
+	return out, nil
+}
+
+// SeekTime returns the ID of the first log entry written at or after t, as recorded in the IDs of the log entries. As
+// IDs record the millisecond a log entry was written, a time within a millisecond skips the log entries written in that
+// millisecond. If no log entry was written at or after t, an error wrapping [ErrNoMoreEntries] is returned.
+//
+// The ID is found with a binary search rather than by scanning the log, and can be used as the start of a range, see
+// [Log.Range]. To read log entries written at or after t with a Consumer group, see [ConsumerGroup.SetStartAtTime].
+//
+// SeekTime is safe for concurrent use.
+func (l *Log) SeekTime(t time.Time) (EntryID, error) {
+	l.treeMux.RLock()
+	defer l.treeMux.RUnlock()
+
+	found := ZeroEntryID
+	err := l.entries.Ascend(entryIDAtOrAfter(t), func(e Entry) bool {
+		found = e.ID
+		return false
+	})
+	if err != nil {
+		return ZeroEntryID, err
+	}
+	if found.IsZero() {
+		return ZeroEntryID, fmt.Errorf("%w: at or after %s", ErrNoMoreEntries, t.Format(time.RFC3339Nano))
+	}
+	return found, nil
+}
+
+// rangeBounds returns the IDs of the bounds of a range, with the special IDs [StartFromBeginning] and [StartFromEnd]
+// replaced by the IDs they refer to, along with the options of the range. Special IDs are always inclusive. It should
+// be called with the treeMux locked.
+func (l *Log) rangeBounds(start, end EntryID, options []RangeOption) (EntryID, EntryID, rangeOptions) {
+	opts := defaultRangeOptions
+	for _, opt := range options {
+		opt.apply(&opts)
+	}
+	bound := func(id EntryID, exclusive *bool) EntryID {
+		switch id {
+		case StartFromBeginning:
+			*exclusive = false
+			return ZeroEntryID
+		case StartFromEnd:
+			*exclusive = false
+			return l.lastEntry
+		}
+		return id
+	}
+	return bound(start, &opts.ExclusiveStart), bound(end, &opts.ExclusiveEnd), opts
+}
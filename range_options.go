@@ -0,0 +1,58 @@
+package historitor
+
+type rangeOptions struct {
+	// ExclusiveStart is set if the log entry with the start ID is excluded from the range.
+	ExclusiveStart bool
+	// ExclusiveEnd is set if the log entry with the end ID is excluded from the range.
+	ExclusiveEnd bool
+	// Filter is the filter log entries must match to be read. It is nil if log entries are not filtered.
+	Filter *Filter
+}
+
+var defaultRangeOptions = rangeOptions{}
+
+// RangeOption is an option for configuring the range read by [Log.Range] and [Log.RevRange].
+type RangeOption interface {
+	apply(*rangeOptions)
+}
+
+// funcRangeOption is a RangeOption that calls a function.
+// It is used to wrap a function, so it satisfies the RangeOption interface.
+type funcRangeOption struct {
+	f func(*rangeOptions)
+}
+
+func (fdo *funcRangeOption) apply(opts *rangeOptions) {
+	fdo.f(opts)
+}
+
+func newFuncRangeOption(f func(*rangeOptions)) *funcRangeOption {
+	return &funcRangeOption{
+		f: f,
+	}
+}
+
+// WithRangeExclusiveStart sets whether the log entry with the start ID of the range is excluded from the range, which
+// is useful for paging through the log by starting the next page at the last log entry of the previous page. The
+// default is false. It has no effect if the start ID is [StartFromBeginning] or [StartFromEnd].
+func WithRangeExclusiveStart(exclusive bool) RangeOption {
+	return newFuncRangeOption(func(opts *rangeOptions) {
+		opts.ExclusiveStart = exclusive
+	})
+}
+
+// WithRangeExclusiveEnd sets whether the log entry with the end ID of the range is excluded from the range. The
+// default is false. It has no effect if the end ID is [StartFromBeginning] or [StartFromEnd].
+func WithRangeExclusiveEnd(exclusive bool) RangeOption {
+	return newFuncRangeOption(func(opts *rangeOptions) {
+		opts.ExclusiveEnd = exclusive
+	})
+}
+
+// WithRangeFilter sets a [Filter] log entries must match to be read. Log entries not matching the filter are skipped,
+// and do not count towards the count of the range.
+func WithRangeFilter(filter *Filter) RangeOption {
+	return newFuncRangeOption(func(opts *rangeOptions) {
+		opts.Filter = filter
+	})
+}
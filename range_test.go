@@ -0,0 +1,171 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// newRangeTestLog returns a log holding the log entries fakeTestEntryID1, fakeTestEntryID2 and fakeTestEntryID3, with
+// the payloads "one", "two" and "three", and a Consumer group named group1.
+func newRangeTestLog(t *testing.T) *Log {
+	t.Helper()
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	for i, id := range []EntryID{fakeTestEntryID1, fakeTestEntryID2, fakeTestEntryID3} {
+		require.NoError(t, l.entries.Insert(Entry{ID: id, Payload: []string{"one", "two", "three"}[i]}))
+		l.lastEntry = id
+	}
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+	return l
+}
+
+// entryPayloads returns the payloads of entries, in order.
+func entryPayloads(entries []Entry) []any {
+	out := make([]any, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.Payload)
+	}
+	return out
+}
+
+func TestLog_Range(t *testing.T) {
+	l := newRangeTestLog(t)
+	tests := []struct {
+		name       string
+		start, end EntryID
+		count      int
+		options    []RangeOption
+		want       []any
+	}{
+		{name: "all", start: StartFromBeginning, end: StartFromEnd, want: []any{"one", "two", "three"}},
+		{name: "count", start: StartFromBeginning, end: StartFromEnd, count: 2, want: []any{"one", "two"}},
+		{name: "inclusive", start: fakeTestEntryID2, end: fakeTestEntryID3, want: []any{"two", "three"}},
+		{
+			name:    "exclusive_start",
+			start:   fakeTestEntryID1,
+			end:     StartFromEnd,
+			options: []RangeOption{WithRangeExclusiveStart(true)},
+			want:    []any{"two", "three"},
+		},
+		{
+			name:    "exclusive_end",
+			start:   fakeTestEntryID1,
+			end:     fakeTestEntryID3,
+			options: []RangeOption{WithRangeExclusiveEnd(true)},
+			want:    []any{"one", "two"},
+		},
+		{
+			name:    "special_ids_inclusive",
+			start:   StartFromBeginning,
+			end:     StartFromEnd,
+			options: []RangeOption{WithRangeExclusiveStart(true), WithRangeExclusiveEnd(true)},
+			want:    []any{"one", "two", "three"},
+		},
+		{name: "start_from_end", start: StartFromEnd, end: StartFromEnd, want: []any{"three"}},
+		{name: "empty", start: fakeTestEntryID3, end: fakeTestEntryID1, want: []any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := l.Range(tt.start, tt.end, tt.count, tt.options...)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, entryPayloads(entries))
+
+			// the reverse range holds the same log entries in reverse order, except when limited by count
+			if tt.count > 0 {
+				return
+			}
+			entries, err = l.RevRange(tt.start, tt.end, tt.count, tt.options...)
+			require.NoError(t, err)
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+			require.Equal(t, tt.want, entryPayloads(entries))
+		})
+	}
+	require.Empty(t, l.groups["group1"].ListPendingEntries())
+}
+
+func TestLog_RevRange(t *testing.T) {
+	l := newRangeTestLog(t)
+	entries, err := l.RevRange(StartFromBeginning, StartFromEnd, 2)
+	require.NoError(t, err)
+	require.Equal(t, []any{"three", "two"}, entryPayloads(entries))
+	entries, err = l.RevRange(fakeTestEntryID1, fakeTestEntryID2, 0, WithRangeExclusiveEnd(true))
+	require.NoError(t, err)
+	require.Equal(t, []any{"one"}, entryPayloads(entries))
+
+	empty, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	entries, err = empty.RevRange(StartFromBeginning, StartFromEnd, 0)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestWithRangeExclusiveStart(t *testing.T) {
+	opts := rangeOptions{}
+	ro := WithRangeExclusiveStart(true)
+	ro.apply(&opts)
+	require.True(t, opts.ExclusiveStart)
+}
+
+func TestWithRangeExclusiveEnd(t *testing.T) {
+	opts := rangeOptions{}
+	ro := WithRangeExclusiveEnd(true)
+	ro.apply(&opts)
+	require.True(t, opts.ExclusiveEnd)
+}
+
+func TestLog_Get(t *testing.T) {
+	l := newRangeTestLog(t)
+	e, err := l.Get(fakeTestEntryID2)
+	require.NoError(t, err)
+	require.Equal(t, Entry{ID: fakeTestEntryID2, Payload: "two"}, e)
+
+	_, err = l.Get(NewEntryID(fakeTestEntryID3.time, 100))
+	require.ErrorIs(t, err, ErrNoSuchEntry)
+	require.Empty(t, l.groups["group1"].ListPendingEntries())
+}
+
+func TestLog_GetMany(t *testing.T) {
+	l := newRangeTestLog(t)
+	entries, err := l.GetMany([]EntryID{fakeTestEntryID3, NewEntryID(fakeTestEntryID3.time, 100), fakeTestEntryID1})
+	require.NoError(t, err)
+	require.Equal(t, []any{"three", "one"}, entryPayloads(entries))
+
+	entries, err = l.GetMany(nil)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestLog_SeekTime(t *testing.T) {
+	l := newSearchTestLog(t)
+	at := func(i int) time.Time {
+		return searchTestEpoch.Add(time.Duration(i) * time.Millisecond)
+	}
+
+	id, err := l.SeekTime(at(3))
+	require.NoError(t, err)
+	require.Equal(t, NewEntryID(at(3), 0), id)
+
+	// times between log entries seek to the next log entry
+	require.NoError(t, l.remove(NewEntryID(at(5), 0)))
+	id, err = l.SeekTime(at(5))
+	require.NoError(t, err)
+	require.Equal(t, NewEntryID(at(6), 0), id)
+
+	// times within a millisecond seek to the log entries written after that millisecond
+	id, err = l.SeekTime(at(3).Add(500 * time.Microsecond))
+	require.NoError(t, err)
+	require.Equal(t, NewEntryID(at(4), 0), id)
+
+	id, err = l.SeekTime(time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, NewEntryID(at(0), 0), id)
+
+	_, err = l.SeekTime(at(10))
+	require.ErrorIs(t, err, ErrNoMoreEntries)
+}
@@ -0,0 +1,38 @@
+package historitor
+
+type readOptions struct {
+	// Filter is the filter log entries must match to be read. It is nil if log entries are not filtered.
+	Filter *Filter
+}
+
+var defaultReadOptions = readOptions{}
+
+// ReadOption is an option for configuring a read with [Log.Read] and [Log.ReadContext].
+type ReadOption interface {
+	apply(*readOptions)
+}
+
+// funcReadOption is a ReadOption that calls a function.
+// It is used to wrap a function, so it satisfies the ReadOption interface.
+type funcReadOption struct {
+	f func(*readOptions)
+}
+
+func (fdo *funcReadOption) apply(opts *readOptions) {
+	fdo.f(opts)
+}
+
+func newFuncReadOption(f func(*readOptions)) *funcReadOption {
+	return &funcReadOption{
+		f: f,
+	}
+}
+
+// WithReadFilter sets a [Filter] log entries must match to be read. Log entries not matching the filter are skipped by
+// the Consumer group, as if they had been read and acknowledged, so they are not read by any member of the Consumer
+// group. Pending log entries re-delivered to the Consumer are not filtered, as they were read before.
+func WithReadFilter(filter *Filter) ReadOption {
+	return newFuncReadOption(func(opts *readOptions) {
+		opts.Filter = filter
+	})
+}
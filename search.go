@@ -0,0 +1,137 @@
+package historitor
+
+import (
+	"fmt"
+	"slices"
+)
+
+// searchChunkSize is the number of log entries [Log.Search] reads each time it acquires the lock of the log.
+const searchChunkSize = 256
+
+// SearchResult is the result of a search with [Log.Search].
+type SearchResult struct {
+	// Entries holds the matching log entries, in ascending order.
+	Entries []Entry
+	// Cursor is set if the search stopped because the limit set with [WithSearchLimit] was reached. Passing it to
+	// [WithSearchCursor] continues the search after the last log entry in Entries. Cursor is the zero EntryID once
+	// every log entry has been searched.
+	Cursor EntryID
+}
+
+// searchBounds are the bounds of the log entries searched by [Log.Search].
+type searchBounds struct {
+	from, to                   EntryID
+	fromExclusive, toExclusive bool
+}
+
+// contains reports whether id is within the bounds.
+func (b searchBounds) contains(id EntryID) bool {
+	c := id.Compare(b.from)
+	if c < 0 || (c == 0 && b.fromExclusive) {
+		return false
+	}
+	c = id.Compare(b.to)
+	return c < 0 || (c == 0 && !b.toExclusive)
+}
+
+// Search returns the log entries whose payloads match predicate, in ascending order. A nil predicate matches every log
+// entry. The log entries searched can be bounded by ID with [WithSearchStart] and [WithSearchEnd], and by the time
+// they were written with [WithSearchSince] and [WithSearchUntil]. The number of log entries returned can be limited
+// with [WithSearchLimit], in which case the search can be continued using the cursor of the [SearchResult] with
+// [WithSearchCursor].
+//
+// By default, every log entry within the bounds is read and passed to predicate. If the search is restricted to the
+// log entries indexed by a value with [WithSearchIndex], only those log entries are read. If no index with the given
+// name exists, an error wrapping [ErrNoSuchIndex] is returned.
+//
+// The log is read in chunks, and predicate is called without holding the lock of the log, so the log may be changed
+// while searching. Like [Log.Range], Search does not change the Pending Entries List of any Consumer group.
+//
+// Search is safe for concurrent use.
+func (l *Log) Search(predicate func(payload any) bool, options ...SearchOption) (SearchResult, error) {
+	opts := defaultSearchOptions
+	for _, opt := range options {
+		opt.apply(&opts)
+	}
+
+	l.treeMux.RLock()
+	from, to, _ := l.rangeBounds(opts.Start, opts.End, nil)
+	b := searchBounds{from: from, to: to}
+	if !opts.Since.IsZero() {
+		if since := entryIDAtOrAfter(opts.Since); since.Compare(b.from) > 0 {
+			b.from, b.fromExclusive = since, false
+		}
+	}
+	if !opts.Until.IsZero() {
+		if until := entryIDAtOrAfter(opts.Until); until.Compare(b.to) <= 0 {
+			b.to, b.toExclusive = until, true
+		}
+	}
+	if !opts.Cursor.IsZero() && opts.Cursor.Compare(b.from) >= 0 {
+		b.from, b.fromExclusive = opts.Cursor, true
+	}
+	var candidates []EntryID
+	if opts.Index != "" {
+		x, ok := l.indexes[opts.Index]
+		if !ok {
+			l.treeMux.RUnlock()
+			return SearchResult{}, fmt.Errorf("%w: %s", ErrNoSuchIndex, opts.Index)
+		}
+		// the IDs are copied, as the index may change once the lock is released
+		for _, id := range x.lookup(opts.IndexValue) {
+			if b.contains(id) {
+				candidates = append(candidates, id)
+			}
+		}
+	}
+	l.treeMux.RUnlock()
+
+	result := SearchResult{Entries: make([]Entry, 0)}
+	// add adds e to the result if it matches, and reports whether the search should continue
+	add := func(e Entry) bool {
+		if opts.Filter != nil && !opts.Filter.Match(e) {
+			return true
+		}
+		if predicate != nil && !predicate(e.Payload) {
+			return true
+		}
+		result.Entries = append(result.Entries, e)
+		if opts.Limit > 0 && len(result.Entries) >= opts.Limit {
+			result.Cursor = e.ID
+			return false
+		}
+		return true
+	}
+
+	if opts.Index != "" {
+		for chunk := range slices.Chunk(candidates, searchChunkSize) {
+			entries, err := l.GetMany(chunk)
+			if err != nil {
+				return SearchResult{}, err
+			}
+			for _, e := range entries {
+				if !add(e) {
+					return result, nil
+				}
+			}
+		}
+		return result, nil
+	}
+
+	for {
+		entries, err := l.Range(b.from, b.to, searchChunkSize, WithRangeExclusiveStart(b.fromExclusive),
+			WithRangeExclusiveEnd(b.toExclusive))
+		if err != nil {
+			return SearchResult{}, err
+		}
+		for _, e := range entries {
+			if !add(e) {
+				return result, nil
+			}
+		}
+		if len(entries) < searchChunkSize {
+			return result, nil
+		}
+		b.from, b.fromExclusive = entries[len(entries)-1].ID, true
+	}
+}
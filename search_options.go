@@ -0,0 +1,117 @@
+package historitor
+
+import (
+	"time"
+)
+
+type searchOptions struct {
+	// Start and End are the IDs of the first and last log entries searched, inclusive.
+	Start EntryID
+	End   EntryID
+	// Since and Until bound the search to log entries written at or after Since, and before Until. The zero time means
+	// unbounded.
+	Since time.Time
+	Until time.Time
+	// Limit is the maximum number of log entries returned. Zero means unlimited.
+	Limit int
+	// Cursor is the cursor returned by a previous search, which the search continues after.
+	Cursor EntryID
+	// Index and IndexValue restrict the search to the log entries indexed by IndexValue in the index named Index. An
+	// empty Index means every log entry is searched.
+	Index      string
+	IndexValue string
+	// Filter is the filter log entries must match, in addition to the predicate. It is nil if log entries are only
+	// matched by the predicate.
+	Filter *Filter
+}
+
+var defaultSearchOptions = searchOptions{
+	Start: StartFromBeginning,
+	End:   StartFromEnd,
+}
+
+// SearchOption is an option for configuring a search with [Log.Search].
+type SearchOption interface {
+	apply(*searchOptions)
+}
+
+// funcSearchOption is a SearchOption that calls a function.
+// It is used to wrap a function, so it satisfies the SearchOption interface.
+type funcSearchOption struct {
+	f func(*searchOptions)
+}
+
+func (fdo *funcSearchOption) apply(opts *searchOptions) {
+	fdo.f(opts)
+}
+
+func newFuncSearchOption(f func(*searchOptions)) *funcSearchOption {
+	return &funcSearchOption{
+		f: f,
+	}
+}
+
+// WithSearchStart sets the ID of the first log entry searched, inclusive. The default is [StartFromBeginning].
+func WithSearchStart(start EntryID) SearchOption {
+	return newFuncSearchOption(func(opts *searchOptions) {
+		opts.Start = start
+	})
+}
+
+// WithSearchEnd sets the ID of the last log entry searched, inclusive. The default is [StartFromEnd].
+func WithSearchEnd(end EntryID) SearchOption {
+	return newFuncSearchOption(func(opts *searchOptions) {
+		opts.End = end
+	})
+}
+
+// WithSearchSince restricts the search to log entries written at or after the given time, as recorded in their IDs.
+// As IDs record the millisecond a log entry was written, a time within a millisecond excludes the log entries written
+// in that millisecond.
+func WithSearchSince(since time.Time) SearchOption {
+	return newFuncSearchOption(func(opts *searchOptions) {
+		opts.Since = since
+	})
+}
+
+// WithSearchUntil restricts the search to log entries written before the given time, as recorded in their IDs. As IDs
+// record the millisecond a log entry was written, a time within a millisecond includes the log entries written in that
+// millisecond.
+func WithSearchUntil(until time.Time) SearchOption {
+	return newFuncSearchOption(func(opts *searchOptions) {
+		opts.Until = until
+	})
+}
+
+// WithSearchLimit sets the maximum number of log entries returned by the search. If more log entries match, the
+// [SearchResult] holds a cursor to continue the search with. The default is zero, which means unlimited.
+func WithSearchLimit(limit int) SearchOption {
+	return newFuncSearchOption(func(opts *searchOptions) {
+		opts.Limit = limit
+	})
+}
+
+// WithSearchCursor continues a previous search after the last log entry it returned, using the cursor of its
+// [SearchResult]. The other options should be the same as those of the previous search.
+func WithSearchCursor(cursor EntryID) SearchOption {
+	return newFuncSearchOption(func(opts *searchOptions) {
+		opts.Cursor = cursor
+	})
+}
+
+// WithSearchIndex restricts the search to the log entries indexed by value in the index with the given name, see
+// [WithLogIndex]. Only those log entries are read, rather than every log entry in the log.
+func WithSearchIndex(name, value string) SearchOption {
+	return newFuncSearchOption(func(opts *searchOptions) {
+		opts.Index = name
+		opts.IndexValue = value
+	})
+}
+
+// WithSearchFilter restricts the search to log entries matching filter, in addition to the predicate passed to
+// [Log.Search], which may be nil to search by the filter alone.
+func WithSearchFilter(filter *Filter) SearchOption {
+	return newFuncSearchOption(func(opts *searchOptions) {
+		opts.Filter = filter
+	})
+}
@@ -0,0 +1,250 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// searchTestEpoch is the time the first log entry of newSearchTestLog is written at.
+var searchTestEpoch = time.UnixMilli(1700000000000)
+
+// parity is an IndexExtractor indexing int payloads by whether they are even or odd.
+func parity(payload any) []string {
+	i, ok := payload.(int)
+	if !ok {
+		return nil
+	}
+	if i%2 == 0 {
+		return []string{"even"}
+	}
+	return []string{"odd"}
+}
+
+// newSearchTestLog returns a log holding ten log entries with the payloads 0 through 9, written a millisecond apart
+// starting at searchTestEpoch, and indexed by parity in an index named parity.
+func newSearchTestLog(t *testing.T, options ...LogOption) *Log {
+	t.Helper()
+	l, err := NewLog(append([]LogOption{WithLogName(t.Name()), WithLogIndex("parity", parity)}, options...)...)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		id := NewEntryID(searchTestEpoch.Add(time.Duration(i)*time.Millisecond), 0)
+		require.NoError(t, l.write(&id, "", i))
+	}
+	return l
+}
+
+func isEven(payload any) bool {
+	return payload.(int)%2 == 0
+}
+
+func TestLog_Search(t *testing.T) {
+	l := newSearchTestLog(t)
+	at := func(i int) EntryID {
+		return NewEntryID(searchTestEpoch.Add(time.Duration(i)*time.Millisecond), 0)
+	}
+	tests := []struct {
+		name      string
+		predicate func(any) bool
+		options   []SearchOption
+		want      []any
+	}{
+		{name: "all", want: []any{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{name: "predicate", predicate: isEven, want: []any{0, 2, 4, 6, 8}},
+		{
+			name:      "bounds",
+			predicate: isEven,
+			options:   []SearchOption{WithSearchStart(at(3)), WithSearchEnd(at(6))},
+			want:      []any{4, 6},
+		},
+		{
+			name:    "time_bounds",
+			options: []SearchOption{WithSearchSince(at(2).time), WithSearchUntil(at(5).time)},
+			want:    []any{2, 3, 4},
+		},
+		{
+			name: "time_bounds_within_millisecond",
+			options: []SearchOption{
+				WithSearchSince(at(2).time.Add(time.Microsecond)),
+				WithSearchUntil(at(5).time.Add(time.Microsecond)),
+			},
+			want: []any{3, 4, 5},
+		},
+		{
+			name: "time_bounds_before_millisecond",
+			options: []SearchOption{
+				WithSearchSince(at(2).time.Add(-time.Microsecond)),
+				WithSearchUntil(at(5).time.Add(-time.Microsecond)),
+			},
+			want: []any{2, 3, 4},
+		},
+		{
+			name:    "time_bounds_narrowed_by_ids",
+			options: []SearchOption{WithSearchStart(at(3)), WithSearchSince(at(2).time), WithSearchUntil(at(5).time)},
+			want:    []any{3, 4},
+		},
+		{
+			name:      "index",
+			predicate: func(payload any) bool { return payload.(int) > 4 },
+			options:   []SearchOption{WithSearchIndex("parity", "odd")},
+			want:      []any{5, 7, 9},
+		},
+		{
+			name:    "index_bounds",
+			options: []SearchOption{WithSearchIndex("parity", "even"), WithSearchSince(at(3).time)},
+			want:    []any{4, 6, 8},
+		},
+		{name: "index_no_value", options: []SearchOption{WithSearchIndex("parity", "none")}, want: []any{}},
+		{name: "none", predicate: func(any) bool { return false }, want: []any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := l.Search(tt.predicate, tt.options...)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, entryPayloads(result.Entries))
+			require.True(t, result.Cursor.IsZero())
+		})
+	}
+}
+
+func TestLog_Search_cursor(t *testing.T) {
+	for _, options := range map[string][]SearchOption{
+		"scan":  nil,
+		"index": {WithSearchIndex("parity", "even")},
+	} {
+		l := newSearchTestLog(t)
+		var got []any
+		var cursor EntryID
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 3)
+			opts := append([]SearchOption{WithSearchLimit(2), WithSearchCursor(cursor)}, options...)
+			result, err := l.Search(isEven, opts...)
+			require.NoError(t, err)
+			got = append(got, entryPayloads(result.Entries)...)
+			if result.Cursor.IsZero() {
+				break
+			}
+			require.Equal(t, result.Entries[len(result.Entries)-1].ID, result.Cursor)
+			cursor = result.Cursor
+		}
+		require.Equal(t, []any{0, 2, 4, 6, 8}, got)
+	}
+}
+
+func TestLog_Search_chunks(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogIndex("parity", parity))
+	require.NoError(t, err)
+	for i := 0; i < searchChunkSize*2+1; i++ {
+		_, err = l.Write(i)
+		require.NoError(t, err)
+	}
+
+	result, err := l.Search(nil)
+	require.NoError(t, err)
+	require.Len(t, result.Entries, searchChunkSize*2+1)
+	require.Equal(t, searchChunkSize*2, result.Entries[len(result.Entries)-1].Payload)
+
+	result, err = l.Search(nil, WithSearchIndex("parity", "even"))
+	require.NoError(t, err)
+	require.Len(t, result.Entries, searchChunkSize+1)
+}
+
+func TestLog_Search_noSuchIndex(t *testing.T) {
+	l := newSearchTestLog(t)
+	_, err := l.Search(nil, WithSearchIndex("missing", "value"))
+	require.ErrorIs(t, err, ErrNoSuchIndex)
+}
+
+func TestLog_Search_indexMaintained(t *testing.T) {
+	l := newSearchTestLog(t, WithLogKeyCompaction(true))
+	even := func() []any {
+		t.Helper()
+		result, err := l.Search(nil, WithSearchIndex("parity", "even"))
+		require.NoError(t, err)
+		return entryPayloads(result.Entries)
+	}
+
+	require.True(t, l.UpdateEntry(NewEntryID(searchTestEpoch, 0), 11))
+	require.Equal(t, []any{2, 4, 6, 8}, even())
+
+	_, err := l.WriteKey("k", 12)
+	require.NoError(t, err)
+	_, err = l.WriteKey("k", 13)
+	require.NoError(t, err)
+	require.Equal(t, []any{2, 4, 6, 8, 12}, even())
+	require.NoError(t, l.Compact())
+	require.Equal(t, []any{2, 4, 6, 8}, even())
+
+	snapshot := newSearchTestLog(t)
+	b, err := snapshot.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, l.UnmarshalBinary(b))
+	require.Equal(t, []any{0, 2, 4, 6, 8}, even())
+}
+
+func TestLog_Search_mmap(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogIndex("parity", parity), WithLogWAL(t.TempDir()),
+		WithLogMmap(true))
+	require.NoError(t, err)
+	defer l.Close()
+	for i := 0; i < 5; i++ {
+		_, err = l.Write(i)
+		require.NoError(t, err)
+	}
+
+	result, err := l.Search(isEven, WithSearchIndex("parity", "even"))
+	require.NoError(t, err)
+	require.Equal(t, []any{0, 2, 4}, entryPayloads(result.Entries))
+}
+
+func TestWithSearchStart(t *testing.T) {
+	opts := searchOptions{}
+	so := WithSearchStart(fakeTestEntryID1)
+	so.apply(&opts)
+	require.Equal(t, fakeTestEntryID1, opts.Start)
+}
+
+func TestWithSearchEnd(t *testing.T) {
+	opts := searchOptions{}
+	so := WithSearchEnd(fakeTestEntryID1)
+	so.apply(&opts)
+	require.Equal(t, fakeTestEntryID1, opts.End)
+}
+
+func TestWithSearchSince(t *testing.T) {
+	opts := searchOptions{}
+	so := WithSearchSince(searchTestEpoch)
+	so.apply(&opts)
+	require.Equal(t, searchTestEpoch, opts.Since)
+}
+
+func TestWithSearchUntil(t *testing.T) {
+	opts := searchOptions{}
+	so := WithSearchUntil(searchTestEpoch)
+	so.apply(&opts)
+	require.Equal(t, searchTestEpoch, opts.Until)
+}
+
+func TestWithSearchLimit(t *testing.T) {
+	opts := searchOptions{}
+	so := WithSearchLimit(10)
+	so.apply(&opts)
+	require.Equal(t, 10, opts.Limit)
+}
+
+func TestWithSearchCursor(t *testing.T) {
+	opts := searchOptions{}
+	so := WithSearchCursor(fakeTestEntryID1)
+	so.apply(&opts)
+	require.Equal(t, fakeTestEntryID1, opts.Cursor)
+}
+
+func TestWithSearchIndex(t *testing.T) {
+	opts := searchOptions{}
+	so := WithSearchIndex("name", "value")
+	so.apply(&opts)
+	require.Equal(t, "name", opts.Index)
+	require.Equal(t, "value", opts.IndexValue)
+}
@@ -0,0 +1,545 @@
+package historitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// segmentExt is the file extension of segment files.
+	segmentExt = ".seg"
+	// segmentIndexExt is the file extension of the sparse index written alongside a sealed segment.
+	segmentIndexExt = ".idx"
+	// segmentIndexInterval is the minimum number of bytes between two entries in the sparse index of a segment.
+	segmentIndexInterval = 4096
+)
+
+// segmentHeader is written at the start of every segment whose records carry a checksum, followed by the version of
+// the segment format. Segments written before checksums were introduced start directly with the length of the first
+// record, which is encoded by encodeUnsignedInt and therefore never starts with the first byte of segmentHeader.
+//
+// From version 2 onwards, the header is followed by the time the segment was created, as the number of nanoseconds
+// since the Unix epoch, see segmentCreatedSize. Segments of version 1 carry no creation time.
+var segmentHeader = []byte{0x89, 'H', 'S', 'G', 2}
+
+// segmentHeaderV1 is the header of segments of version 1.
+var segmentHeaderV1 = []byte{0x89, 'H', 'S', 'G', 1}
+
+// segmentCreatedSize is the size, in bytes, of the creation time following segmentHeader.
+const segmentCreatedSize = 8
+
+// corruptRecordError is returned by segment.replay when a record fails verification against its checksum.
+type corruptRecordError struct {
+	// offset is the offset of the corrupted record in the segment.
+	offset int64
+	// id is the ID of the log entry changed by the corrupted record, if it could be determined.
+	id EntryID
+}
+
+func (e *corruptRecordError) Error() string {
+	return fmt.Sprintf("corrupted record at offset %d", e.offset)
+}
+
+// segmentIndexEntry maps the ID of a log entry to the offset of the record that wrote it.
+type segmentIndexEntry struct {
+	ID     EntryID
+	Offset int64
+}
+
+// segment is a single file of the write-ahead log. Records are appended to the active segment until it grows larger
+// than [WithLogSegmentMaxBytes] or older than [WithLogSegmentMaxAge], at which point it is sealed and a new segment is
+// created. Nothing is appended to sealed segments, which are only rewritten by compaction.
+//
+// Every segment keeps a sparse index of the log entries written to it, mapping [EntryID] to the offset of the record
+// in the segment. An entry is added to the index for the first record in the segment and then for every record
+// written at least segmentIndexInterval bytes after the previously indexed record. The index of a sealed segment is
+// persisted next to it.
+//
+// Segments start with segmentHeader, and every record in them is followed by its checksum. Segments written before
+// checksums were introduced have neither, and are sealed as soon as a record is to be appended to them.
+//
+// Sealed segments may be mapped into memory with mmap, in which case records are decoded directly from the mapping
+// rather than read from the file.
+type segment struct {
+	base    uint64
+	path    string
+	file    *os.File
+	size    int64
+	created time.Time
+	sealed  bool
+	index   []segmentIndexEntry
+	// checksummed is set if the segment starts with segmentHeader and its records carry checksums.
+	checksummed bool
+	// start is the offset of the first record in the segment.
+	start int64
+	// records is the number of records in the segment, and garbage the number of those records superseded by later
+	// records, see wal.track.
+	records int
+	garbage int
+	// data holds the contents of the sealed segment mapped into memory, or nil if the segment is not mapped, see mmap.
+	data []byte
+}
+
+// segmentPath returns the path of the file with the given extension for the segment with the given base.
+func segmentPath(dir string, base uint64, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, ext))
+}
+
+// listSegments returns the bases of the segments in dir in ascending order.
+func listSegments(dir string) ([]uint64, error) {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments: %w", err)
+	}
+	var bases []uint64
+	for _, de := range des {
+		name, ok := strings.CutSuffix(de.Name(), segmentExt)
+		if !ok || de.IsDir() {
+			continue
+		}
+		base, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool {
+		return bases[i] < bases[j]
+	})
+	return bases, nil
+}
+
+// createSegment creates a new, empty, active segment.
+func createSegment(dir string, base uint64) (*segment, error) {
+	path := segmentPath(dir, base, segmentExt)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment: %w", err)
+	}
+	s := &segment{
+		base:        base,
+		path:        path,
+		file:        f,
+		created:     time.Now(),
+		checksummed: true,
+	}
+	err = s.writeHeader()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// writeHeader writes segmentHeader and the creation time of the segment to the start of an empty segment.
+func (s *segment) writeHeader() error {
+	b := binary.BigEndian.AppendUint64(bytes.Clone(segmentHeader), uint64(s.created.UnixNano()))
+	_, err := s.file.WriteAt(b, 0)
+	if err != nil {
+		return fmt.Errorf("failed to write header of segment %d: %w", s.base, err)
+	}
+	s.size = int64(len(b))
+	s.start = s.size
+	return nil
+}
+
+// readHeader determines whether the segment starts with segmentHeader, and reads the creation time of the segment
+// from it. The header of an active segment that was only partially written, which happens if the process crashed
+// while creating the segment, is rewritten.
+//
+// Segments without a creation time are assumed to have been created when they were last modified, which is the
+// closest approximation available.
+func (s *segment) readHeader() error {
+	if s.size == 0 {
+		return nil
+	}
+	b := make([]byte, min(s.size, int64(len(segmentHeader)+segmentCreatedSize)))
+	_, err := s.file.ReadAt(b, 0)
+	if err != nil {
+		return fmt.Errorf("failed to read header of segment %d: %w", s.base, err)
+	}
+	if b[0] != segmentHeader[0] {
+		return s.statCreated()
+	}
+	switch {
+	case bytes.HasPrefix(b, segmentHeaderV1):
+		s.checksummed = true
+		s.start = int64(len(segmentHeader))
+		return s.statCreated()
+	case len(b) == len(segmentHeader)+segmentCreatedSize && bytes.HasPrefix(b, segmentHeader):
+		s.checksummed = true
+		s.start = int64(len(b))
+		s.created = time.Unix(0, int64(binary.BigEndian.Uint64(b[len(segmentHeader):])))
+		return nil
+	case !s.sealed && (bytes.HasPrefix(segmentHeader, b) || bytes.HasPrefix(b, segmentHeader)):
+		s.checksummed = true
+		s.created = time.Now()
+		return s.writeHeader()
+	}
+	return fmt.Errorf("unsupported format of segment %d", s.base)
+}
+
+// statCreated sets the creation time of a segment whose header holds none to the time the segment was last modified.
+func (s *segment) statCreated() error {
+	fi, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat segment %d: %w", s.base, err)
+	}
+	s.created = fi.ModTime()
+	return nil
+}
+
+// openSegment opens an existing segment. Sealed segments are opened read-only and their sparse index is loaded, if it
+// exists. The sparse index of the active segment is rebuilt when it is replayed.
+func openSegment(dir string, base uint64, sealed bool) (*segment, error) {
+	path := segmentPath(dir, base, segmentExt)
+	flag := os.O_RDWR
+	if sealed {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open segment: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to open segment: %w", err)
+	}
+	s := &segment{
+		base:    base,
+		path:    path,
+		file:    f,
+		size:    fi.Size(),
+		created: time.Now(),
+		sealed:  sealed,
+	}
+	err = s.readHeader()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if sealed {
+		err = s.readIndex()
+		if err != nil {
+			// the index only holds information derived from the segment, so an index that is missing or cannot be
+			// read is rebuilt when the segment is replayed
+			s.index = nil
+		}
+	}
+	return s, nil
+}
+
+// replay calls fn for every record in the segment, along with the offset of the record. If the active segment ends
+// with a partially written record, the segment is truncated to the last complete record. The sparse index is rebuilt
+// if it was not loaded when the segment was opened.
+//
+// If a record fails verification against its checksum, a *corruptRecordError is returned.
+func (s *segment) replay(fn func(rec walRecord, offset int64) error) error {
+	rebuildIndex := len(s.index) == 0
+	r := bufio.NewReader(io.NewSectionReader(s.file, s.start, s.size-s.start))
+	offset := s.start
+	for {
+		rec, n, err := readWALRecord(r, s.checksummed)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) && !s.sealed {
+			err = s.truncate(offset)
+			if err != nil {
+				return fmt.Errorf("failed to truncate partially written record in segment %d: %w", s.base, err)
+			}
+			break
+		}
+		if errors.Is(err, errChecksumMismatch) {
+			return &corruptRecordError{offset: offset, id: recordEntryID(rec)}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read segment %d at offset %d: %w", s.base, offset, err)
+		}
+		if rebuildIndex {
+			s.track(rec, offset)
+		}
+		err = fn(rec, offset)
+		if err != nil {
+			return fmt.Errorf("failed to replay segment %d at offset %d: %w", s.base, offset, err)
+		}
+		offset += int64(n)
+	}
+	if s.sealed && rebuildIndex {
+		return s.writeIndex()
+	}
+	return nil
+}
+
+// track adds the record at offset to the sparse index if it writes a log entry and is far enough from the previously
+// indexed record.
+func (s *segment) track(rec walRecord, offset int64) {
+	if rec.Op != walOpWrite {
+		return
+	}
+	if len(s.index) > 0 && offset-s.index[len(s.index)-1].Offset < segmentIndexInterval {
+		return
+	}
+	s.index = append(s.index, segmentIndexEntry{ID: rec.ID, Offset: offset})
+}
+
+// append appends an encoded record to the active segment.
+func (s *segment) append(b []byte, rec walRecord) error {
+	if s.sealed {
+		return fmt.Errorf("segment %d is sealed", s.base)
+	}
+	_, err := s.file.WriteAt(b, s.size)
+	if err != nil {
+		return err
+	}
+	s.track(rec, s.size)
+	s.size += int64(len(b))
+	return nil
+}
+
+// full reports whether the segment has reached one of the configured bounds and should be sealed. Segments written
+// before checksums were introduced are always full, as records with checksums cannot be appended to them.
+func (s *segment) full(maxBytes int64, maxAge time.Duration) bool {
+	if !s.checksummed {
+		return true
+	}
+	if s.size == s.start {
+		return false
+	}
+	if maxBytes > 0 && s.size >= maxBytes {
+		return true
+	}
+	return maxAge > 0 && time.Since(s.created) >= maxAge
+}
+
+// seal makes the segment immutable. The segment is flushed to stable storage, its sparse index is written, and the
+// segment is re-opened read-only.
+func (s *segment) seal() error {
+	err := s.file.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to seal segment %d: %w", s.base, err)
+	}
+	err = s.writeIndex()
+	if err != nil {
+		return err
+	}
+	err = s.file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to seal segment %d: %w", s.base, err)
+	}
+	err = os.Chmod(s.path, 0o444)
+	if err != nil {
+		return fmt.Errorf("failed to seal segment %d: %w", s.base, err)
+	}
+	s.file, err = os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to seal segment %d: %w", s.base, err)
+	}
+	s.sealed = true
+	return nil
+}
+
+// indexPath returns the path of the sparse index of the segment.
+func (s *segment) indexPath() string {
+	return strings.TrimSuffix(s.path, segmentExt) + segmentIndexExt
+}
+
+// writeIndex writes the sparse index of the segment, followed by its checksum.
+func (s *segment) writeIndex() error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(s.index)
+	if err != nil {
+		return fmt.Errorf("failed to encode index of segment %d: %w", s.base, err)
+	}
+	// an index that could not be read is rewritten, and as it is read-only it must be removed first
+	err = os.Remove(s.indexPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to write index of segment %d: %w", s.base, err)
+	}
+	err = os.WriteFile(s.indexPath(), appendChecksum(buf.Bytes(), buf.Bytes()), 0o444)
+	if err != nil {
+		return fmt.Errorf("failed to write index of segment %d: %w", s.base, err)
+	}
+	return nil
+}
+
+func (s *segment) readIndex() error {
+	b, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		return err
+	}
+	if len(b) < checksumSize || !verifyChecksum(b[:len(b)-checksumSize], b[len(b)-checksumSize:]) {
+		return fmt.Errorf("failed to verify index of segment %d: %w", s.base, errChecksumMismatch)
+	}
+	err = gob.NewDecoder(bytes.NewReader(b[:len(b)-checksumSize])).Decode(&s.index)
+	if err != nil {
+		return fmt.Errorf("failed to decode index of segment %d: %w", s.base, err)
+	}
+	return nil
+}
+
+// firstID returns the ID of the first log entry written to the segment. If no log entries were written to the
+// segment, it returns false.
+func (s *segment) firstID() (EntryID, bool) {
+	if len(s.index) == 0 {
+		return ZeroEntryID, false
+	}
+	return s.index[0].ID, true
+}
+
+// lookup returns the record that wrote the log entry with the given ID. The sparse index is used to find the closest
+// preceding record, from which the segment is scanned. If the log entry was not written to the segment,
+// [ErrNoSuchEntry] is returned.
+//
+// The returned record holds the payload the log entry was written with, and does not reflect later updates, unless
+// the segment has been compacted.
+func (s *segment) lookup(id EntryID) (walRecord, error) {
+	i := sort.Search(len(s.index), func(i int) bool {
+		return s.index[i].ID.Compare(id) > 0
+	})
+	if i == 0 {
+		return walRecord{}, fmt.Errorf("%w: %s", ErrNoSuchEntry, id)
+	}
+	offset := s.index[i-1].Offset
+	for {
+		rec, n, err := s.recordAt(offset)
+		if errors.Is(err, io.EOF) {
+			return walRecord{}, fmt.Errorf("%w: %s", ErrNoSuchEntry, id)
+		}
+		if err != nil {
+			return walRecord{}, err
+		}
+		offset += int64(n)
+		if rec.Op != walOpWrite {
+			continue
+		}
+		switch rec.ID.Compare(id) {
+		case 0:
+			return rec, nil
+		case 1:
+			return walRecord{}, fmt.Errorf("%w: %s", ErrNoSuchEntry, id)
+		}
+	}
+}
+
+// recordAt reads the record at offset in the segment, returning the record and its size. If offset is at the end of
+// the segment, io.EOF is returned. Records of mapped segments are decoded directly from the mapping.
+func (s *segment) recordAt(offset int64) (walRecord, int, error) {
+	var rec walRecord
+	var n int
+	var err error
+	if s.data != nil {
+		rec, n, err = decodeWALRecord(s.data[offset:s.size], s.checksummed)
+	} else {
+		rec, n, err = readWALRecord(bufio.NewReader(io.NewSectionReader(s.file, offset, s.size-offset)), s.checksummed)
+	}
+	if errors.Is(err, io.EOF) {
+		return rec, n, err
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		return rec, n, &CorruptionError{
+			ID:     recordEntryID(rec),
+			Source: fmt.Sprintf("WAL segment %d at offset %d", s.base, offset),
+		}
+	}
+	if err != nil {
+		return rec, n, fmt.Errorf("failed to read segment %d at offset %d: %w", s.base, offset, err)
+	}
+	return rec, n, nil
+}
+
+// mmap maps the sealed segment into memory. Mapping a segment that is already mapped does nothing.
+func (s *segment) mmap() error {
+	if !s.sealed || s.data != nil {
+		return nil
+	}
+	data, err := mmap(s.file, s.size)
+	if err != nil {
+		return fmt.Errorf("failed to map segment %d: %w", s.base, err)
+	}
+	s.data = data
+	return nil
+}
+
+// munmap unmaps the segment, if it is mapped.
+func (s *segment) munmap() error {
+	err := munmap(s.data)
+	s.data = nil
+	if err != nil {
+		return fmt.Errorf("failed to unmap segment %d: %w", s.base, err)
+	}
+	return nil
+}
+
+// truncate discards everything in the active segment from offset onwards.
+func (s *segment) truncate(offset int64) error {
+	err := s.file.Truncate(offset)
+	if err != nil {
+		return err
+	}
+	s.size = offset
+	i := sort.Search(len(s.index), func(i int) bool {
+		return s.index[i].Offset >= offset
+	})
+	s.index = s.index[:i]
+	return nil
+}
+
+// unseal makes a sealed segment the active segment again. It is only used when truncating the write-ahead log.
+func (s *segment) unseal() error {
+	err := s.munmap()
+	if err != nil {
+		return err
+	}
+	err = s.file.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(s.indexPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err = os.Chmod(s.path, 0o644)
+	if err != nil {
+		return err
+	}
+	s.file, err = os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	s.sealed = false
+	return nil
+}
+
+// remove closes the segment and removes it and its index.
+func (s *segment) remove() error {
+	err := s.close()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(s.path)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(s.indexPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *segment) close() error {
+	return errors.Join(s.munmap(), s.file.Close())
+}
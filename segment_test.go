@@ -0,0 +1,209 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestListSegments(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"00000000000000000002.seg", "00000000000000000000.seg", "00000000000000000000.idx", "other.seg"} {
+		require.NoError(t, os.WriteFile(dir+"/"+name, nil, 0o644))
+	}
+	bases, err := listSegments(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 2}, bases)
+}
+
+func TestSegment_full(t *testing.T) {
+	s := &segment{created: time.Now(), checksummed: true}
+	require.False(t, s.full(1, time.Nanosecond))
+	s.size = 10
+	require.True(t, s.full(10, 0))
+	require.False(t, s.full(11, 0))
+	require.False(t, s.full(0, time.Hour))
+	s.created = time.Now().Add(-2 * time.Hour)
+	require.True(t, s.full(0, time.Hour))
+}
+
+// TestOpenSegment_created tests that the creation time of a segment is read from its header, rather than derived from
+// the IDs of the log entries written to it, which may hold any time.
+func TestOpenSegment_created(t *testing.T) {
+	dir := t.TempDir()
+	s, err := createSegment(dir, 0)
+	require.NoError(t, err)
+	created := s.created
+	rec := walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}
+	b, err := encodeWALRecord(rec)
+	require.NoError(t, err)
+	require.NoError(t, s.append(b, rec))
+	require.NoError(t, s.close())
+
+	s, err = openSegment(dir, 0, false)
+	require.NoError(t, err)
+	defer func() {
+		_ = s.close()
+	}()
+	require.NoError(t, s.replay(func(rec walRecord, offset int64) error {
+		return nil
+	}))
+	require.True(t, created.Equal(s.created), "%s != %s", created, s.created)
+	require.False(t, s.full(0, time.Hour))
+}
+
+// TestOpenSegment_created_version_1 tests that segments of version 1, which carry no creation time, are assumed to
+// have been created when they were last modified.
+func TestOpenSegment_created_version_1(t *testing.T) {
+	dir := t.TempDir()
+	path := segmentPath(dir, 0, segmentExt)
+	require.NoError(t, os.WriteFile(path, segmentHeaderV1, 0o644))
+	modified := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(path, modified, modified))
+
+	s, err := openSegment(dir, 0, false)
+	require.NoError(t, err)
+	defer func() {
+		_ = s.close()
+	}()
+	require.True(t, s.checksummed)
+	require.Equal(t, int64(len(segmentHeaderV1)), s.start)
+	require.WithinDuration(t, modified, s.created, time.Second)
+}
+
+// TestSegment_sparse_index tests that the sparse index only holds an entry for every segmentIndexInterval bytes, and
+// that entries between the indexed records can still be looked up.
+func TestSegment_sparse_index(t *testing.T) {
+	dir := t.TempDir()
+	s, err := createSegment(dir, 0)
+	require.NoError(t, err)
+	payload := string(make([]byte, segmentIndexInterval/4))
+	var ids []EntryID
+	for i := 0; i < 20; i++ {
+		id := NewEntryID(fakeTestEntryID1.time, uint64(i))
+		ids = append(ids, id)
+		rec := walRecord{Op: walOpWrite, ID: id, Payload: payload}
+		b, err := encodeWALRecord(rec)
+		require.NoError(t, err)
+		require.NoError(t, s.append(b, rec))
+	}
+	require.Greater(t, len(s.index), 1)
+	require.Less(t, len(s.index), len(ids))
+	require.Equal(t, ids[0], s.index[0].ID)
+
+	require.NoError(t, s.seal())
+	require.True(t, s.sealed)
+	require.FileExists(t, s.indexPath())
+	b, err := encodeWALRecord(walRecord{Op: walOpWrite})
+	require.NoError(t, err)
+	require.Error(t, s.append(b, walRecord{Op: walOpWrite}))
+	index := s.index
+	require.NoError(t, s.close())
+
+	s, err = openSegment(dir, 0, true)
+	require.NoError(t, err)
+	defer func() {
+		_ = s.close()
+	}()
+	require.Equal(t, index, s.index)
+	for _, id := range ids {
+		rec, err := s.lookup(id)
+		require.NoError(t, err)
+		require.Equal(t, id, rec.ID)
+	}
+	_, err = s.lookup(NewEntryID(fakeTestEntryID1.time, 100))
+	require.ErrorIs(t, err, ErrNoSuchEntry)
+	_, err = s.lookup(ZeroEntryID)
+	require.ErrorIs(t, err, ErrNoSuchEntry)
+}
+
+// TestSegment_replay_rebuilds_missing_index tests that the sparse index of a sealed segment is rebuilt if it is
+// missing, e.g. because the process crashed while the segment was being sealed.
+func TestSegment_replay_rebuilds_missing_index(t *testing.T) {
+	dir := t.TempDir()
+	s, err := createSegment(dir, 0)
+	require.NoError(t, err)
+	rec := walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}
+	b, err := encodeWALRecord(rec)
+	require.NoError(t, err)
+	require.NoError(t, s.append(b, rec))
+	require.NoError(t, s.close())
+
+	s, err = openSegment(dir, 0, true)
+	require.NoError(t, err)
+	defer func() {
+		_ = s.close()
+	}()
+	require.Empty(t, s.index)
+	require.NoError(t, s.replay(func(rec walRecord, offset int64) error {
+		return nil
+	}))
+	offset := int64(len(segmentHeader) + segmentCreatedSize)
+	require.Equal(t, []segmentIndexEntry{{ID: fakeTestEntryID1, Offset: offset}}, s.index)
+	require.FileExists(t, s.indexPath())
+}
+
+// TestOpenSegment_rebuilds_corrupted_index tests that an index failing verification is discarded and rebuilt when the
+// segment is replayed.
+func TestOpenSegment_rebuilds_corrupted_index(t *testing.T) {
+	dir := t.TempDir()
+	s, err := createSegment(dir, 0)
+	require.NoError(t, err)
+	rec := walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}
+	b, err := encodeWALRecord(rec)
+	require.NoError(t, err)
+	require.NoError(t, s.append(b, rec))
+	require.NoError(t, s.seal())
+	index := s.index
+	require.NoError(t, s.close())
+
+	b, err = os.ReadFile(s.indexPath())
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	require.NoError(t, os.Remove(s.indexPath()))
+	require.NoError(t, os.WriteFile(s.indexPath(), b, 0o444))
+
+	s, err = openSegment(dir, 0, true)
+	require.NoError(t, err)
+	defer func() {
+		_ = s.close()
+	}()
+	require.Empty(t, s.index)
+	require.NoError(t, s.replay(func(rec walRecord, offset int64) error {
+		return nil
+	}))
+	require.Equal(t, index, s.index)
+	require.NoError(t, s.readIndex())
+}
+
+// TestSegment_mmap tests that records are read from the mapping of a sealed segment, which is released when the
+// segment is closed.
+func TestSegment_mmap(t *testing.T) {
+	s, err := createSegment(t.TempDir(), 0)
+	require.NoError(t, err)
+	require.NoError(t, s.mmap())
+	require.Nil(t, s.data)
+	rec := walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}
+	b, err := encodeWALRecord(rec)
+	require.NoError(t, err)
+	require.NoError(t, s.append(b, rec))
+	require.NoError(t, s.seal())
+
+	require.NoError(t, s.mmap())
+	if runtime.GOOS != "windows" && runtime.GOOS != "js" && runtime.GOOS != "wasip1" {
+		require.Len(t, s.data, int(s.size))
+	}
+	got, err := s.lookup(fakeTestEntryID1)
+	require.NoError(t, err)
+	require.Equal(t, rec, got)
+	_, _, err = s.recordAt(s.size)
+	require.ErrorIs(t, err, io.EOF)
+
+	require.NoError(t, s.close())
+	require.Nil(t, s.data)
+}
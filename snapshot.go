@@ -0,0 +1,546 @@
+package historitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+var ErrUnsupportedSnapshotVersion = fmt.Errorf("unsupported snapshot version")
+
+// Ensure Log implements io.WriterTo and io.ReaderFrom at compile time
+var (
+	_ io.WriterTo   = (*Log)(nil)
+	_ io.ReaderFrom = (*Log)(nil)
+)
+
+// snapshotChunkSize is the number of log entries [Log.WriteTo] encodes each time it acquires the lock of the log.
+const snapshotChunkSize = 1024
+
+// snapshotMagic identifies a versioned snapshot. A gob stream starts with the byte count of its first message, which
+// is either below 0x80 or a negated byte count of at most 8 bytes (0xf8 and up), so the first byte of snapshotMagic
+// cannot be mistaken for the start of an unversioned snapshot.
+var snapshotMagic = []byte{0x89, 'H', 'S', 'T'}
+
+// snapshotMigration upgrades a snapshot from one format version to the next.
+type snapshotMigration struct {
+	// Log upgrades the settings and Consumer groups of the log. It may be nil.
+	Log func(el *externalLog) error
+	// Entry upgrades a single log entry. It may be nil.
+	Entry func(e *Entry) error
+}
+
+// snapshotMigrations holds the migrations between the versions of the snapshot format. The migration at index i
+// upgrades a snapshot from version i+1 to version i+2, which makes the current version one larger than the number of
+// migrations. Changing the snapshot format is done by appending a migration.
+var snapshotMigrations = []snapshotMigration{
+	// 1 -> 2: the magic and format version were prepended to the gob stream, the stream itself is unchanged.
+	{},
+	// 2 -> 3: the gob stream was split into checksummed frames, the values encoded are unchanged.
+	{},
+	// 3 -> 4: log entries carry a key. Log entries in older snapshots have no key.
+	{},
+	// 4 -> 5: the log carries the postings of its secondary indexes. Older snapshots carry no indexes.
+	{},
+	// 5 -> 6: the log carries the deduplication state of its idempotent producers. Older snapshots carry none.
+	{},
+	// 6 -> 7: log entries carry a revision. Log entries in older snapshots have revision zero.
+	{},
+}
+
+// snapshotFramedVersion is the first version of the snapshot format in which the gob stream is split into frames, see
+// snapshotEncoder.
+const snapshotFramedVersion = 3
+
+// snapshotVersion returns the version of the snapshot format written by [Log.WriteTo].
+func snapshotVersion() uint64 {
+	return uint64(len(snapshotMigrations)) + 1
+}
+
+// SnapshotVersionError is returned when reading a snapshot written in a newer version of the snapshot format than
+// the ones supported by this version of the package. It wraps [ErrUnsupportedSnapshotVersion].
+type SnapshotVersionError struct {
+	// Version is the version of the snapshot format the snapshot was written in.
+	Version uint64
+}
+
+func (e *SnapshotVersionError) Error() string {
+	return fmt.Sprintf("%s %d: newest supported version is %d", ErrUnsupportedSnapshotVersion, e.Version, snapshotVersion())
+}
+
+func (e *SnapshotVersionError) Unwrap() error {
+	return ErrUnsupportedSnapshotVersion
+}
+
+// readSnapshotVersion reads the magic and format version from the start of a snapshot. Snapshots written before the
+// format was versioned start directly with the gob stream, and are reported as version 1.
+func readSnapshotVersion(r *bufio.Reader) (uint64, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	if b[0] != snapshotMagic[0] {
+		return 1, nil
+	}
+	magic := make([]byte, len(snapshotMagic))
+	_, err = io.ReadFull(r, magic)
+	if err != nil || !bytes.Equal(magic, snapshotMagic) {
+		return 0, fmt.Errorf("invalid snapshot header")
+	}
+	version, _, err := readUnsignedInt(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read snapshot version: %w", err)
+	}
+	if version == 0 || version > snapshotVersion() {
+		return 0, &SnapshotVersionError{Version: version}
+	}
+	return version, nil
+}
+
+// snapshotEncoder encodes the gob stream of a snapshot as a sequence of frames. A frame holds the length of its data,
+// the data itself and the checksum of the data. The data of a frame is the part of the gob stream encoding a single
+// value; the settings and Consumer groups of the log for the first frame, and a single [Entry] for every other frame.
+// The data of frames holding an [Entry] is prefixed with the ID of the log entry, encoded as the milliseconds and
+// sequence number of the ID, allowing the ID of a corrupted log entry to be reported.
+//
+// The last frame is followed by a zero length, as no frame is empty, and a digest of the entire snapshot preceding
+// the digest.
+type snapshotEncoder struct {
+	out    *bytes.Buffer
+	digest hash.Hash32
+	data   bytes.Buffer
+	enc    *gob.Encoder
+}
+
+func newSnapshotEncoder(out *bytes.Buffer) *snapshotEncoder {
+	e := &snapshotEncoder{
+		out:    out,
+		digest: crc32.New(castagnoli),
+	}
+	e.enc = gob.NewEncoder(&e.data)
+	return e
+}
+
+// write writes b to the output of the encoder, adding it to the digest.
+func (e *snapshotEncoder) write(b []byte) {
+	e.out.Write(b)
+	e.digest.Write(b)
+}
+
+// encode writes a frame holding v. If id is not the zero EntryID, the data of the frame is prefixed with it.
+func (e *snapshotEncoder) encode(id EntryID, v any) error {
+	e.data.Reset()
+	if !id.IsZero() {
+		e.data.Write(encodeUnsignedInt(uint64(id.time.UnixMilli())))
+		e.data.Write(encodeUnsignedInt(id.seq))
+	}
+	err := e.enc.Encode(v)
+	if err != nil {
+		return err
+	}
+	e.write(encodeUnsignedInt(uint64(e.data.Len())))
+	e.write(e.data.Bytes())
+	e.write(appendChecksum(nil, e.data.Bytes()))
+	return nil
+}
+
+// close writes the end of the snapshot.
+func (e *snapshotEncoder) close() {
+	e.write(encodeUnsignedInt(0))
+	e.out.Write(e.digest.Sum(nil))
+}
+
+// snapshotReader decodes the contents of a snapshot following its magic and format version.
+type snapshotReader interface {
+	// header decodes the settings and Consumer groups of the log.
+	header(el *externalLog) error
+	// entry decodes the next log entry. If there are no more log entries, io.EOF is returned.
+	entry(e *Entry) error
+}
+
+// snapshotDecoder decodes the frames written by snapshotEncoder, verifying them as they are read. Frames that fail
+// verification, as well as the snapshot ending before the digest was read, are reported as a [*CorruptionError].
+type snapshotDecoder struct {
+	r      *bufio.Reader
+	digest hash.Hash32
+	data   bytes.Buffer
+	dec    *gob.Decoder
+	// offset is the offset in the snapshot of the next frame.
+	offset int64
+	// last is the ID of the last log entry decoded.
+	last EntryID
+}
+
+func newSnapshotDecoder(r *bufio.Reader, version uint64) *snapshotDecoder {
+	d := &snapshotDecoder{
+		r:      r,
+		digest: crc32.New(castagnoli),
+	}
+	d.dec = gob.NewDecoder(&d.data)
+	// the header has already been read, and is added to the digest as it was written
+	header := append(bytes.Clone(snapshotMagic), encodeUnsignedInt(version)...)
+	d.digest.Write(header)
+	d.offset = int64(len(header))
+	return d
+}
+
+// corrupted returns a [*CorruptionError] for the frame at the current offset.
+func (d *snapshotDecoder) corrupted(id EntryID, reason string) error {
+	return &CorruptionError{
+		ID:        id,
+		LastValid: d.last,
+		Source:    fmt.Sprintf("snapshot at offset %d: %s", d.offset, reason),
+	}
+}
+
+// frame reads the next frame and returns its data. If the end of the snapshot is reached, io.EOF is returned. If
+// entry is set, the frame is expected to hold a log entry, and the ID it is prefixed with is returned.
+func (d *snapshotDecoder) frame(entry bool) ([]byte, EntryID, error) {
+	size, n, err := readUnsignedInt(d.r)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, ZeroEntryID, d.corrupted(ZeroEntryID, "snapshot ends before its digest")
+	}
+	if err != nil {
+		return nil, ZeroEntryID, d.corrupted(ZeroEntryID, "invalid frame length")
+	}
+	d.digest.Write(encodeUnsignedInt(size))
+	if size == 0 {
+		sum := make([]byte, d.digest.Size())
+		_, err = io.ReadFull(d.r, sum)
+		if err != nil {
+			return nil, ZeroEntryID, d.corrupted(ZeroEntryID, "snapshot ends before its digest")
+		}
+		if !bytes.Equal(sum, d.digest.Sum(nil)) {
+			return nil, ZeroEntryID, d.corrupted(ZeroEntryID, "digest mismatch")
+		}
+		return nil, ZeroEntryID, io.EOF
+	}
+	var buf bytes.Buffer
+	_, err = io.CopyN(&buf, d.r, int64(size)+checksumSize)
+	if err != nil {
+		return nil, ZeroEntryID, d.corrupted(ZeroEntryID, "snapshot ends before its digest")
+	}
+	d.digest.Write(buf.Bytes())
+	data := buf.Bytes()[:size]
+	id := ZeroEntryID
+	if entry {
+		// the ID is parsed before the frame is verified, so it can be reported if the frame is corrupted
+		br := bytes.NewReader(data)
+		ms, _, msErr := readUnsignedInt(br)
+		seq, _, seqErr := readUnsignedInt(br)
+		if msErr == nil && seqErr == nil {
+			id = NewEntryID(time.UnixMilli(int64(ms)), seq)
+			data = data[len(data)-br.Len():]
+		}
+	}
+	if !verifyChecksum(buf.Bytes()[:size], buf.Bytes()[size:]) {
+		return nil, id, d.corrupted(id, "checksum mismatch")
+	}
+	d.offset += int64(n) + int64(buf.Len())
+	return data, id, nil
+}
+
+// header decodes the settings and Consumer groups of the log.
+func (d *snapshotDecoder) header(el *externalLog) error {
+	data, _, err := d.frame(false)
+	if errors.Is(err, io.EOF) {
+		return d.corrupted(ZeroEntryID, "snapshot holds no log")
+	}
+	if err != nil {
+		return err
+	}
+	d.data.Write(data)
+	return d.dec.Decode(el)
+}
+
+// entry decodes the next log entry. If there are no more log entries, io.EOF is returned.
+func (d *snapshotDecoder) entry(e *Entry) error {
+	data, id, err := d.frame(true)
+	if err != nil {
+		return err
+	}
+	d.data.Write(data)
+	err = d.dec.Decode(e)
+	if err != nil {
+		return fmt.Errorf("failed to decode log entry %s: %w", id, err)
+	}
+	d.last = e.ID
+	return nil
+}
+
+// gobSnapshotDecoder decodes snapshots written before the gob stream was split into frames.
+type gobSnapshotDecoder struct {
+	dec *gob.Decoder
+}
+
+func (d *gobSnapshotDecoder) header(el *externalLog) error {
+	return d.dec.Decode(el)
+}
+
+func (d *gobSnapshotDecoder) entry(e *Entry) error {
+	err := d.dec.Decode(e)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to decode log entry: %w", err)
+	}
+	return err
+}
+
+// WriteTo writes a gob-encoded snapshot of the log to w. It returns the number of bytes written.
+//
+// The snapshot starts with a magic and the version of the snapshot format, followed by a gob stream holding the
+// settings, Consumer groups, secondary indexes and deduplication state of the log, followed by one [Entry] per log
+// entry. Every log entry is accompanied by a checksum, and the snapshot ends with a digest of the entire snapshot,
+// allowing corruption to be detected when the snapshot is read.
+//
+// Log entries are encoded in chunks, and the log is only locked while a chunk is being encoded, not while it is
+// written to w. This allows the log to be used while the snapshot is being written. Log entries written after WriteTo
+// was called are not included in the snapshot, whereas updates to existing log entries may or may not be included.
+//
+// As with any gob stream, the concrete types of log entry payloads must be registered with [encoding/gob.Register]
+// unless they are predeclared types.
+func (l *Log) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	var written int64
+	enc := newSnapshotEncoder(&buf)
+	flush := func() error {
+		n, err := buf.WriteTo(w)
+		written += n
+		return err
+	}
+
+	enc.write(snapshotMagic)
+	enc.write(encodeUnsignedInt(snapshotVersion()))
+
+	l.treeMux.RLock()
+	last := l.lastEntry
+	err := enc.encode(ZeroEntryID, externalLog{
+		Name:                   l.name,
+		Groups:                 l.groups,
+		FirstEntry:             l.firstEntry,
+		LastEntry:              l.lastEntry,
+		MaxPendingAge:          l.maxPendingAge,
+		MaxDeliveryCount:       l.maxDeliveryCount,
+		AttemptRedeliveryAfter: l.attemptRedeliveryAfter,
+		Indexes:                l.externalIndexes(),
+		Producers:              l.externalProducers(),
+	})
+	l.treeMux.RUnlock()
+	if err != nil {
+		return written, fmt.Errorf("failed to encode log: %w", err)
+	}
+	err = flush()
+	if err != nil {
+		return written, err
+	}
+
+	cursor := ZeroEntryID
+	for {
+		n := 0
+		var encErr, resolveErr error
+		l.treeMux.RLock()
+		err = l.entries.Ascend(cursor, func(e Entry) bool {
+			if n == 0 && e.ID == cursor && !cursor.IsZero() {
+				// the cursor was encoded as part of the previous chunk
+				return true
+			}
+			if e.ID.Compare(last) > 0 {
+				return false
+			}
+			e, resolveErr = l.resolve(e)
+			if resolveErr != nil {
+				return false
+			}
+			encErr = enc.encode(e.ID, e)
+			if encErr != nil {
+				return false
+			}
+			cursor = e.ID
+			n++
+			return n < snapshotChunkSize
+		})
+		l.treeMux.RUnlock()
+		if err != nil {
+			return written, err
+		}
+		if resolveErr != nil {
+			return written, resolveErr
+		}
+		if encErr != nil {
+			return written, fmt.Errorf("failed to encode log entry %s: %w", cursor, encErr)
+		}
+		err = flush()
+		if err != nil {
+			return written, err
+		}
+		if n < snapshotChunkSize {
+			enc.close()
+			return written, flush()
+		}
+	}
+}
+
+// ReadFrom replaces the contents of the log with a snapshot read from r, until r is exhausted. It returns the number
+// of bytes read. The snapshot must have been written by [Log.WriteTo] or [Log.MarshalBinary].
+//
+// Snapshots written by older versions of this package are upgraded to the current snapshot format as they are read.
+// Snapshots written in a newer version of the snapshot format are rejected with a [*SnapshotVersionError].
+//
+// The snapshot is verified against its checksums as it is read, and corruption is reported as a [*CorruptionError].
+// If the log was created with [WithLogTruncateCorrupted], the log entries preceding the first corrupted log entry are
+// kept instead, and no error is returned. Corruption of the settings and Consumer groups of the log cannot be
+// recovered from.
+//
+// Log entries are decoded and inserted into the [Storage] of the log one at a time, so the snapshot is never held in
+// memory in its entirety. The log is locked until ReadFrom returns. If an error is returned, the log may hold part of
+// the snapshot.
+//
+// Secondary indexes of the log with an extractor, see [WithLogIndex] and [Log.CreateIndex], are rebuilt from the log
+// entries in the snapshot. Other indexes in the snapshot are restored as they were when the snapshot was written, and
+// can be used with [Log.LookupIndex] and [Log.Search]. Log entries written or updated are not added to a restored
+// index until an extractor is attached to it with [Log.CreateIndex].
+//
+// If the write-ahead log is enabled, the snapshot replaces the contents of the write-ahead log. The Consumer groups
+// replaced by those in the snapshot no longer journal their changes, as is the case for removed Consumer groups.
+func (l *Log) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	version, err := readSnapshotVersion(br)
+	if err != nil {
+		return cr.n, err
+	}
+	migrations := snapshotMigrations[version-1:]
+	var dec snapshotReader
+	if version >= snapshotFramedVersion {
+		dec = newSnapshotDecoder(br, version)
+	} else {
+		dec = &gobSnapshotDecoder{dec: gob.NewDecoder(br)}
+	}
+
+	l.treeMux.Lock()
+	defer l.treeMux.Unlock()
+	defer l.written.notify()
+
+	var el externalLog
+	err = dec.header(&el)
+	if err != nil {
+		return cr.n, err
+	}
+	for _, m := range migrations {
+		if m.Log == nil {
+			continue
+		}
+		err = m.Log(&el)
+		if err != nil {
+			return cr.n, fmt.Errorf("failed to upgrade snapshot: %w", err)
+		}
+	}
+	insert := func(e Entry) error {
+		for _, m := range migrations {
+			if m.Entry == nil {
+				continue
+			}
+			err := m.Entry(&e)
+			if err != nil {
+				return fmt.Errorf("failed to upgrade log entry %s: %w", e.ID, err)
+			}
+		}
+		// the payload is kept in the storage until the log entry is journaled, see journalAll
+		err := l.entries.Insert(e)
+		if err != nil {
+			return err
+		}
+		l.indexEntry(e)
+		l.trackKey(e)
+		return nil
+	}
+	l.name = el.Name
+	// the replaced Consumer groups must no longer journal their changes, as their records would refer to Consumer
+	// groups that are not in the write-ahead log anymore
+	for _, g := range l.groups {
+		g.mut.Lock()
+		g.wal = nil
+		g.mut.Unlock()
+	}
+	l.groups = el.Groups
+	if l.groups == nil {
+		l.groups = make(map[string]*ConsumerGroup)
+	}
+	l.firstEntry = el.FirstEntry
+	l.lastEntry = el.LastEntry
+	l.maxPendingAge = el.MaxPendingAge
+	l.maxDeliveryCount = el.MaxDeliveryCount
+	l.attemptRedeliveryAfter = el.AttemptRedeliveryAfter
+	if l.entries == nil {
+		l.entries = NewMemoryStorage()
+	}
+	err = l.clearEntries()
+	if err != nil {
+		return cr.n, err
+	}
+	l.restoreIndexes(el.Indexes)
+	l.restoreProducers(el.Producers)
+	for _, e := range el.Entries {
+		err = insert(e)
+		if err != nil {
+			return cr.n, err
+		}
+	}
+	var last EntryID
+	for {
+		var e Entry
+		err = dec.entry(&e)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, ErrCorrupted) && l.truncateCorrupted {
+			if last.Compare(l.lastEntry) < 0 {
+				l.lastEntry = last
+			}
+			break
+		}
+		if err != nil {
+			return cr.n, err
+		}
+		last = e.ID
+		err = insert(e)
+		if err != nil {
+			return cr.n, err
+		}
+	}
+	// restored indexes may hold log entries missing from the snapshot, e.g. if they were removed while the snapshot
+	// was being written, or were discarded as corrupted
+	var searchErr error
+	for _, x := range l.indexes {
+		if x.extract != nil {
+			continue
+		}
+		x.retain(func(id EntryID) bool {
+			_, exists, err := l.entries.Search(id)
+			searchErr = errors.Join(searchErr, err)
+			return exists
+		})
+	}
+	if searchErr != nil {
+		return cr.n, searchErr
+	}
+
+	return cr.n, l.journalAll()
+}
+
+// countingReader is an io.Reader that counts the number of bytes read from the underlying io.Reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
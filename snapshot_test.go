@@ -0,0 +1,287 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+)
+
+func TestLog_WriteTo_ReadFrom(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+	// write enough log entries to span multiple chunks
+	for i := 0; i < snapshotChunkSize*2+10; i++ {
+		_, err = l.Write(i)
+		require.NoError(t, err)
+	}
+	_, err = l.Read("group1", "consumer1", 5)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := l.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), n)
+
+	var l2 Log
+	m, err := l2.ReadFrom(&buf)
+	require.NoError(t, err)
+	require.Equal(t, n, m)
+	require.Equal(t, t.Name(), l2.name)
+	require.Equal(t, l.lastEntry, l2.lastEntry)
+	require.Equal(t, l.Size(), l2.Size())
+	pel := l2.groups["group1"].ListPendingEntries()
+	require.Len(t, pel, 5)
+	for id, pe := range l.groups["group1"].ListPendingEntries() {
+		require.True(t, pe.DeliveredAt.Equal(pel[id].DeliveredAt))
+	}
+	i := 0
+	require.NoError(t, l2.entries.Ascend(ZeroEntryID, func(e Entry) bool {
+		require.Equal(t, i, e.Payload)
+		i++
+		return true
+	}))
+}
+
+// lateWriter is an io.Writer that writes a log entry to a log the first time it is written to.
+type lateWriter struct {
+	bytes.Buffer
+	l       *Log
+	written bool
+}
+
+func (w *lateWriter) Write(p []byte) (int, error) {
+	if !w.written {
+		w.written = true
+		_, err := w.l.Write("late")
+		if err != nil {
+			return 0, err
+		}
+	}
+	return w.Buffer.Write(p)
+}
+
+// TestLog_WriteTo_excludes_later_entries tests that log entries written while the snapshot is being written are not
+// included in the snapshot, and that the log is not locked while writing to the io.Writer.
+func TestLog_WriteTo_excludes_later_entries(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	_, err = l.Write("early")
+	require.NoError(t, err)
+
+	w := &lateWriter{l: l}
+	_, err = l.WriteTo(w)
+	require.NoError(t, err)
+	require.Equal(t, 2, l.Size())
+
+	var l2 Log
+	_, err = l2.ReadFrom(&w.Buffer)
+	require.NoError(t, err)
+	require.Equal(t, 1, l2.Size())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("failed")
+}
+
+func TestLog_WriteTo_error(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	_, err = l.WriteTo(failingWriter{})
+	require.Error(t, err)
+}
+
+// TestLog_ReadFrom_legacy tests that snapshots encoded before the introduction of Log.WriteTo, which hold all log
+// entries in the externalLog, can still be read.
+func TestLog_ReadFrom_legacy(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(externalLog{
+		Name:      "legacy",
+		Groups:    map[string]*ConsumerGroup{"group1": NewConsumerGroup(WithConsumerGroupName("group1"))},
+		Entries:   []Entry{{ID: fakeTestEntryID1, Payload: "one"}, {ID: fakeTestEntryID2, Payload: "two"}},
+		LastEntry: fakeTestEntryID2,
+	}))
+
+	var l Log
+	_, err := l.ReadFrom(&buf)
+	require.NoError(t, err)
+	require.Equal(t, "legacy", l.name)
+	require.Equal(t, 2, l.Size())
+	require.Equal(t, fakeTestEntryID2, l.lastEntry)
+	require.Contains(t, l.groups, "group1")
+}
+
+func TestLog_ReadFrom_truncated(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	_, err = l.Write("value")
+	require.NoError(t, err)
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+
+	var l2 Log
+	_, err = l2.ReadFrom(bytes.NewReader(b[:len(b)-2]))
+	require.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestLog_WriteTo_version(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(b, snapshotMagic))
+
+	version, err := readSnapshotVersion(bufio.NewReader(bytes.NewReader(b)))
+	require.NoError(t, err)
+	require.Equal(t, snapshotVersion(), version)
+}
+
+// TestLog_ReadFrom_unversioned tests that streamed snapshots written before the snapshot format was versioned can
+// still be read.
+func TestLog_ReadFrom_unversioned(t *testing.T) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	require.NoError(t, enc.Encode(externalLog{Name: "unversioned", LastEntry: fakeTestEntryID1}))
+	require.NoError(t, enc.Encode(Entry{ID: fakeTestEntryID1, Payload: "one"}))
+
+	var l Log
+	_, err := l.ReadFrom(&buf)
+	require.NoError(t, err)
+	require.Equal(t, "unversioned", l.name)
+	require.Equal(t, 1, l.Size())
+}
+
+func TestLog_ReadFrom_future_version(t *testing.T) {
+	b := append(bytes.Clone(snapshotMagic), encodeUnsignedInt(snapshotVersion()+1)...)
+
+	var l Log
+	_, err := l.ReadFrom(bytes.NewReader(b))
+	require.ErrorIs(t, err, ErrUnsupportedSnapshotVersion)
+	var verr *SnapshotVersionError
+	require.ErrorAs(t, err, &verr)
+	require.Equal(t, snapshotVersion()+1, verr.Version)
+}
+
+func TestLog_ReadFrom_invalid_header(t *testing.T) {
+	var l Log
+	_, err := l.ReadFrom(bytes.NewReader([]byte{snapshotMagic[0], 'X', 'X', 'X', 1}))
+	require.ErrorContains(t, err, "invalid snapshot header")
+}
+
+// TestLog_ReadFrom_migrations tests that snapshots written in an older version of the snapshot format are upgraded
+// by every migration between that version and the current one, in order.
+func TestLog_ReadFrom_migrations(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	_, err = l.Write("value")
+	require.NoError(t, err)
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+
+	orig := snapshotMigrations
+	defer func() {
+		snapshotMigrations = orig
+	}()
+	snapshotMigrations = append(slices.Clone(orig),
+		snapshotMigration{
+			Log: func(el *externalLog) error {
+				el.Name += "-upgraded"
+				return nil
+			},
+			Entry: func(e *Entry) error {
+				e.Payload = e.Payload.(string) + "-upgraded"
+				return nil
+			},
+		},
+		snapshotMigration{
+			Log: func(el *externalLog) error {
+				el.Name += "-twice"
+				return nil
+			},
+		},
+	)
+
+	var l2 Log
+	_, err = l2.ReadFrom(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, t.Name()+"-upgraded-twice", l2.name)
+	require.NoError(t, l2.entries.Ascend(ZeroEntryID, func(e Entry) bool {
+		require.Equal(t, "value-upgraded", e.Payload)
+		return true
+	}))
+
+	snapshotMigrations = append(snapshotMigrations, snapshotMigration{
+		Log: func(el *externalLog) error {
+			return errors.New("failed")
+		},
+	})
+	var l3 Log
+	_, err = l3.ReadFrom(bytes.NewReader(b))
+	require.ErrorContains(t, err, "failed to upgrade snapshot")
+}
+
+// corruptSnapshot returns a snapshot of a log holding three log entries, with a bit flipped in the payload of the
+// second.
+func corruptSnapshot(t *testing.T) ([]byte, []EntryID) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	var ids []EntryID
+	for _, payload := range []string{"first", "second", "third"} {
+		id, err := l.Write(payload)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+	i := bytes.Index(b, []byte("second"))
+	require.Positive(t, i)
+	b[i] ^= 0x01
+	return b, ids
+}
+
+func TestLog_ReadFrom_corrupted(t *testing.T) {
+	b, ids := corruptSnapshot(t)
+
+	var l Log
+	_, err := l.ReadFrom(bytes.NewReader(b))
+	require.ErrorIs(t, err, ErrCorrupted)
+	var cerr *CorruptionError
+	require.ErrorAs(t, err, &cerr)
+	require.Equal(t, ids[1], cerr.ID)
+	require.Equal(t, ids[0], cerr.LastValid)
+}
+
+func TestLog_ReadFrom_truncates_corrupted(t *testing.T) {
+	b, ids := corruptSnapshot(t)
+
+	l, err := NewLog(WithLogTruncateCorrupted(true))
+	require.NoError(t, err)
+	_, err = l.ReadFrom(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, 1, l.Size())
+	require.Equal(t, ids[0], l.lastEntry)
+}
+
+func TestLog_ReadFrom_digest_mismatch(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	_, err = l.Write("value")
+	require.NoError(t, err)
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+	b[len(b)-1] ^= 0xff
+
+	var l2 Log
+	_, err = l2.ReadFrom(bytes.NewReader(b))
+	require.ErrorIs(t, err, ErrCorrupted)
+	require.ErrorContains(t, err, "digest mismatch")
+}
@@ -0,0 +1,79 @@
+package historitor
+
+// Ensure MemoryStorage implements Storage at compile time
+var _ Storage = (*MemoryStorage)(nil)
+
+// Storage stores the entries of a [Log], ordered by their [EntryID]. A [Log] uses a [MemoryStorage] unless another
+// Storage is provided with [WithLogStorage].
+//
+// The [Log] never calls Insert or Delete concurrently with any other method, whereas Search, Ascend, Descend and Size
+// may be called concurrently with each other.
+type Storage interface {
+	// Insert stores entry, replacing any entry with the same ID.
+	Insert(entry Entry) error
+	// Search returns the entry with the given ID. If no such entry exists, it returns false.
+	Search(id EntryID) (Entry, bool, error)
+	// Delete removes the entry with the given ID. Deleting an entry that does not exist is not an error.
+	Delete(id EntryID) error
+	// Ascend calls fn for every entry with an ID equal to or after from, in ascending order, until fn returns false.
+	Ascend(from EntryID, fn func(entry Entry) bool) error
+	// Descend calls fn for every entry with an ID equal to or before from, in descending order, until fn returns
+	// false. If from is the zero EntryID, Descend starts at the last entry.
+	Descend(from EntryID, fn func(entry Entry) bool) error
+	// Size returns the number of entries stored.
+	Size() int
+}
+
+// MemoryStorage is a [Storage] that keeps entries in memory, in a B-tree ordered by [EntryID]. Ascend and Descend
+// find their starting point without visiting the entries before it.
+type MemoryStorage struct {
+	tree btree
+}
+
+// NewMemoryStorage creates a new, empty, MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+// Insert stores entry, replacing any entry with the same ID.
+func (m *MemoryStorage) Insert(entry Entry) error {
+	m.tree.set(entry)
+	return nil
+}
+
+// Search returns the entry with the given ID. If no such entry exists, it returns false.
+func (m *MemoryStorage) Search(id EntryID) (Entry, bool, error) {
+	e, ok := m.tree.get(id)
+	return e, ok, nil
+}
+
+// Delete removes the entry with the given ID.
+func (m *MemoryStorage) Delete(id EntryID) error {
+	m.tree.delete(id)
+	return nil
+}
+
+// Ascend calls fn for every entry with an ID equal to or after from, in ascending order, until fn returns false.
+func (m *MemoryStorage) Ascend(from EntryID, fn func(entry Entry) bool) error {
+	m.tree.ascend(from, fn)
+	return nil
+}
+
+// Descend calls fn for every entry with an ID equal to or before from, in descending order, until fn returns false.
+// If from is the zero EntryID, Descend starts at the last entry.
+func (m *MemoryStorage) Descend(from EntryID, fn func(entry Entry) bool) error {
+	if from.IsZero() {
+		last, ok := m.tree.last()
+		if !ok {
+			return nil
+		}
+		from = last.ID
+	}
+	m.tree.descend(from, fn)
+	return nil
+}
+
+// Size returns the number of entries stored.
+func (m *MemoryStorage) Size() int {
+	return m.tree.size
+}
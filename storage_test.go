@@ -0,0 +1,78 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newTestMemoryStorage(t *testing.T) *MemoryStorage {
+	t.Helper()
+	m := NewMemoryStorage()
+	require.NoError(t, m.Insert(Entry{ID: fakeTestEntryID3, Payload: "three"}))
+	require.NoError(t, m.Insert(Entry{ID: fakeTestEntryID1, Payload: "one"}))
+	require.NoError(t, m.Insert(Entry{ID: fakeTestEntryID2, Payload: "two"}))
+	return m
+}
+
+func collectIDs(t *testing.T, f func(from EntryID, fn func(entry Entry) bool) error, from EntryID, limit int) []EntryID {
+	t.Helper()
+	var out []EntryID
+	err := f(from, func(e Entry) bool {
+		out = append(out, e.ID)
+		return limit <= 0 || len(out) < limit
+	})
+	require.NoError(t, err)
+	return out
+}
+
+func TestMemoryStorage_Insert_Search(t *testing.T) {
+	m := newTestMemoryStorage(t)
+	require.Equal(t, 3, m.Size())
+
+	e, ok, err := m.Search(fakeTestEntryID2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, Entry{ID: fakeTestEntryID2, Payload: "two"}, e)
+
+	require.NoError(t, m.Insert(Entry{ID: fakeTestEntryID2, Payload: "two updated"}))
+	require.Equal(t, 3, m.Size())
+	e, ok, err = m.Search(fakeTestEntryID2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "two updated", e.Payload)
+
+	_, ok, err = m.Search(ZeroEntryID)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestMemoryStorage_Delete(t *testing.T) {
+	m := newTestMemoryStorage(t)
+	require.NoError(t, m.Delete(fakeTestEntryID2))
+	require.NoError(t, m.Delete(fakeTestEntryID2))
+	require.Equal(t, 2, m.Size())
+	_, ok, err := m.Search(fakeTestEntryID2)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestMemoryStorage_Ascend(t *testing.T) {
+	m := newTestMemoryStorage(t)
+	require.Equal(t, []EntryID{fakeTestEntryID1, fakeTestEntryID2, fakeTestEntryID3}, collectIDs(t, m.Ascend, ZeroEntryID, 0))
+	require.Equal(t, []EntryID{fakeTestEntryID2, fakeTestEntryID3}, collectIDs(t, m.Ascend, fakeTestEntryID2, 0))
+	require.Equal(t, []EntryID{fakeTestEntryID2}, collectIDs(t, m.Ascend, fakeTestEntryID2, 1))
+	// seeking to an ID that does not exist starts at the next entry
+	between := NewEntryID(fakeTestEntryID2.time, 3)
+	require.Equal(t, []EntryID{fakeTestEntryID3}, collectIDs(t, m.Ascend, between, 0))
+}
+
+func TestMemoryStorage_Descend(t *testing.T) {
+	m := newTestMemoryStorage(t)
+	require.Equal(t, []EntryID{fakeTestEntryID3, fakeTestEntryID2, fakeTestEntryID1}, collectIDs(t, m.Descend, ZeroEntryID, 0))
+	require.Equal(t, []EntryID{fakeTestEntryID2, fakeTestEntryID1}, collectIDs(t, m.Descend, fakeTestEntryID2, 0))
+	require.Equal(t, []EntryID{fakeTestEntryID2}, collectIDs(t, m.Descend, fakeTestEntryID2, 1))
+	between := NewEntryID(fakeTestEntryID2.time, 3)
+	require.Equal(t, []EntryID{fakeTestEntryID2, fakeTestEntryID1}, collectIDs(t, m.Descend, between, 0))
+}
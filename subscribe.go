@@ -0,0 +1,169 @@
+package historitor
+
+import (
+	"sync"
+)
+
+// subscribeChunkSize is the number of log entries a subscription reads each time it acquires the lock of the log.
+const subscribeChunkSize = 256
+
+// Backpressure determines what happens when the subscriber of a subscription created with [Log.Subscribe] falls
+// behind, and the buffer of the subscription is full. It is set with [WithSubscribeBackpressure].
+//
+// Writers are never blocked by subscribers, regardless of the Backpressure, as subscriptions read the log entries from
+// the log.
+type Backpressure int
+
+const (
+	// BackpressureBlock waits for the subscriber to receive log entries, so no log entries are missed. Log entries
+	// are read from the log as the subscriber catches up. This is the default.
+	BackpressureBlock Backpressure = iota
+	// BackpressureDropOldest discards the oldest log entry in the buffer to make room for the next, so the subscriber
+	// receives the latest log entries, but may miss some.
+	BackpressureDropOldest
+	// BackpressureDisconnect ends the subscription, closing its channel, so the subscriber can resubscribe from the
+	// last log entry it received.
+	BackpressureDisconnect
+)
+
+func (b Backpressure) String() string {
+	switch b {
+	case BackpressureBlock:
+		return "block"
+	case BackpressureDropOldest:
+		return "drop-oldest"
+	case BackpressureDisconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// subscription streams the log entries of a log to a channel, see [Log.Subscribe].
+type subscription struct {
+	l    *Log
+	ch   chan Entry
+	opts subscribeOptions
+	// cursor is the ID of the last log entry sent.
+	cursor EntryID
+	// done is closed when the subscription is cancelled.
+	done       chan struct{}
+	cancelOnce sync.Once
+}
+
+// Subscribe streams the log entries written to the log after the log entry with the ID from to the returned channel,
+// in order. If from is [StartFromBeginning], every log entry in the log is streamed, and if from is [StartFromEnd],
+// only log entries written after Subscribe is called are. Once the subscriber has caught up, log entries are streamed
+// as they are written.
+//
+// Unlike reading with a Consumer group, subscribing involves no Pending Entries List (PEL), and log entries are not
+// acknowledged, making subscriptions suitable for readers that do not need to process every log entry exactly once,
+// such as live dashboards. The size of the buffer of the channel is set with [WithSubscribeBufferSize], and what
+// happens when it is full with [WithSubscribeBackpressure].
+//
+// The returned function cancels the subscription. The channel is closed once the subscription is cancelled, the
+// subscriber is disconnected by [BackpressureDisconnect], the log is closed, or a log entry cannot be read. Log entries
+// may still be received after cancelling the subscription, until the channel is closed.
+//
+// Subscribe is safe for concurrent use.
+func (l *Log) Subscribe(from EntryID, options ...SubscribeOption) (<-chan Entry, func()) {
+	opts := defaultSubscribeOptions
+	for _, opt := range options {
+		opt.apply(&opts)
+	}
+	s := &subscription{
+		l:    l,
+		ch:   make(chan Entry, max(opts.BufferSize, 1)),
+		opts: opts,
+		done: make(chan struct{}),
+	}
+
+	l.treeMux.RLock()
+	switch from {
+	case StartFromBeginning:
+		s.cursor = ZeroEntryID
+	case StartFromEnd:
+		s.cursor = l.lastEntry
+	default:
+		s.cursor = from
+	}
+	l.treeMux.RUnlock()
+
+	l.background.Add(1)
+	go func() {
+		defer l.background.Done()
+		defer close(s.ch)
+		s.run()
+	}()
+	return s.ch, func() {
+		s.cancelOnce.Do(func() {
+			close(s.done)
+		})
+	}
+}
+
+// run streams log entries until the subscription ends.
+func (s *subscription) run() {
+	for {
+		// the channel is obtained before reading, so log entries written after reading wake us up
+		written := s.l.written.wait()
+		entries, err := s.l.Range(s.cursor, StartFromEnd, subscribeChunkSize, WithRangeExclusiveStart(true))
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			if !s.send(e) {
+				return
+			}
+			s.cursor = e.ID
+		}
+		if len(entries) == subscribeChunkSize {
+			continue
+		}
+		select {
+		case <-written:
+		case <-s.done:
+			return
+		case <-s.l.stop:
+			return
+		}
+	}
+}
+
+// send sends e to the subscriber according to the Backpressure of the subscription. It reports whether the
+// subscription should continue.
+func (s *subscription) send(e Entry) bool {
+	switch s.opts.Backpressure {
+	case BackpressureDropOldest:
+		for {
+			select {
+			case s.ch <- e:
+				return true
+			case <-s.done:
+				return false
+			default:
+			}
+			// the subscriber may receive the oldest log entry first, in which case there is room for e
+			select {
+			case <-s.ch:
+			default:
+			}
+		}
+	case BackpressureDisconnect:
+		select {
+		case s.ch <- e:
+			return true
+		case <-s.done:
+			return false
+		default:
+			return false
+		}
+	}
+	select {
+	case s.ch <- e:
+		return true
+	case <-s.done:
+		return false
+	case <-s.l.stop:
+		return false
+	}
+}
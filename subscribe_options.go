@@ -0,0 +1,50 @@
+package historitor
+
+type subscribeOptions struct {
+	// BufferSize is the capacity of the channel log entries are delivered on.
+	BufferSize int
+	// Backpressure determines what happens when the channel is full.
+	Backpressure Backpressure
+}
+
+var defaultSubscribeOptions = subscribeOptions{
+	BufferSize:   64,
+	Backpressure: BackpressureBlock,
+}
+
+// SubscribeOption is an option for configuring a subscription created with [Log.Subscribe].
+type SubscribeOption interface {
+	apply(*subscribeOptions)
+}
+
+// funcSubscribeOption is a SubscribeOption that calls a function.
+// It is used to wrap a function, so it satisfies the SubscribeOption interface.
+type funcSubscribeOption struct {
+	f func(*subscribeOptions)
+}
+
+func (fdo *funcSubscribeOption) apply(opts *subscribeOptions) {
+	fdo.f(opts)
+}
+
+func newFuncSubscribeOption(f func(*subscribeOptions)) *funcSubscribeOption {
+	return &funcSubscribeOption{
+		f: f,
+	}
+}
+
+// WithSubscribeBufferSize sets the number of log entries buffered in the channel of the subscription. Sizes below one
+// are treated as one. The default is 64.
+func WithSubscribeBufferSize(size int) SubscribeOption {
+	return newFuncSubscribeOption(func(opts *subscribeOptions) {
+		opts.BufferSize = size
+	})
+}
+
+// WithSubscribeBackpressure sets the [Backpressure] of the subscription, determining what happens when the subscriber
+// falls behind and the buffer of the subscription is full. The default is [BackpressureBlock].
+func WithSubscribeBackpressure(backpressure Backpressure) SubscribeOption {
+	return newFuncSubscribeOption(func(opts *subscribeOptions) {
+		opts.Backpressure = backpressure
+	})
+}
@@ -0,0 +1,176 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// receive receives n log entries from ch, failing the test if they are not received in time.
+func receive(t *testing.T, ch <-chan Entry, n int) []any {
+	t.Helper()
+	out := make([]any, 0, n)
+	for len(out) < n {
+		select {
+		case e, ok := <-ch:
+			require.True(t, ok, "channel closed after %d log entries", len(out))
+			out = append(out, e.Payload)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for log entries", "received %d log entries", len(out))
+		}
+	}
+	return out
+}
+
+// requireClosed fails the test if ch is not closed in time, after receiving any log entries still buffered.
+func requireClosed(t *testing.T, ch <-chan Entry) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for the channel to be closed")
+		}
+	}
+}
+
+func TestLog_Subscribe(t *testing.T) {
+	l := newRangeTestLog(t)
+
+	ch, cancel := l.Subscribe(StartFromBeginning, WithSubscribeBufferSize(1))
+	defer cancel()
+	require.Equal(t, []any{"one", "two", "three"}, receive(t, ch, 3))
+
+	// log entries are streamed as they are written
+	_, err := l.Write("four")
+	require.NoError(t, err)
+	require.Equal(t, []any{"four"}, receive(t, ch, 1))
+
+	// subscribing from an ID streams the log entries after it
+	from, cancelFrom := l.Subscribe(fakeTestEntryID2)
+	defer cancelFrom()
+	require.Equal(t, []any{"three", "four"}, receive(t, from, 2))
+
+	// subscribing from the end only streams log entries written later
+	end, cancelEnd := l.Subscribe(StartFromEnd)
+	_, err = l.Write("five")
+	require.NoError(t, err)
+	require.Equal(t, []any{"five"}, receive(t, end, 1))
+	require.Equal(t, []any{"five"}, receive(t, ch, 1))
+
+	cancelEnd()
+	cancelEnd()
+	requireClosed(t, end)
+}
+
+func TestLog_Subscribe_chunks(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	for i := 0; i < subscribeChunkSize*2+1; i++ {
+		_, err = l.Write(i)
+		require.NoError(t, err)
+	}
+	ch, cancel := l.Subscribe(StartFromBeginning)
+	defer cancel()
+	got := receive(t, ch, subscribeChunkSize*2+1)
+	for i, payload := range got {
+		require.Equal(t, i, payload)
+	}
+}
+
+func TestLog_Subscribe_close(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	idle, cancelIdle := l.Subscribe(StartFromEnd)
+	defer cancelIdle()
+	for i := 0; i < 2; i++ {
+		_, err = l.Write(i)
+		require.NoError(t, err)
+	}
+	// the subscriber never receives, so the subscription is blocked sending the second log entry
+	blocked, cancelBlocked := l.Subscribe(StartFromBeginning, WithSubscribeBufferSize(1))
+	defer cancelBlocked()
+
+	require.NoError(t, l.Close())
+	requireClosed(t, idle)
+	requireClosed(t, blocked)
+}
+
+func TestLog_Subscribe_disconnect(t *testing.T) {
+	l := newRangeTestLog(t)
+	ch, cancel := l.Subscribe(StartFromBeginning, WithSubscribeBufferSize(1),
+		WithSubscribeBackpressure(BackpressureDisconnect))
+	defer cancel()
+
+	// the second log entry does not fit in the buffer, so the subscriber is disconnected
+	require.Equal(t, []any{"one"}, receive(t, ch, 1))
+	requireClosed(t, ch)
+}
+
+func TestSubscription_send(t *testing.T) {
+	newSubscription := func(backpressure Backpressure) *subscription {
+		return &subscription{
+			l:    &Log{},
+			ch:   make(chan Entry, 2),
+			opts: subscribeOptions{Backpressure: backpressure},
+			done: make(chan struct{}),
+		}
+	}
+	drain := func(s *subscription) []any {
+		out := make([]any, 0)
+		for len(s.ch) > 0 {
+			out = append(out, (<-s.ch).Payload)
+		}
+		return out
+	}
+
+	s := newSubscription(BackpressureDropOldest)
+	for i := 0; i < 5; i++ {
+		require.True(t, s.send(Entry{Payload: i}))
+	}
+	require.Equal(t, []any{3, 4}, drain(s))
+
+	s = newSubscription(BackpressureDisconnect)
+	require.True(t, s.send(Entry{Payload: 0}))
+	require.True(t, s.send(Entry{Payload: 1}))
+	require.False(t, s.send(Entry{Payload: 2}))
+	require.Equal(t, []any{0, 1}, drain(s))
+
+	s = newSubscription(BackpressureBlock)
+	require.True(t, s.send(Entry{Payload: 0}))
+	require.True(t, s.send(Entry{Payload: 1}))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-s.ch
+	}()
+	require.True(t, s.send(Entry{Payload: 2}))
+	close(s.done)
+	require.False(t, s.send(Entry{Payload: 3}))
+}
+
+func TestBackpressure_String(t *testing.T) {
+	require.Equal(t, "block", BackpressureBlock.String())
+	require.Equal(t, "drop-oldest", BackpressureDropOldest.String())
+	require.Equal(t, "disconnect", BackpressureDisconnect.String())
+	require.Equal(t, "unknown", Backpressure(-1).String())
+}
+
+func TestWithSubscribeBufferSize(t *testing.T) {
+	opts := subscribeOptions{}
+	so := WithSubscribeBufferSize(10)
+	so.apply(&opts)
+	require.Equal(t, 10, opts.BufferSize)
+}
+
+func TestWithSubscribeBackpressure(t *testing.T) {
+	opts := subscribeOptions{}
+	so := WithSubscribeBackpressure(BackpressureDropOldest)
+	so.apply(&opts)
+	require.Equal(t, BackpressureDropOldest, opts.Backpressure)
+}
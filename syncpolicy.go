@@ -0,0 +1,37 @@
+package historitor
+
+import (
+	"time"
+)
+
+// SyncPolicy determines when changes appended to the write-ahead log are flushed to stable storage, trading
+// durability for throughput. It is set with [WithLogSyncPolicy].
+//
+// Regardless of the SyncPolicy, the write-ahead log can be flushed at any time with [Log.Sync], and is flushed when the
+// log is closed with [Log.Close].
+type SyncPolicy struct {
+	always   bool
+	interval time.Duration
+}
+
+var (
+	// SyncNone leaves flushing the write-ahead log to the operating system. Changes survive the process crashing, but
+	// may be lost if the operating system crashes or the machine loses power. This is the default.
+	SyncNone = SyncPolicy{}
+	// SyncAlways flushes the write-ahead log after every change, before the method making the change returns. This
+	// is the most durable, and the slowest, policy.
+	SyncAlways = SyncPolicy{always: true}
+)
+
+// SyncInterval returns a SyncPolicy flushing the write-ahead log every interval, committing all changes made since
+// the previous flush as a group. [Log.Write] waits for the flush following the write before it returns, making the log
+// entry durable at the cost of latency, while allowing concurrent writes to share a single flush. Other changes, such
+// as acknowledging log entries, do not wait and are made durable by the next flush.
+//
+// An interval of zero or less is equivalent to [SyncAlways].
+func SyncInterval(interval time.Duration) SyncPolicy {
+	if interval <= 0 {
+		return SyncAlways
+	}
+	return SyncPolicy{interval: interval}
+}
@@ -0,0 +1,765 @@
+package historitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// walFileName is the name of the single journal file kept in the directory configured with [WithLogWAL] before the
+// journal was split into segments.
+const walFileName = "historitor.wal"
+
+// walOp identifies the kind of change described by a walRecord.
+type walOp uint8
+
+const (
+	walOpWrite walOp = iota + 1
+	walOpUpdateEntry
+	walOpAddGroup
+	walOpRemoveGroup
+	walOpSetStartAt
+	walOpAddMember
+	walOpRemoveMember
+	walOpAddPendingEntry
+	walOpRemovePendingEntry
+	walOpReset
+	walOpDelete
+	walOpSetProducer
+)
+
+// walRecord is a single change to a [Log] as it is stored in the write-ahead log. Only the fields relevant to Op are
+// set.
+type walRecord struct {
+	Op       walOp
+	ID       EntryID
+	Key      string
+	Payload  any
+	Group    string
+	Consumer string
+	// State holds the binary encoding of a ConsumerGroup for walOpAddGroup records, and the deduplication state of a
+	// producer for walOpSetProducer records.
+	State []byte
+	// Pending holds the pending entry, as it looks after the change, for walOpAddPendingEntry records.
+	Pending PendingEntry
+	// Revision is the revision of the log entry after the change, for walOpWrite and walOpUpdateEntry records.
+	Revision uint64
+	// Producer and ProducerSeq hold the producer ID and sequence number a walOpWrite record was written with by
+	// [Log.WriteIdempotent]. Producer also holds the producer ID of walOpSetProducer records, and is empty for other
+	// records.
+	Producer    string
+	ProducerSeq uint64
+	// Continued is set if the record is followed by more records of the same batch, see [Log.WriteBatch]. The records
+	// of a batch are only replayed once its last record, which does not have Continued set, has been read.
+	Continued bool
+}
+
+// errChecksumMismatch is returned when persisted data does not match its checksum.
+var errChecksumMismatch = fmt.Errorf("checksum mismatch")
+
+// encodeWALRecord encodes a walRecord as a length-prefixed gob message, followed by the checksum of the message. Every
+// record is encoded with its own encoder so that records can be decoded independently of each other, regardless of
+// how many times the journal was reopened.
+func encodeWALRecord(rec walRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode WAL record: %w", err)
+	}
+	b := append(encodeUnsignedInt(uint64(buf.Len())), buf.Bytes()...)
+	return appendChecksum(b, buf.Bytes()), nil
+}
+
+// readWALRecord reads a single length-prefixed record from r. It returns the record and the number of bytes consumed.
+// If r is exhausted before a record starts, io.EOF is returned. If r is exhausted in the middle of a record,
+// io.ErrUnexpectedEOF is returned.
+//
+// Records in segments written before checksums were introduced are read with checksummed set to false. If the
+// checksum of a record does not match, errChecksumMismatch is returned along with as much of the record as could be
+// decoded, which may be used to identify the record.
+func readWALRecord(r *bufio.Reader, checksummed bool) (walRecord, int, error) {
+	var rec walRecord
+	size, n, err := readUnsignedInt(r)
+	if err != nil {
+		return rec, n, err
+	}
+	want := int64(size)
+	if checksummed {
+		want += checksumSize
+	}
+	// the record is copied rather than read into a buffer of the given size, as a corrupted size could be arbitrarily
+	// large
+	var buf bytes.Buffer
+	read, err := io.CopyN(&buf, r, want)
+	n += int(read)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return rec, n, err
+	}
+	rec, err = verifyWALRecord(buf.Bytes(), size, checksummed)
+	return rec, n, err
+}
+
+// decodeWALRecord decodes a single length-prefixed record from the start of b, see readWALRecord. Unlike
+// readWALRecord, the record is decoded in place, which avoids copying records out of memory-mapped segments.
+func decodeWALRecord(b []byte, checksummed bool) (walRecord, int, error) {
+	if len(b) == 0 {
+		return walRecord{}, 0, io.EOF
+	}
+	n := 1
+	if b[0] > 0x7f {
+		n += -int(int8(b[0]))
+	}
+	if len(b) < n {
+		return walRecord{}, len(b), io.ErrUnexpectedEOF
+	}
+	size, err := decodeUnsignedInt(b[:n])
+	if err != nil {
+		return walRecord{}, n, err
+	}
+	// the size is checked before the checksum is added, as a corrupted size could overflow
+	want := size
+	if checksummed && want <= uint64(len(b)-n) {
+		want += checksumSize
+	}
+	if want > uint64(len(b)-n) {
+		return walRecord{}, len(b), io.ErrUnexpectedEOF
+	}
+	rec, err := verifyWALRecord(b[n:n+int(want)], size, checksummed)
+	return rec, n + int(want), err
+}
+
+// verifyWALRecord verifies the gob message of a record against its checksum, which follows the first size bytes of b
+// if checksummed is set, and decodes it.
+func verifyWALRecord(b []byte, size uint64, checksummed bool) (walRecord, error) {
+	var rec walRecord
+	data := b
+	if checksummed {
+		data = b[:size]
+		if !verifyChecksum(data, b[size:]) {
+			_ = gob.NewDecoder(bytes.NewReader(data)).Decode(&rec)
+			return rec, errChecksumMismatch
+		}
+	}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rec)
+	if err != nil {
+		return rec, fmt.Errorf("failed to decode WAL record: %w", err)
+	}
+	return rec, nil
+}
+
+// recordEntryID returns the ID of the log entry a record changes, if any.
+func recordEntryID(rec walRecord) EntryID {
+	if rec.Op == walOpWrite || rec.Op == walOpUpdateEntry || rec.Op == walOpDelete {
+		return rec.ID
+	}
+	return ZeroEntryID
+}
+
+// wal is an append-only journal of the changes made to a [Log]. The journal is replayed when the log is created,
+// restoring the state the log had before it was last closed or the process crashed.
+//
+// The journal is split into segments, see segment, which are named after their base. The base of a segment is one
+// larger than the base of the segment preceding it.
+//
+// Every record is accompanied by a checksum, which is verified when the journal is replayed. A record that fails
+// verification is reported as a [*CorruptionError], unless [WithLogTruncateCorrupted] is set, in which case the
+// journal is truncated to the last record preceding it.
+//
+// When the journal is flushed to stable storage is determined by its [SyncPolicy]. With an interval policy, a
+// background goroutine flushes the journal every interval, and wait can be used to wait for the next flush.
+//
+// Errors are sticky: once appending a record fails, every subsequent append fails with the same error, as the
+// journal no longer reflects the state of the log.
+//
+// All methods of wal are safe to call on a nil *wal, in which case they do nothing. This allows a [Log] without a
+// write-ahead log to call them unconditionally.
+type wal struct {
+	// mut guards the fields of the journal and the segments. Records are only read with mut locked for reading, so
+	// concurrent reads do not block each other, while appends, rolls and the replacement of compacted segments lock
+	// it for writing.
+	mut             sync.RWMutex
+	dir             string
+	segments        []*segment
+	segmentMaxBytes int64
+	segmentMaxAge   time.Duration
+	// truncateCorrupted is set if the journal should be truncated at the first corrupted record when replayed.
+	truncateCorrupted bool
+	syncPolicy        SyncPolicy
+	// appended is the number of records appended to the journal, and synced the number of those records known to be
+	// flushed to stable storage. synchronized is broadcast whenever synced changes or an error occurs.
+	appended     uint64
+	synced       uint64
+	synchronized *sync.Cond
+	// dirDirty is set if a segment was created since the directory was last flushed to stable storage.
+	dirDirty bool
+	// updates maps the ID of every log entry updated since the last walOpReset record to the position of the record
+	// holding its latest update, deleted maps the ID of every log entry deleted since the last walOpReset record to
+	// the position of the walOpDelete record, producers maps the ID of every producer whose deduplication state was
+	// journaled since the last walOpReset record to the position of the latest walOpSetProducer record, and resetAt
+	// is the position of the last walOpReset record. They are used to determine which records are superseded, see
+	// compact.
+	updates   map[EntryID]walPosition
+	deleted   map[EntryID]walPosition
+	producers map[string]walPosition
+	resetAt   walPosition
+	// continued is set if the last record appended to the journal is followed by more records of the same batch. The
+	// active segment is not rolled in the middle of a batch, so sealed segments only hold complete batches.
+	continued bool
+	// compactionBytesPerSecond limits the rate at which compact rewrites segments. Zero means unlimited.
+	compactionBytesPerSecond int64
+	// mmap is set if sealed segments are mapped into memory. Mapped segments are only read with mut locked for
+	// reading, as compact replaces their mappings with mut locked.
+	mmap bool
+	// stop is closed when the journal is closed, stopping the goroutines in background.
+	stop       chan struct{}
+	stopped    bool
+	background sync.WaitGroup
+	err        error
+	// closed is set once the journal has been closed, and closeErr holds the error close returned.
+	closed   bool
+	closeErr error
+}
+
+// walPosition is the position of a record in the journal.
+type walPosition struct {
+	base   uint64
+	offset int64
+}
+
+// openWAL opens the journal kept in the directory configured in opts, creating the directory if it does not exist.
+func openWAL(opts logOptions) (*wal, error) {
+	dir := opts.WALDir
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WAL directory: %w", err)
+	}
+	bases, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		// journals written before the introduction of segments consist of a single file, which becomes the first
+		// segment
+		err = os.Rename(filepath.Join(dir, walFileName), segmentPath(dir, 0, segmentExt))
+		if err == nil {
+			bases = append(bases, 0)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to migrate WAL: %w", err)
+		}
+	}
+
+	w := &wal{
+		dir:             dir,
+		segmentMaxBytes: opts.SegmentMaxBytes,
+		segmentMaxAge:   opts.SegmentMaxAge,
+
+		truncateCorrupted: opts.TruncateCorrupted,
+		syncPolicy:        opts.SyncPolicy,
+		updates:           make(map[EntryID]walPosition),
+		deleted:           make(map[EntryID]walPosition),
+		producers:         make(map[string]walPosition),
+
+		compactionBytesPerSecond: opts.CompactionBytesPerSecond,
+		mmap:                     opts.Mmap,
+		stop:                     make(chan struct{}),
+	}
+	w.synchronized = sync.NewCond(&w.mut)
+	err = removeCompactionFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, base := range bases {
+		s, err := openSegment(dir, base, i < len(bases)-1)
+		if err == nil && w.mmap {
+			err = s.mmap()
+		}
+		if s != nil {
+			w.segments = append(w.segments, s)
+		}
+		if err != nil {
+			_ = w.closeSegments()
+			return nil, err
+		}
+	}
+	if len(w.segments) == 0 {
+		s, err := createSegment(dir, 0)
+		if err != nil {
+			return nil, err
+		}
+		w.segments = append(w.segments, s)
+		w.dirDirty = true
+	}
+	if w.syncPolicy.interval > 0 {
+		w.background.Add(1)
+		go w.syncEvery(w.syncPolicy.interval)
+	}
+	return w, nil
+}
+
+// active returns the segment records are appended to.
+func (w *wal) active() *segment {
+	return w.segments[len(w.segments)-1]
+}
+
+// replay calls fn for every record in the journal, in the order they were appended. A record that was only partially
+// written to the active segment, which happens if the process crashed in the middle of an append, is discarded and
+// the segment is truncated to the last complete record. Likewise, the records of a batch are only passed to fn once
+// the whole batch has been read, and a batch that was only partially appended is discarded.
+//
+// If a record fails verification against its checksum, replay stops and returns a [*CorruptionError]. If the journal
+// was opened with truncateCorrupted set, the journal is instead truncated to the last record preceding the corrupted
+// record, and replay returns without error.
+func (w *wal) replay(fn func(rec walRecord) error) error {
+	if w == nil {
+		return nil
+	}
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	// the records of a batch are held back in batch until its last record is read, and batchAt is the position of
+	// the first record of the batch
+	type batchedRecord struct {
+		s      *segment
+		rec    walRecord
+		offset int64
+	}
+	var batch []batchedRecord
+	var batchAt int
+	var last EntryID
+	for i, s := range w.segments {
+		err := s.replay(func(rec walRecord, offset int64) error {
+			if len(batch) == 0 {
+				batchAt = i
+			}
+			batch = append(batch, batchedRecord{s: s, rec: rec, offset: offset})
+			if rec.Continued {
+				return nil
+			}
+			for _, b := range batch {
+				err := fn(b.rec)
+				if err != nil {
+					return err
+				}
+				if b.rec.Op == walOpWrite {
+					last = b.rec.ID
+				}
+				w.track(b.s, b.rec, b.offset)
+			}
+			batch = batch[:0]
+			return nil
+		})
+		var cerr *corruptRecordError
+		if errors.As(err, &cerr) {
+			if w.truncateCorrupted {
+				if len(batch) > 0 {
+					return w.truncate(batchAt, batch[0].offset)
+				}
+				return w.truncate(i, cerr.offset)
+			}
+			err = &CorruptionError{
+				ID:        cerr.id,
+				LastValid: last,
+				Source:    fmt.Sprintf("WAL segment %d at offset %d", s.base, cerr.offset),
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay WAL: %w", err)
+		}
+	}
+	if len(batch) > 0 {
+		// the process crashed in the middle of appending the batch
+		return w.truncate(batchAt, batch[0].offset)
+	}
+	return nil
+}
+
+// truncate discards everything in the journal from the given offset of the segment at index i onwards, making the
+// segment the active segment. It should be called with mut locked.
+func (w *wal) truncate(i int, offset int64) error {
+	for _, s := range w.segments[i+1:] {
+		err := s.remove()
+		if err != nil {
+			return fmt.Errorf("failed to truncate WAL: %w", err)
+		}
+	}
+	w.segments = w.segments[:i+1]
+	s := w.segments[i]
+	if s.sealed {
+		err := s.unseal()
+		if err != nil {
+			return fmt.Errorf("failed to truncate WAL: %w", err)
+		}
+	}
+	err := s.truncate(offset)
+	if err != nil {
+		return fmt.Errorf("failed to truncate WAL: %w", err)
+	}
+	return nil
+}
+
+// append appends rec to the journal. If the active segment is full, it is sealed and rec is appended to a new
+// segment. If the journal is configured with [SyncAlways], the journal is flushed to stable storage before append
+// returns.
+func (w *wal) append(rec walRecord) error {
+	if w == nil {
+		return nil
+	}
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	err := w.appendLocked(rec)
+	if err != nil {
+		return err
+	}
+	if w.syncPolicy.always {
+		return w.syncLocked()
+	}
+	return nil
+}
+
+// appendBatch calls fn with a function appending a record to the journal. No other records are appended to the
+// journal until fn returns, and if the journal is configured with [SyncAlways], it is flushed to stable storage once
+// fn has returned, rather than after every record.
+func (w *wal) appendBatch(fn func(add func(rec walRecord) error) error) error {
+	if w == nil {
+		return fn(func(walRecord) error {
+			return nil
+		})
+	}
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	err := fn(w.appendLocked)
+	if err != nil {
+		return err
+	}
+	if w.syncPolicy.always {
+		return w.syncLocked()
+	}
+	return nil
+}
+
+// appendLocked appends rec to the journal. It should be called with mut locked.
+func (w *wal) appendLocked(rec walRecord) error {
+	if w.err != nil {
+		return w.err
+	}
+	b, err := encodeWALRecord(rec)
+	if err != nil {
+		return err
+	}
+	// a reset starts a new segment, so the IDs of the log entries written to a segment are always ascending, which
+	// the sparse index of the segment relies on
+	active := w.active()
+	full := active.full(w.segmentMaxBytes, w.segmentMaxAge) || (rec.Op == walOpReset && active.records > 0)
+	if full && !w.continued {
+		err = w.roll()
+		if err != nil {
+			w.fail(fmt.Errorf("failed to roll WAL segment: %w", err))
+			return w.err
+		}
+	}
+	active = w.active()
+	offset := active.size
+	err = active.append(b, rec)
+	if err != nil {
+		w.fail(fmt.Errorf("failed to append to WAL: %w", err))
+		return w.err
+	}
+	w.appended++
+	w.continued = rec.Continued
+	w.track(active, rec, offset)
+	return nil
+}
+
+// track keeps count of the records in segment s, and of those that are superseded by rec, which was appended to s at
+// offset. It should be called with mut locked.
+func (w *wal) track(s *segment, rec walRecord, offset int64) {
+	s.records++
+	switch rec.Op {
+	case walOpUpdateEntry:
+		// the update supersedes the previous update of the log entry, or the payload the log entry was written with
+		if prev, ok := w.updates[rec.ID]; ok {
+			if ps := w.segment(prev.base); ps != nil {
+				ps.garbage++
+			}
+		} else if ws := w.segmentOf(rec.ID); ws != nil {
+			ws.garbage++
+		}
+		w.updates[rec.ID] = walPosition{base: s.base, offset: offset}
+	case walOpDelete:
+		// the deletion supersedes the record writing the log entry and its latest update
+		if ws := w.segmentOf(rec.ID); ws != nil {
+			ws.garbage++
+		}
+		if prev, ok := w.updates[rec.ID]; ok {
+			if ps := w.segment(prev.base); ps != nil {
+				ps.garbage++
+			}
+			delete(w.updates, rec.ID)
+		}
+		w.deleted[rec.ID] = walPosition{base: s.base, offset: offset}
+	case walOpSetProducer:
+		// the deduplication state supersedes the previously journaled deduplication state of the producer
+		if prev, ok := w.producers[rec.Producer]; ok {
+			if ps := w.segment(prev.base); ps != nil {
+				ps.garbage++
+			}
+		}
+		w.producers[rec.Producer] = walPosition{base: s.base, offset: offset}
+	case walOpReset:
+		// the reset supersedes every record preceding it
+		clear(w.updates)
+		clear(w.deleted)
+		clear(w.producers)
+		for _, o := range w.segments {
+			o.garbage = o.records
+		}
+		s.garbage = s.records - 1
+		w.resetAt = walPosition{base: s.base, offset: offset}
+	}
+}
+
+// segment returns the segment with the given base, or nil if no such segment exists.
+func (w *wal) segment(base uint64) *segment {
+	i := sort.Search(len(w.segments), func(i int) bool {
+		return w.segments[i].base >= base
+	})
+	if i < len(w.segments) && w.segments[i].base == base {
+		return w.segments[i]
+	}
+	return nil
+}
+
+// segmentOf returns the segment the log entry with the given ID was written to, or nil if no such segment exists.
+func (w *wal) segmentOf(id EntryID) *segment {
+	for i := len(w.segments) - 1; i >= 0; i-- {
+		first, ok := w.segments[i].firstID()
+		if ok && first.Compare(id) <= 0 {
+			return w.segments[i]
+		}
+	}
+	return nil
+}
+
+// fail makes err the sticky error of the journal, and wakes up anyone waiting for the journal to be flushed. It should
+// be called with mut locked.
+func (w *wal) fail(err error) {
+	w.err = err
+	w.synchronized.Broadcast()
+}
+
+// sync flushes the journal to stable storage.
+func (w *wal) sync() error {
+	if w == nil {
+		return nil
+	}
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.syncLocked()
+}
+
+// syncLocked flushes the active segment, and the directory if a segment was created since it was last flushed, to
+// stable storage. Sealed segments were flushed when they were sealed. It should be called with mut locked.
+func (w *wal) syncLocked() error {
+	if w.err != nil {
+		return w.err
+	}
+	err := w.active().file.Sync()
+	if err == nil && w.dirDirty {
+		err = syncDir(w.dir)
+		w.dirDirty = err != nil
+	}
+	if err != nil {
+		w.fail(fmt.Errorf("failed to sync WAL: %w", err))
+		return w.err
+	}
+	w.synced = w.appended
+	w.synchronized.Broadcast()
+	return nil
+}
+
+// syncDir flushes the directory entries of dir to stable storage, making the creation of files in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	return errors.Join(err, d.Close())
+}
+
+// syncEvery flushes the journal every interval, if records were appended since it was last flushed, until the journal
+// is closed.
+func (w *wal) syncEvery(interval time.Duration) {
+	defer w.background.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+			w.mut.Lock()
+			if w.synced < w.appended {
+				_ = w.syncLocked()
+			}
+			w.mut.Unlock()
+		}
+	}
+}
+
+// wait waits until every record appended to the journal before wait was called has been flushed to stable storage.
+// If the journal fails before that happens, the error is returned. Unless the journal is flushed at an interval, wait
+// returns immediately, as records are either flushed as they are appended or left to the operating system.
+func (w *wal) wait() error {
+	if w == nil || w.syncPolicy.interval <= 0 {
+		return nil
+	}
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	appended := w.appended
+	for w.synced < appended {
+		if w.err != nil {
+			return w.err
+		}
+		w.synchronized.Wait()
+	}
+	return nil
+}
+
+// roll seals the active segment and creates a new one. It should be called with mut locked.
+func (w *wal) roll() error {
+	active := w.active()
+	err := active.seal()
+	if err != nil {
+		return err
+	}
+	if w.mmap {
+		err = active.mmap()
+		if err != nil {
+			return err
+		}
+	}
+	s, err := createSegment(w.dir, active.base+1)
+	if err != nil {
+		return err
+	}
+	w.segments = append(w.segments, s)
+	w.dirDirty = true
+	return nil
+}
+
+// lookup returns the record that wrote the log entry with the given ID, using the sparse indexes of the segments. If
+// no such record exists, [ErrNoSuchEntry] is returned.
+//
+// The returned record holds the payload the log entry was written with, and does not reflect later updates. Once the
+// segment holding the record has been compacted, the payload is either the latest payload of the log entry or nil,
+// see compact.
+func (w *wal) lookup(id EntryID) (walRecord, error) {
+	if w == nil {
+		return walRecord{}, fmt.Errorf("%w: %s", ErrNoSuchEntry, id)
+	}
+	w.mut.RLock()
+	defer w.mut.RUnlock()
+	return w.lookupLocked(id)
+}
+
+// lookupLocked is like lookup, but should be called with mut locked, at least for reading.
+func (w *wal) lookupLocked(id EntryID) (walRecord, error) {
+	s := w.segmentOf(id)
+	if s == nil {
+		return walRecord{}, fmt.Errorf("%w: %s", ErrNoSuchEntry, id)
+	}
+	return s.lookup(id)
+}
+
+// payload returns the latest payload of the log entry with the given ID, read from the record holding the latest
+// update of the log entry, if any, and otherwise from the record writing the log entry. It is used to read payloads
+// that are not kept in memory, see [WithLogMmap]. Reads of payloads do not block each other.
+func (w *wal) payload(id EntryID) (any, error) {
+	if w == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchEntry, id)
+	}
+	w.mut.RLock()
+	defer w.mut.RUnlock()
+
+	if pos, ok := w.updates[id]; ok {
+		s := w.segment(pos.base)
+		if s == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchEntry, id)
+		}
+		rec, err := s.readAt(pos.offset)
+		return rec.Payload, err
+	}
+	rec, err := w.lookupLocked(id)
+	return rec.Payload, err
+}
+
+// Err returns the error that caused the journal to stop accepting records, if any.
+func (w *wal) Err() error {
+	if w == nil {
+		return nil
+	}
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.err
+}
+
+// close flushes the journal to stable storage and closes it. Any error that previously caused an append to fail is
+// returned. Closing a closed journal does nothing, and returns the same error as the first close.
+func (w *wal) close() error {
+	if w == nil {
+		return nil
+	}
+	w.mut.Lock()
+	if w.closed {
+		defer w.mut.Unlock()
+		return w.closeErr
+	}
+	if !w.stopped {
+		close(w.stop)
+		w.stopped = true
+	}
+	w.mut.Unlock()
+	w.background.Wait()
+
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	if w.err == nil {
+		_ = w.syncLocked()
+	}
+	err := w.closeSegments()
+	w.closed = true
+	switch {
+	case w.err != nil:
+		w.closeErr = w.err
+	case err != nil:
+		w.closeErr = fmt.Errorf("failed to close WAL: %w", err)
+	}
+	if w.err == nil {
+		w.fail(fmt.Errorf("WAL is closed"))
+	}
+	return w.closeErr
+}
+
+func (w *wal) closeSegments() error {
+	var errs []error
+	for _, s := range w.segments {
+		errs = append(errs, s.close())
+	}
+	return errors.Join(errs...)
+}
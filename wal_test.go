@@ -0,0 +1,519 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeWALRecord_roundtrip(t *testing.T) {
+	rec := walRecord{
+		Op:      walOpWrite,
+		ID:      fakeTestEntryID1,
+		Payload: "value",
+	}
+	b, err := encodeWALRecord(rec)
+	require.NoError(t, err)
+
+	got, n, err := readWALRecord(bufio.NewReader(bytes.NewReader(b)), true)
+	require.NoError(t, err)
+	require.Equal(t, len(b), n)
+	require.Equal(t, rec, got)
+}
+
+func TestReadWALRecord_partial(t *testing.T) {
+	b, err := encodeWALRecord(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "value"})
+	require.NoError(t, err)
+
+	_, _, err = readWALRecord(bufio.NewReader(bytes.NewReader(b[:len(b)-1])), true)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, _, err = readWALRecord(bufio.NewReader(bytes.NewReader(nil)), true)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestDecodeWALRecord(t *testing.T) {
+	rec := walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: strings.Repeat("value", 100)}
+	b, err := encodeWALRecord(rec)
+	require.NoError(t, err)
+
+	got, n, err := decodeWALRecord(append(b, 0x01), true)
+	require.NoError(t, err)
+	require.Equal(t, len(b), n)
+	require.Equal(t, rec, got)
+
+	_, _, err = decodeWALRecord(b[:len(b)-1], true)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, _, err = decodeWALRecord(b[:1], true)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, _, err = decodeWALRecord(nil, true)
+	require.ErrorIs(t, err, io.EOF)
+
+	b[len(b)-1] ^= 0xff
+	got, _, err = decodeWALRecord(b, true)
+	require.ErrorIs(t, err, errChecksumMismatch)
+	require.Equal(t, fakeTestEntryID1, got.ID)
+}
+
+// TestWAL_reset_rolls tests that a reset starts a new segment, so the log entries in a segment are always ascending.
+func TestWAL_reset_rolls(t *testing.T) {
+	w, err := openWAL(logOptions{WALDir: t.TempDir()})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	require.NoError(t, w.append(walRecord{Op: walOpReset}))
+	require.Len(t, w.segments, 1)
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID2, Payload: "two"}))
+	require.NoError(t, w.append(walRecord{Op: walOpReset}))
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}))
+	require.Len(t, w.segments, 2)
+	rec, err := w.lookup(fakeTestEntryID1)
+	require.NoError(t, err)
+	require.Equal(t, "one", rec.Payload)
+}
+
+func TestWAL_append_replay(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	recs := []walRecord{
+		{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"},
+		{Op: walOpUpdateEntry, ID: fakeTestEntryID1, Payload: "two"},
+		{Op: walOpRemoveGroup, Group: "group1"},
+	}
+	for _, rec := range recs {
+		require.NoError(t, w.append(rec))
+	}
+	require.NoError(t, w.close())
+
+	w, err = openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	var got []walRecord
+	err = w.replay(func(rec walRecord) error {
+		got = append(got, rec)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, recs, got)
+}
+
+// TestWAL_replay_truncates_partial_record tests that a record that was only partially written, e.g. because the
+// process crashed, is discarded and that subsequent records are appended after the last complete record.
+func TestWAL_replay_truncates_partial_record(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}))
+	require.NoError(t, w.close())
+
+	b, err := encodeWALRecord(walRecord{Op: walOpWrite, ID: fakeTestEntryID2, Payload: "two"})
+	require.NoError(t, err)
+	f, err := os.OpenFile(segmentPath(dir, 0, segmentExt), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write(b[:len(b)/2])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	w, err = openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	n := 0
+	require.NoError(t, w.replay(func(rec walRecord) error {
+		n++
+		return nil
+	}))
+	require.Equal(t, 1, n)
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID3, Payload: "three"}))
+	require.NoError(t, w.close())
+
+	w, err = openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	var ids []EntryID
+	require.NoError(t, w.replay(func(rec walRecord) error {
+		ids = append(ids, rec.ID)
+		return nil
+	}))
+	require.Equal(t, []EntryID{fakeTestEntryID1, fakeTestEntryID3}, ids)
+}
+
+// TestWAL_replay_discards_partial_batch tests that a batch whose last record was never appended, e.g. because the
+// process crashed, is discarded in its entirety.
+func TestWAL_replay_discards_partial_batch(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}))
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID2, Payload: "two", Continued: true}))
+	require.NoError(t, w.close())
+
+	w, err = openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	var ids []EntryID
+	require.NoError(t, w.replay(func(rec walRecord) error {
+		ids = append(ids, rec.ID)
+		return nil
+	}))
+	require.Equal(t, []EntryID{fakeTestEntryID1}, ids)
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID3, Payload: "three"}))
+	require.NoError(t, w.close())
+
+	w, err = openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	ids = nil
+	require.NoError(t, w.replay(func(rec walRecord) error {
+		ids = append(ids, rec.ID)
+		return nil
+	}))
+	require.Equal(t, []EntryID{fakeTestEntryID1, fakeTestEntryID3}, ids)
+}
+
+// TestWAL_batch_not_rolled tests that the active segment is not rolled in the middle of a batch.
+func TestWAL_batch_not_rolled(t *testing.T) {
+	w, err := openWAL(logOptions{WALDir: t.TempDir(), SegmentMaxBytes: 1})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	require.NoError(t, w.appendBatch(func(add func(rec walRecord) error) error {
+		for i, id := range []EntryID{fakeTestEntryID1, fakeTestEntryID2, fakeTestEntryID3} {
+			err := add(walRecord{Op: walOpWrite, ID: id, Continued: i < 2})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.Len(t, w.segments, 1)
+	require.NoError(t, w.append(walRecord{Op: walOpRemoveGroup, Group: "group1"}))
+	require.Len(t, w.segments, 2)
+}
+
+func TestWAL_append_error_is_sticky(t *testing.T) {
+	w, err := openWAL(logOptions{WALDir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, w.active().file.Close())
+
+	err = w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"})
+	require.Error(t, err)
+	require.Equal(t, err, w.Err())
+	require.Equal(t, err, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID2, Payload: "two"}))
+}
+
+func TestWAL_nil(t *testing.T) {
+	var w *wal
+	require.NoError(t, w.append(walRecord{Op: walOpWrite}))
+	require.NoError(t, w.replay(func(rec walRecord) error {
+		return nil
+	}))
+	require.NoError(t, w.Err())
+	require.NoError(t, w.close())
+}
+
+// TestWAL_roll tests that segments are sealed once they are full, and that records are replayed in order across
+// segments.
+func TestWAL_roll(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(logOptions{WALDir: dir, SegmentMaxBytes: 1})
+	require.NoError(t, err)
+	ids := []EntryID{fakeTestEntryID1, fakeTestEntryID2, fakeTestEntryID3}
+	for _, id := range ids {
+		require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: id, Payload: id.String()}))
+	}
+	require.Len(t, w.segments, 3)
+	require.True(t, w.segments[0].sealed)
+	require.True(t, w.segments[1].sealed)
+	require.False(t, w.segments[2].sealed)
+
+	rec, err := w.lookup(fakeTestEntryID2)
+	require.NoError(t, err)
+	require.Equal(t, fakeTestEntryID2.String(), rec.Payload)
+	_, err = w.lookup(StartFromBeginning)
+	require.ErrorIs(t, err, ErrNoSuchEntry)
+	require.NoError(t, w.close())
+
+	w, err = openWAL(logOptions{WALDir: dir, SegmentMaxBytes: 1})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	var got []EntryID
+	require.NoError(t, w.replay(func(rec walRecord) error {
+		got = append(got, rec.ID)
+		return nil
+	}))
+	require.Equal(t, ids, got)
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: NewEntryID(fakeTestEntryID3.time, 2)}))
+	require.Len(t, w.segments, 4)
+}
+
+// TestOpenWAL_migrates_single_file tests that a journal written before the introduction of segments becomes the first
+// segment.
+func TestOpenWAL_migrates_single_file(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, walFileName), encodeLegacyWALRecord(t, walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}), 0o644))
+
+	w, err := openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	var got []EntryID
+	require.NoError(t, w.replay(func(rec walRecord) error {
+		got = append(got, rec.ID)
+		return nil
+	}))
+	require.Equal(t, []EntryID{fakeTestEntryID1}, got)
+	require.NoFileExists(t, filepath.Join(dir, walFileName))
+}
+
+// encodeLegacyWALRecord encodes a walRecord as it was encoded before checksums were introduced.
+func encodeLegacyWALRecord(t *testing.T, rec walRecord) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(rec))
+	return append(encodeUnsignedInt(uint64(buf.Len())), buf.Bytes()...)
+}
+
+// TestWAL_legacy_segment tests that segments written before checksums were introduced can still be replayed, and
+// that records are appended to a new segment rather than to the legacy segment.
+func TestWAL_legacy_segment(t *testing.T) {
+	dir := t.TempDir()
+	b := encodeLegacyWALRecord(t, walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"})
+	b = append(b, encodeLegacyWALRecord(t, walRecord{Op: walOpWrite, ID: fakeTestEntryID2, Payload: "two"})...)
+	require.NoError(t, os.WriteFile(segmentPath(dir, 0, segmentExt), b, 0o644))
+
+	w, err := openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	require.False(t, w.active().checksummed)
+	var got []EntryID
+	require.NoError(t, w.replay(func(rec walRecord) error {
+		got = append(got, rec.ID)
+		return nil
+	}))
+	require.Equal(t, []EntryID{fakeTestEntryID1, fakeTestEntryID2}, got)
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID3, Payload: "three"}))
+	require.Len(t, w.segments, 2)
+	require.True(t, w.segments[0].sealed)
+	require.True(t, w.active().checksummed)
+	rec, err := w.lookup(fakeTestEntryID2)
+	require.NoError(t, err)
+	require.Equal(t, "two", rec.Payload)
+	require.NoError(t, w.close())
+}
+
+func TestReadWALRecord_checksum_mismatch(t *testing.T) {
+	b, err := encodeWALRecord(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "value"})
+	require.NoError(t, err)
+	b[len(b)-1] ^= 0xff
+
+	rec, _, err := readWALRecord(bufio.NewReader(bytes.NewReader(b)), true)
+	require.ErrorIs(t, err, errChecksumMismatch)
+	require.Equal(t, fakeTestEntryID1, rec.ID)
+}
+
+// corruptWAL writes three log entries to a WAL in dir, each in its own segment, and flips a bit in the payload of
+// the second.
+func corruptWAL(t *testing.T, dir string) {
+	w, err := openWAL(logOptions{WALDir: dir, SegmentMaxBytes: 1})
+	require.NoError(t, err)
+	for _, id := range []EntryID{fakeTestEntryID1, fakeTestEntryID2, fakeTestEntryID3} {
+		require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: id, Payload: "payload"}))
+	}
+	require.NoError(t, w.close())
+
+	path := segmentPath(dir, 1, segmentExt)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	i := bytes.LastIndex(b, []byte("payload"))
+	require.Positive(t, i)
+	b[i] ^= 0x01
+	require.NoError(t, os.Chmod(path, 0o644))
+	require.NoError(t, os.WriteFile(path, b, 0o444))
+}
+
+func TestWAL_replay_corrupted(t *testing.T) {
+	dir := t.TempDir()
+	corruptWAL(t, dir)
+
+	w, err := openWAL(logOptions{WALDir: dir, SegmentMaxBytes: 1})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	err = w.replay(func(rec walRecord) error {
+		return nil
+	})
+	require.ErrorIs(t, err, ErrCorrupted)
+	var cerr *CorruptionError
+	require.ErrorAs(t, err, &cerr)
+	require.Equal(t, fakeTestEntryID2, cerr.ID)
+	require.Equal(t, fakeTestEntryID1, cerr.LastValid)
+}
+
+// TestWAL_replay_truncates_corrupted tests that the journal is truncated to the last record preceding a corrupted
+// record, and that records can be appended after it.
+func TestWAL_replay_truncates_corrupted(t *testing.T) {
+	dir := t.TempDir()
+	corruptWAL(t, dir)
+
+	w, err := openWAL(logOptions{WALDir: dir, SegmentMaxBytes: 1, TruncateCorrupted: true})
+	require.NoError(t, err)
+	var got []EntryID
+	require.NoError(t, w.replay(func(rec walRecord) error {
+		got = append(got, rec.ID)
+		return nil
+	}))
+	require.Equal(t, []EntryID{fakeTestEntryID1}, got)
+	require.Len(t, w.segments, 2)
+	require.False(t, w.active().sealed)
+	require.NoFileExists(t, segmentPath(dir, 2, segmentExt))
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID3, Payload: "three"}))
+	require.NoError(t, w.close())
+
+	w, err = openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	got = nil
+	require.NoError(t, w.replay(func(rec walRecord) error {
+		got = append(got, rec.ID)
+		return nil
+	}))
+	require.Equal(t, []EntryID{fakeTestEntryID1, fakeTestEntryID3}, got)
+}
+
+func TestWAL_sync_always(t *testing.T) {
+	w, err := openWAL(logOptions{WALDir: t.TempDir(), SyncPolicy: SyncAlways})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}))
+	require.Equal(t, uint64(1), w.synced)
+	require.False(t, w.dirDirty)
+}
+
+func TestWAL_sync_none(t *testing.T) {
+	w, err := openWAL(logOptions{WALDir: t.TempDir()})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}))
+	require.NoError(t, w.wait())
+	require.Equal(t, uint64(0), w.synced)
+	require.NoError(t, w.sync())
+	require.Equal(t, uint64(1), w.synced)
+}
+
+// TestWAL_sync_interval tests that wait returns once the records appended before it was called have been flushed by
+// the background goroutine.
+func TestWAL_sync_interval(t *testing.T) {
+	w, err := openWAL(logOptions{WALDir: t.TempDir(), SyncPolicy: SyncInterval(5 * time.Millisecond)})
+	require.NoError(t, err)
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}))
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID2, Payload: "two"}))
+	require.NoError(t, w.wait())
+	w.mut.Lock()
+	require.Equal(t, uint64(2), w.synced)
+	w.mut.Unlock()
+	require.NoError(t, w.close())
+	_, ok := <-w.stop
+	require.False(t, ok)
+}
+
+func TestWAL_wait_error(t *testing.T) {
+	w, err := openWAL(logOptions{WALDir: t.TempDir(), SyncPolicy: SyncInterval(time.Hour)})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}))
+	go func() {
+		w.mut.Lock()
+		defer w.mut.Unlock()
+		w.fail(errors.New("failed"))
+	}()
+	require.ErrorContains(t, w.wait(), "failed")
+}
+
+func TestWAL_appendBatch(t *testing.T) {
+	w, err := openWAL(logOptions{WALDir: t.TempDir(), SyncPolicy: SyncAlways})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	require.NoError(t, w.appendBatch(func(add func(rec walRecord) error) error {
+		for _, id := range []EntryID{fakeTestEntryID1, fakeTestEntryID2} {
+			err := add(walRecord{Op: walOpWrite, ID: id})
+			if err != nil {
+				return err
+			}
+		}
+		// the batch is flushed once it is complete
+		require.Equal(t, uint64(0), w.synced)
+		return nil
+	}))
+	require.Equal(t, uint64(2), w.synced)
+
+	var nilWAL *wal
+	n := 0
+	require.NoError(t, nilWAL.appendBatch(func(add func(rec walRecord) error) error {
+		n++
+		return add(walRecord{Op: walOpWrite})
+	}))
+	require.Equal(t, 1, n)
+}
+
+// TestWAL_payload_concurrent_reads tests that payloads are read from both sealed and active segments while mut is
+// locked for reading by someone else, so reads do not block each other.
+func TestWAL_payload_concurrent_reads(t *testing.T) {
+	w, err := openWAL(logOptions{WALDir: t.TempDir(), SegmentMaxBytes: 1, Mmap: true})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID1, Payload: "one"}))
+	require.NoError(t, w.append(walRecord{Op: walOpWrite, ID: fakeTestEntryID2, Payload: "two"}))
+	require.Len(t, w.segments, 2)
+
+	w.mut.RLock()
+	defer w.mut.RUnlock()
+	payloads := make(chan any)
+	go func() {
+		for _, id := range []EntryID{fakeTestEntryID1, fakeTestEntryID2} {
+			payload, err := w.payload(id)
+			if err != nil {
+				payload = err
+			}
+			payloads <- payload
+		}
+	}()
+	for _, want := range []any{"one", "two"} {
+		select {
+		case got := <-payloads:
+			require.Equal(t, want, got)
+		case <-time.After(time.Second):
+			require.FailNow(t, "payload blocked on mut locked for reading")
+		}
+	}
+}
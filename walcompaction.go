@@ -0,0 +1,490 @@
+package historitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// compactionExt is the file extension of the file a segment is rewritten to while it is being compacted.
+const compactionExt = ".compact"
+
+// compactionGarbageRatio is the fraction of the records of a sealed segment that must be superseded before the
+// segment is compacted by the background compactor.
+const compactionGarbageRatio = 0.25
+
+var errCompactionStopped = fmt.Errorf("compaction stopped")
+
+// CompactionStats describes the work done compacting the log. It is returned by [Log.CompactionStats].
+type CompactionStats struct {
+	// Runs is the number of times the log was compacted, whether started by the background compactor or by
+	// [Log.Compact].
+	Runs int
+	// EntriesRemoved is the number of log entries removed as they were superseded by a later log entry with the same
+	// key, see [WithLogKeyCompaction].
+	EntriesRemoved int
+	// SegmentsCompacted is the number of segments of the write-ahead log rewritten, and SegmentsRemoved the number of
+	// segments removed as every record in them was superseded.
+	SegmentsCompacted int
+	SegmentsRemoved   int
+	// RecordsRemoved is the number of superseded records removed from the write-ahead log.
+	RecordsRemoved int
+	// BytesReclaimed is the number of bytes the write-ahead log shrunk by.
+	BytesReclaimed int64
+	// LastRun is when the log was last compacted, and LastDuration how long it took.
+	LastRun      time.Time
+	LastDuration time.Duration
+	// LastError is the error that stopped the last compaction, if any.
+	LastError error
+}
+
+// removeCompactionFiles removes files left behind by a compaction that was interrupted, e.g. by the process crashing.
+func removeCompactionFiles(dir string) error {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list WAL directory: %w", err)
+	}
+	for _, de := range des {
+		if !strings.HasSuffix(de.Name(), compactionExt) {
+			continue
+		}
+		err = os.Remove(filepath.Join(dir, de.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to remove interrupted compaction: %w", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the sealed segments of the journal holding superseded records, without the superseded records, and
+// adds the work done to stats. If force is not set, only segments where at least compactionGarbageRatio of the
+// records are superseded are compacted. If any segment is compacted, journal is called first, with mut unlocked, so
+// the log can journal state that cannot be restored once records are removed, see [Log.journalProducers]. Calls to
+// compact must not overlap.
+//
+// A record is superseded if it precedes the last walOpReset record, if it updates a log entry that is updated again
+// later, or if it writes or updates a log entry that is deleted later. The payload of the record writing a log entry
+// is superseded by the first update of the log entry. When a log entry and its latest update are in the same segment,
+// the latest payload is moved to the record writing the log entry and the update is removed. When the latest update
+// is in a later segment, the payload of the record writing the log entry is removed. A walOpDelete record is
+// superseded once the record writing the log entry has been removed. A walOpSetProducer record is superseded by the
+// next walOpSetProducer record of the same producer. Records changing Consumer groups are never superseded, so the
+// references Consumer groups hold to log entries remain valid.
+//
+// The active segment is never compacted, and sealed segments are rewritten to a separate file which replaces the
+// segment once it is complete, so compact only holds mut while deciding which records to keep and while replacing
+// segments, and does not block appends otherwise.
+func (w *wal) compact(force bool, stats *CompactionStats, journal func() error) error {
+	if w == nil {
+		return nil
+	}
+	w.mut.Lock()
+	if w.stopped {
+		w.mut.Unlock()
+		return fmt.Errorf("WAL is closed")
+	}
+	w.background.Add(1)
+	defer w.background.Done()
+	candidates := w.candidates(force)
+	w.mut.Unlock()
+
+	if len(candidates) > 0 && journal != nil {
+		err := journal()
+		if err != nil {
+			return err
+		}
+		// the records journaled may supersede records in the sealed segments
+		w.mut.Lock()
+		candidates = w.candidates(force)
+		w.mut.Unlock()
+	}
+	for _, s := range candidates {
+		err := w.compactSegment(s, stats)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// candidates returns the sealed segments to compact, see compact. It should be called with mut locked.
+func (w *wal) candidates(force bool) []*segment {
+	var candidates []*segment
+	for _, s := range w.segments[:len(w.segments)-1] {
+		if s.garbage == 0 {
+			continue
+		}
+		if force || float64(s.garbage) >= float64(s.records)*compactionGarbageRatio {
+			candidates = append(candidates, s)
+		}
+	}
+	return candidates
+}
+
+// compactSegment rewrites the sealed segment s without its superseded records, see compact, and adds the work done to
+// stats. It should be called with mut unlocked.
+func (w *wal) compactSegment(s *segment, stats *CompactionStats) error {
+	// first collect the log entries written, updated and deleted in the segment, and the producers whose deduplication
+	// state is journaled in the segment, so their latest updates, deletions and deduplication states can be looked up
+	written := make(map[EntryID]struct{})
+	updated := make(map[EntryID]struct{})
+	deleted := make(map[EntryID]struct{})
+	producers := make(map[string]struct{})
+	err := s.scan(func(rec walRecord, _ int64) error {
+		switch rec.Op {
+		case walOpWrite:
+			written[rec.ID] = struct{}{}
+		case walOpUpdateEntry:
+			updated[rec.ID] = struct{}{}
+		case walOpDelete:
+			deleted[rec.ID] = struct{}{}
+		case walOpSetProducer:
+			producers[rec.Producer] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.mut.Lock()
+	resetAt := w.resetAt
+	garbage := s.garbage
+	latest := make(map[EntryID]walPosition)
+	deletions := make(map[EntryID]walPosition)
+	for _, ids := range []map[EntryID]struct{}{written, updated, deleted} {
+		for id := range ids {
+			if pos, ok := w.updates[id]; ok {
+				latest[id] = pos
+			}
+			if pos, ok := w.deleted[id]; ok {
+				deletions[id] = pos
+			}
+		}
+	}
+	journaled := make(map[string]walPosition)
+	for name := range producers {
+		if pos, ok := w.producers[name]; ok {
+			journaled[name] = pos
+		}
+	}
+	// a deletion is only kept while the record writing the log entry exists, as the log entry would otherwise be
+	// restored when the journal is replayed
+	orphaned := make(map[EntryID]struct{})
+	for id := range deleted {
+		if _, ok := written[id]; ok {
+			continue
+		}
+		ws := w.segmentOf(id)
+		if ws == nil || ws == s {
+			orphaned[id] = struct{}{}
+			continue
+		}
+		_, err = ws.lookup(id)
+		if errors.Is(err, ErrNoSuchEntry) {
+			orphaned[id] = struct{}{}
+		} else if err != nil {
+			w.mut.Unlock()
+			return err
+		}
+	}
+	w.mut.Unlock()
+
+	superseded := func(offset int64) bool {
+		return s.base < resetAt.base || (s.base == resetAt.base && offset < resetAt.offset)
+	}
+
+	// the latest payloads of log entries written in the segment and updated later in the segment are moved to the
+	// records writing them
+	folded := make(map[EntryID]walRecord)
+	for id, pos := range latest {
+		if _, ok := written[id]; !ok || pos.base != s.base || superseded(pos.offset) {
+			continue
+		}
+		rec, err := s.readAt(pos.offset)
+		if err != nil {
+			return err
+		}
+		folded[id] = rec
+	}
+
+	path := strings.TrimSuffix(s.path, segmentExt) + compactionExt
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to compact segment %d: %w", s.base, err)
+	}
+	c := &segment{
+		base:        s.base,
+		path:        path,
+		file:        f,
+		created:     s.created,
+		checksummed: true,
+	}
+	abort := func(err error) error {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return fmt.Errorf("failed to compact segment %d: %w", s.base, err)
+	}
+	err = c.writeHeader()
+	if err != nil {
+		return abort(err)
+	}
+
+	// moved maps the offsets in the segment of the updates and deduplication states that are kept to their offsets in
+	// the compacted segment.
+	// dropped holds the log entries whose records writing them were removed because they were deleted, and
+	// forgotten the log entries whose deletions were removed.
+	moved := make(map[int64]int64)
+	dropped := make(map[EntryID]struct{})
+	forgotten := make(map[EntryID]walPosition)
+	removed := 0
+	throttleStart := time.Now()
+	err = s.scan(func(rec walRecord, offset int64) error {
+		if superseded(offset) {
+			removed++
+			return nil
+		}
+		switch rec.Op {
+		case walOpWrite:
+			if _, ok := deletions[rec.ID]; ok {
+				dropped[rec.ID] = struct{}{}
+				removed++
+				return nil
+			}
+			if update, ok := folded[rec.ID]; ok {
+				rec.Payload = update.Payload
+				rec.Revision = update.Revision
+			} else if _, ok := latest[rec.ID]; ok {
+				rec.Payload = nil
+			}
+		case walOpUpdateEntry:
+			_, wasFolded := folded[rec.ID]
+			_, wasDeleted := deletions[rec.ID]
+			if wasFolded || wasDeleted || latest[rec.ID] != (walPosition{base: s.base, offset: offset}) {
+				removed++
+				return nil
+			}
+			moved[offset] = c.size
+		case walOpDelete:
+			_, wasWritten := written[rec.ID]
+			_, wasOrphaned := orphaned[rec.ID]
+			if wasWritten || wasOrphaned {
+				forgotten[rec.ID] = walPosition{base: s.base, offset: offset}
+				removed++
+				return nil
+			}
+		case walOpSetProducer:
+			if journaled[rec.Producer] != (walPosition{base: s.base, offset: offset}) {
+				removed++
+				return nil
+			}
+			moved[offset] = c.size
+		}
+		// sealed segments only hold complete batches, and the last record of a batch may have been removed
+		rec.Continued = false
+		b, err := encodeWALRecord(rec)
+		if err != nil {
+			return err
+		}
+		err = c.append(b, rec)
+		if err != nil {
+			return err
+		}
+		c.records++
+		return w.throttle(throttleStart, c.size)
+	})
+	if err != nil {
+		return abort(err)
+	}
+	err = c.file.Sync()
+	if err != nil {
+		return abort(err)
+	}
+	err = c.file.Close()
+	if err != nil {
+		return abort(err)
+	}
+
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.stopped {
+		_ = os.Remove(path)
+		return errCompactionStopped
+	}
+	reclaimed := s.size - c.size
+	for id := range dropped {
+		// a deletion in a later segment is superseded once the record writing the log entry is removed
+		if pos, ok := w.deleted[id]; ok && pos.base != s.base {
+			if ds := w.segment(pos.base); ds != nil {
+				ds.garbage++
+			}
+		}
+	}
+	for id, pos := range forgotten {
+		if w.deleted[id] == pos {
+			delete(w.deleted, id)
+		}
+	}
+	if c.records == 0 {
+		_ = os.Remove(path)
+		err = s.remove()
+		if err != nil {
+			return w.failCompaction(s, err)
+		}
+		for i, o := range w.segments {
+			if o == s {
+				w.segments = append(w.segments[:i], w.segments[i+1:]...)
+				break
+			}
+		}
+		stats.SegmentsRemoved++
+		stats.RecordsRemoved += removed
+		stats.BytesReclaimed += reclaimed
+		return nil
+	}
+
+	// the segment is replaced by the compacted segment. The index of the segment is removed first, so a crash before
+	// the new index is written causes the index to be rebuilt rather than an outdated index to be used
+	err = os.Remove(s.indexPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = os.Remove(path)
+		return fmt.Errorf("failed to compact segment %d: %w", s.base, err)
+	}
+	err = os.Chmod(path, 0o444)
+	if err == nil {
+		err = os.Rename(path, s.path)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return w.failCompaction(s, err)
+	}
+	err = s.close()
+	if err != nil {
+		return w.failCompaction(s, err)
+	}
+	s.file, err = os.Open(s.path)
+	if err != nil {
+		return w.failCompaction(s, err)
+	}
+	s.size = c.size
+	s.index = c.index
+	s.checksummed = true
+	s.start = c.start
+	if w.mmap {
+		err = s.mmap()
+		if err != nil {
+			return w.failCompaction(s, err)
+		}
+	}
+	// records superseded while the segment was being compacted are still superseded
+	s.garbage = min(s.garbage-garbage, c.records)
+	if resetAt != w.resetAt {
+		s.garbage = c.records
+	}
+	s.records = c.records
+	err = s.writeIndex()
+	if err != nil {
+		return w.failCompaction(s, err)
+	}
+	err = syncDir(w.dir)
+	if err != nil {
+		return w.failCompaction(s, err)
+	}
+
+	for id, pos := range latest {
+		if w.updates[id] != pos || pos.base != s.base {
+			// the log entry was updated again while the segment was being compacted
+			continue
+		}
+		if _, ok := folded[id]; ok {
+			delete(w.updates, id)
+		} else if offset, ok := moved[pos.offset]; ok {
+			w.updates[id] = walPosition{base: s.base, offset: offset}
+		}
+	}
+	for name, pos := range journaled {
+		if w.producers[name] != pos || pos.base != s.base {
+			// the deduplication state of the producer was journaled again while the segment was being compacted
+			continue
+		}
+		if offset, ok := moved[pos.offset]; ok {
+			w.producers[name] = walPosition{base: s.base, offset: offset}
+		}
+	}
+	stats.SegmentsCompacted++
+	stats.RecordsRemoved += removed
+	stats.BytesReclaimed += reclaimed
+	return nil
+}
+
+// failCompaction fails the journal after the segment s could not be replaced by its compacted version, as the
+// journal can no longer be relied upon. It should be called with mut locked.
+func (w *wal) failCompaction(s *segment, err error) error {
+	w.fail(fmt.Errorf("failed to replace compacted segment %d: %w", s.base, err))
+	return w.err
+}
+
+// throttle sleeps long enough to keep the rate at which a compaction that started at start has written n bytes below
+// the configured limit. If the journal is closed while sleeping, errCompactionStopped is returned.
+func (w *wal) throttle(start time.Time, n int64) error {
+	select {
+	case <-w.stop:
+		return errCompactionStopped
+	default:
+	}
+	if w.compactionBytesPerSecond <= 0 {
+		return nil
+	}
+	ahead := time.Duration(float64(n)/float64(w.compactionBytesPerSecond)*float64(time.Second)) - time.Since(start)
+	if ahead <= 0 {
+		return nil
+	}
+	t := time.NewTimer(ahead)
+	defer t.Stop()
+	select {
+	case <-w.stop:
+		return errCompactionStopped
+	case <-t.C:
+		return nil
+	}
+}
+
+// scan calls fn for every record in the sealed segment s, along with the offset of the record.
+func (s *segment) scan(fn func(rec walRecord, offset int64) error) error {
+	r := bufio.NewReader(io.NewSectionReader(s.file, s.start, s.size-s.start))
+	offset := s.start
+	for {
+		rec, n, err := readWALRecord(r, s.checksummed)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, errChecksumMismatch) {
+			return &CorruptionError{
+				ID:     recordEntryID(rec),
+				Source: fmt.Sprintf("WAL segment %d at offset %d", s.base, offset),
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read segment %d at offset %d: %w", s.base, offset, err)
+		}
+		err = fn(rec, offset)
+		if err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+}
+
+// readAt reads the record at offset in the segment.
+func (s *segment) readAt(offset int64) (walRecord, error) {
+	rec, _, err := s.recordAt(offset)
+	if errors.Is(err, io.EOF) {
+		err = fmt.Errorf("failed to read segment %d at offset %d: %w", s.base, offset, io.ErrUnexpectedEOF)
+	}
+	return rec, err
+}
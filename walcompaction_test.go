@@ -0,0 +1,185 @@
+//go:build !integration
+
+package historitor
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// requirePayloads requires the log entries of l to hold the given payloads, in order.
+func requirePayloads(t *testing.T, l *Log, payloads ...any) {
+	t.Helper()
+	var got []any
+	require.NoError(t, l.entries.Ascend(ZeroEntryID, func(e Entry) bool {
+		got = append(got, e.Payload)
+		return true
+	}))
+	require.Equal(t, payloads, got)
+}
+
+// TestLog_Compact tests that superseded updates are removed from the write-ahead log, and that the payloads of log
+// entries updated in later segments are removed, without changing the state the write-ahead log restores.
+func TestLog_Compact(t *testing.T) {
+	dir := t.TempDir()
+	// every record is appended to its own segment
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogSegmentMaxBytes(1))
+	require.NoError(t, err)
+	id1, err := l.Write("one")
+	require.NoError(t, err)
+	_, err = l.Write("two")
+	require.NoError(t, err)
+	require.True(t, l.UpdateEntry(id1, "one-a"))
+	require.True(t, l.UpdateEntry(id1, "one-b"))
+	// seal the segment holding the latest update
+	_, err = l.Write("three")
+	require.NoError(t, err)
+	require.Len(t, l.wal.segments, 5)
+
+	require.NoError(t, l.Compact())
+	stats := l.CompactionStats()
+	require.Equal(t, 1, stats.Runs)
+	require.Equal(t, 1, stats.SegmentsCompacted)
+	require.Equal(t, 1, stats.SegmentsRemoved)
+	require.Equal(t, 1, stats.RecordsRemoved)
+	require.Positive(t, stats.BytesReclaimed)
+	require.NoError(t, stats.LastError)
+	require.Len(t, l.wal.segments, 4)
+	rec, err := l.wal.lookup(id1)
+	require.NoError(t, err)
+	require.Nil(t, rec.Payload)
+	for _, s := range l.wal.segments {
+		require.Zero(t, s.garbage)
+	}
+
+	// compacting again does nothing
+	require.NoError(t, l.Compact())
+	require.Equal(t, 1, l.CompactionStats().SegmentsCompacted)
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogSegmentMaxBytes(1))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	requirePayloads(t, l2, "one-b", "two", "three")
+}
+
+// TestLog_Compact_fold tests that the latest payload of a log entry updated in the segment it was written to is moved
+// to the record writing the log entry.
+func TestLog_Compact_fold(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	id, err := l.Write("one")
+	require.NoError(t, err)
+	require.True(t, l.UpdateEntry(id, "one-a"))
+	require.True(t, l.UpdateEntry(id, "one-b"))
+	l.wal.mut.Lock()
+	require.NoError(t, l.wal.roll())
+	l.wal.mut.Unlock()
+	require.Equal(t, 2, l.wal.segments[0].garbage)
+
+	require.NoError(t, l.Compact())
+	require.Equal(t, 2, l.CompactionStats().RecordsRemoved)
+	require.Equal(t, 1, l.wal.segments[0].records)
+	require.Zero(t, l.wal.segments[0].garbage)
+	require.NotContains(t, l.wal.updates, id)
+	rec, err := l.wal.lookup(id)
+	require.NoError(t, err)
+	require.Equal(t, "one-b", rec.Payload)
+	require.Equal(t, uint64(2), rec.Revision)
+
+	// the next update supersedes the payload of the record writing the log entry
+	require.True(t, l.UpdateEntry(id, "one-c"))
+	require.Equal(t, 1, l.wal.segments[0].garbage)
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	requirePayloads(t, l2, "one-c")
+	e, ok, err := l2.entries.Search(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(3), e.Revision)
+}
+
+// TestLog_Compact_reset tests that segments preceding the last reset of the write-ahead log are removed, while the
+// Consumer groups and their pending entries are retained.
+func TestLog_Compact_reset(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir), WithLogSegmentMaxBytes(1))
+	require.NoError(t, err)
+	_, err = l.Write("one")
+	require.NoError(t, err)
+	_, err = l.Write("two")
+	require.NoError(t, err)
+	c := NewConsumer(WithConsumerName("consumer1"))
+	require.NoError(t, l.AddGroup(NewConsumerGroup(WithConsumerGroupName("group1"), WithConsumerGroupMember(c))))
+	b, err := l.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, l.UnmarshalBinary(b))
+	entries, err := l.Read("group1", "consumer1", 1)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	require.NoError(t, l.Compact())
+	require.Equal(t, 3, l.CompactionStats().SegmentsRemoved)
+	require.NoError(t, l.Close())
+
+	l2, err := NewLog(WithLogName(t.Name()), WithLogWAL(dir))
+	require.NoError(t, err)
+	defer func() {
+		_ = l2.Close()
+	}()
+	requirePayloads(t, l2, "one", "two")
+	require.Len(t, l2.groups["group1"].ListPendingEntries(), 1)
+}
+
+func TestLog_Compact_background(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()), WithLogWAL(t.TempDir()), WithLogCompactionInterval(time.Millisecond))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return l.CompactionStats().Runs > 0
+	}, time.Second, time.Millisecond)
+	require.NoError(t, l.Close())
+	require.Error(t, l.Compact())
+}
+
+func TestLog_Compact_no_wal(t *testing.T) {
+	l, err := NewLog(WithLogName(t.Name()))
+	require.NoError(t, err)
+	require.NoError(t, l.Compact())
+	require.Equal(t, CompactionStats{}, l.CompactionStats())
+}
+
+func TestWAL_throttle(t *testing.T) {
+	w, err := openWAL(logOptions{WALDir: t.TempDir(), CompactionBytesPerSecond: 1000})
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, w.throttle(start, 10))
+	require.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+	require.NoError(t, w.close())
+	require.ErrorIs(t, w.throttle(time.Now(), 1000), errCompactionStopped)
+}
+
+// TestOpenWAL_removes_compaction_files tests that files left behind by an interrupted compaction are removed.
+func TestOpenWAL_removes_compaction_files(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "00000000000000000000"+compactionExt)
+	require.NoError(t, os.WriteFile(path, []byte("partial"), 0o644))
+
+	w, err := openWAL(logOptions{WALDir: dir})
+	require.NoError(t, err)
+	defer func() {
+		_ = w.close()
+	}()
+	require.NoFileExists(t, path)
+}